@@ -0,0 +1,190 @@
+package main
+
+import (
+	"archive/tar"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+type archiveOptions struct {
+	// excludes are path.Match patterns. Each is matched against both the path relative to the
+	// backed up directory and the base name of every entry.
+	excludes []string
+
+	compression compression
+}
+
+// archiveName returns the file name of the archive produced from path, before the date is
+// substituted in.
+func archiveName(filePath string, options archiveOptions) (string, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return "", fmt.Errorf("stat %s: %w", filePath, err)
+	}
+
+	name := filepath.Base(filePath)
+	if stat.IsDir() {
+		name += ".tar"
+	}
+
+	return name + options.compression.extension() + ".age", nil
+}
+
+// writeArchive writes the file at filePath to w, compressing it if configured. Directories are
+// written as a tar stream.
+func writeArchive(w io.Writer, filePath string, options archiveOptions) error {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return fmt.Errorf("stat %s: %w", filePath, err)
+	}
+
+	cw, err := options.compression.compress(w)
+	if err != nil {
+		return fmt.Errorf("compress: %w", err)
+	}
+
+	if stat.IsDir() {
+		err = writeTar(cw, filePath, options.excludes)
+	} else {
+		err = copyFile(cw, filePath, -1)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := cw.Close(); err != nil {
+		return fmt.Errorf("close compression: %w", err)
+	}
+
+	return nil
+}
+
+// writeTar writes root and everything below it as a tar stream. Entries are named relative to
+// the parent of root, so extracting the archive recreates the directory itself.
+func writeTar(w io.Writer, root string, excludes []string) error {
+	for _, pattern := range excludes {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("exclude pattern %s: %w", pattern, err)
+		}
+	}
+
+	root = filepath.Clean(root)
+	base := filepath.Base(root)
+	tw := tar.NewWriter(w)
+
+	err := filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if rel != "." && isExcluded(rel, excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type()&(fs.ModeSocket|fs.ModeNamedPipe) != 0 {
+			slog.Warn("skipping unsupported file type", "path", filePath)
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		link := ""
+		if d.Type()&fs.ModeSymlink != 0 {
+			link, err = os.Readlink(filePath)
+			if err != nil {
+				return err
+			}
+		}
+
+		// FileInfoHeader keeps the mode, modification time and ownership of the file.
+		header, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return fmt.Errorf("tar header %s: %w", filePath, err)
+		}
+
+		header.Name = path.Join(base, rel)
+		if d.IsDir() {
+			header.Name += "/"
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return fmt.Errorf("write tar header %s: %w", filePath, err)
+		}
+
+		if header.Typeflag == tar.TypeReg {
+			if err := copyFile(tw, filePath, header.Size); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("tar %s: %w", root, err)
+	}
+
+	return tw.Close()
+}
+
+func isExcluded(rel string, excludes []string) bool {
+	for _, pattern := range excludes {
+		if matched, _ := path.Match(pattern, rel); matched {
+			return true
+		}
+		if matched, _ := path.Match(pattern, path.Base(rel)); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// copyFile copies the file at filePath to w. If size is not negative exactly size bytes are
+// copied, as a tar header promising that size has already been written.
+func copyFile(w io.Writer, filePath string, size int64) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("open %s: %w", filePath, err)
+	}
+	defer func() { _ = f.Close() }()
+
+	if size < 0 {
+		_, err = io.Copy(w, f)
+	} else {
+		_, err = io.CopyN(w, f, size)
+	}
+	if err != nil {
+		return fmt.Errorf("copy %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// stringList is a flag that can be passed multiple times.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
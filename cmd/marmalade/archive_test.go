@@ -0,0 +1,125 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/marmalade"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestWriteTar(t *testing.T) {
+	root := filepath.Join(t.TempDir(), "data")
+	mtime := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	assert.NoErr(t, os.MkdirAll(filepath.Join(root, "sub"), 0750))
+	assert.NoErr(t, os.MkdirAll(filepath.Join(root, "cache"), 0750))
+	assert.NoErr(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("abc"), 0640))
+	assert.NoErr(t, os.WriteFile(filepath.Join(root, "sub", "b.txt"), []byte("defg"), 0600))
+	assert.NoErr(t, os.WriteFile(filepath.Join(root, "sub", "b.log"), []byte("log"), 0600))
+	assert.NoErr(t, os.WriteFile(filepath.Join(root, "cache", "c.txt"), []byte("cached"), 0600))
+	assert.NoErr(t, os.Symlink("a.txt", filepath.Join(root, "link")))
+	assert.NoErr(t, os.Chtimes(filepath.Join(root, "a.txt"), mtime, mtime))
+
+	var buf bytes.Buffer
+	err := writeTar(&buf, root, []string{"*.log", "cache"})
+	assert.NoErr(t, err)
+
+	type entry struct {
+		typeflag byte
+		mode     int64
+		content  string
+		link     string
+	}
+	entries := map[string]entry{}
+	names := []string{}
+
+	tr := tar.NewReader(&buf)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		assert.NoErr(t, err)
+
+		content, err := io.ReadAll(tr)
+		assert.NoErr(t, err)
+
+		names = append(names, header.Name)
+		entries[header.Name] = entry{header.Typeflag, header.Mode & 0777, string(content), header.Linkname}
+
+		assert.Equal(t, os.Getuid(), header.Uid)
+		assert.Equal(t, os.Getgid(), header.Gid)
+		if header.Name == "data/a.txt" {
+			assert.True(t, mtime.Equal(header.ModTime))
+		}
+	}
+
+	assert.Equal(t, 5, len(names))
+	assert.Equal(t, entry{tar.TypeDir, 0750, "", ""}, entries["data/"])
+	assert.Equal(t, entry{tar.TypeReg, 0640, "abc", ""}, entries["data/a.txt"])
+	assert.Equal(t, entry{tar.TypeSymlink, 0777, "", "a.txt"}, entries["data/link"])
+	assert.Equal(t, entry{tar.TypeDir, 0750, "", ""}, entries["data/sub/"])
+	assert.Equal(t, entry{tar.TypeReg, 0600, "defg", ""}, entries["data/sub/b.txt"])
+}
+
+func TestWriteTarBadPattern(t *testing.T) {
+	err := writeTar(io.Discard, t.TempDir(), []string{"[a"})
+	assert.ErrContains(t, err, "exclude pattern [a")
+}
+
+func TestBackupDirectory(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	s3config := s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	root := filepath.Join(t.TempDir(), "data")
+	assert.NoErr(t, os.MkdirAll(root, 0750))
+	assert.NoErr(t, os.WriteFile(filepath.Join(root, "a.txt"), []byte("abc"), 0640))
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, root, archiveOptions{}, []age.Recipient{id.Recipient()})
+	assert.NoErr(t, err)
+
+	fileName := time.Now().UTC().Format("2006-01-02") + ".tar.age"
+	versions := sv.GetVersions(fileName)
+	assert.Equal(t, 1, len(versions))
+
+	reader, err := age.Decrypt(bytes.NewReader(versions[0].Content), id)
+	assert.NoErr(t, err)
+
+	tr := tar.NewReader(reader)
+	header, err := tr.Next()
+	assert.NoErr(t, err)
+	assert.Equal(t, "data/", header.Name)
+	header, err = tr.Next()
+	assert.NoErr(t, err)
+	assert.Equal(t, "data/a.txt", header.Name)
+	_, err = tr.Next()
+	assert.Equal(t, io.EOF, err)
+}
@@ -1,10 +1,12 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
-	"os"
-	"path/filepath"
+	"log/slog"
 	"time"
 
 	"filippo.io/age"
@@ -12,59 +14,158 @@ import (
 	"github.com/bradenrayhorn/marmalade/s3"
 )
 
-func encryptAndBackup(s3config s3.Config, schedule marmalade.RetentionSchedule, path, agePublicKey string) error {
-	client := s3.NewClient(s3config)
+// backupReport is the JSON output of a backup run.
+type backupReport struct {
+	Job    string                  `json:"job,omitempty"`
+	Result *marmalade.BackupResult `json:"result"`
+	Error  string                  `json:"error,omitempty"`
+}
+
+// runJob backs up the source of job as of at and writes a report of the run to w. When dryRun is
+// set it only writes the plan.
+func runJob(ctx context.Context, job backupJob, at time.Time, dryRun bool, format string, w io.Writer) error {
+	if format != "text" && format != "json" {
+		return fmt.Errorf("unknown format: %s", format)
+	}
 
-	workingDir, err := os.MkdirTemp("", "marmalade-*")
+	schedule, err := marmalade.ParseSchedule(job.config.Schedule)
 	if err != nil {
-		return fmt.Errorf("make working: %w", err)
+		return fmt.Errorf("parse schedule: %w", err)
 	}
-	defer func() { _ = os.RemoveAll(workingDir) }()
 
-	encryptedArchive, err := encrypt(agePublicKey, path, workingDir)
+	compression, err := parseCompression(job.config.Compression)
 	if err != nil {
 		return err
 	}
 
-	err = marmalade.Backup(client, schedule, time.Now().UTC(), encryptedArchive)
+	options := marmalade.BackupOptions{
+		Prefix:             job.config.Prefix,
+		MultipartThreshold: job.config.MultipartThreshold,
+		BypassGovernance:   job.bypassGovernance,
+	}
+	archive := archiveOptions{excludes: job.config.Exclude, compression: compression}
+	s3config, err := job.s3Config()
 	if err != nil {
-		return fmt.Errorf("backup: %w", err)
+		return err
 	}
 
-	return nil
+	if dryRun {
+		return planBackup(ctx, s3config, schedule, at, options, job.config.Source, archive, format, w)
+	}
+
+	recipients, err := loadRecipients(job.config.Recipients, job.config.RecipientsFiles)
+	if err != nil {
+		return fmt.Errorf("age recipients: %w", err)
+	}
+
+	result, err := encryptAndBackup(ctx, s3config, schedule, at, options, job.config.Source, archive, recipients)
+	if result == nil {
+		return err
+	}
+
+	var writeErr error
+	switch format {
+	case "text":
+		_, writeErr = io.WriteString(w, result.String())
+	case "json":
+		report := backupReport{Job: job.name, Result: result}
+		if err != nil {
+			report.Error = err.Error()
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		writeErr = encoder.Encode(report)
+	}
+
+	return errors.Join(err, writeErr)
 }
 
-func encrypt(agePublicKey, filePath, workingDir string) (string, error) {
-	recipient, err := age.ParseX25519Recipient(agePublicKey)
+// encryptAndBackup uploads the encrypted archive of path. Cancelling ctx aborts the upload.
+func encryptAndBackup(ctx context.Context, s3config s3.Config, schedule marmalade.RetentionSchedule, at time.Time, options marmalade.BackupOptions, path string, archive archiveOptions, recipients []age.Recipient) (*marmalade.BackupResult, error) {
+	client := s3.NewClient(s3config)
+
+	name, err := archiveName(path, archive)
 	if err != nil {
-		return "", fmt.Errorf("age identity: %w", err)
+		return nil, err
 	}
 
-	src, err := os.Open(filePath)
+	// Restore cannot tell the compression from the name, which may have come from the source.
+	options.Metadata = map[string]string{compressionMetadata: archive.compression.name()}
+
+	plan, err := marmalade.PlanBackupContext(ctx, client, schedule, at, name, options)
 	if err != nil {
-		return "", fmt.Errorf("open %s: %w", filePath, err)
+		return nil, fmt.Errorf("plan: %w", err)
 	}
 
-	archivePath := filepath.Join(workingDir, filepath.Base(filePath)+".age")
-	archive, err := os.Create(archivePath)
+	// Encrypt straight into the upload, only reading the source if it is going to be uploaded.
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	if plan.Upload != nil {
+		go func() {
+			defer close(done)
+			_ = pw.CloseWithError(encrypt(recipients, path, archive, pw))
+		}()
+	} else {
+		close(done)
+	}
+
+	result, err := plan.ExecuteStreamContext(ctx, client, pr)
+
+	// Stop the encryption goroutine if the upload ended early.
+	_ = pr.CloseWithError(fmt.Errorf("upload stopped"))
+	<-done
+
+	metrics := client.Metrics()
+	slog.Info("s3 requests", "requests", metrics.Requests, "retries", metrics.Retries, "throttled", metrics.Throttled)
+
+	if err != nil {
+		return result, fmt.Errorf("backup: %w", err)
+	}
+
+	return result, nil
+}
+
+func planBackup(ctx context.Context, s3config s3.Config, schedule marmalade.RetentionSchedule, at time.Time, options marmalade.BackupOptions, path string, archive archiveOptions, format string, w io.Writer) error {
+	client := s3.NewClient(s3config)
+
+	name, err := archiveName(path, archive)
 	if err != nil {
-		return "", fmt.Errorf("create %s: %w", archivePath, err)
+		return err
 	}
-	defer func() { _ = archive.Close() }()
 
-	w, err := age.Encrypt(archive, recipient)
+	plan, err := marmalade.PlanBackupContext(ctx, client, schedule, at, name, options)
 	if err != nil {
-		return "", fmt.Errorf("age encrypt: %w", err)
+		return fmt.Errorf("plan: %w", err)
 	}
 
-	_, err = io.Copy(w, src)
+	switch format {
+	case "text":
+		_, err = io.WriteString(w, plan.String())
+	case "json":
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(plan)
+	default:
+		return fmt.Errorf("unknown format: %s", format)
+	}
+
+	return err
+}
+
+// encrypt writes the encrypted archive of filePath to w.
+func encrypt(recipients []age.Recipient, filePath string, options archiveOptions, w io.Writer) error {
+	aw, err := age.Encrypt(w, recipients...)
 	if err != nil {
-		return "", fmt.Errorf("copy to age: %w", err)
+		return fmt.Errorf("age encrypt: %w", err)
 	}
 
-	if err := w.Close(); err != nil {
-		return "", fmt.Errorf("close encrypted file: %w", err)
+	if err := writeArchive(aw, filePath, options); err != nil {
+		return err
 	}
 
-	return archivePath, nil
+	if err := aw.Close(); err != nil {
+		return fmt.Errorf("close encrypted file: %w", err)
+	}
+
+	return nil
 }
@@ -2,10 +2,18 @@ package main
 
 import (
 	"bytes"
+	"context"
+	"crypto/rand"
 	"crypto/sha256"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"net/http"
 	"os"
+	"path/filepath"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -46,7 +54,7 @@ func TestBackup(t *testing.T) {
 	assert.NoErr(t, err)
 
 	// do backup
-	err = encryptAndBackup(s3config, schedule, file.Name(), id.Recipient().String())
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, file.Name(), archiveOptions{}, []age.Recipient{id.Recipient()})
 	assert.NoErr(t, err)
 
 	// get stored file
@@ -73,3 +81,221 @@ func TestBackup(t *testing.T) {
 
 	assert.Equal(t, string(storedData), hex.EncodeToString(hash[:]))
 }
+
+func TestBackupDryRun(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	s3config := s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	source := filepath.Join(t.TempDir(), "file.txt")
+	err = os.WriteFile(source, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	fileName := time.Now().UTC().Format("2006-01-02") + ".txt.age"
+
+	// text output
+	var out bytes.Buffer
+	err = planBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{}, "text", &out)
+	assert.NoErr(t, err)
+	assert.Equal(t, fmt.Sprintf("upload %s and %s.sha256 (daily)\n", fileName, fileName), out.String())
+
+	// json output
+	out.Reset()
+	err = planBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{}, "json", &out)
+	assert.NoErr(t, err)
+
+	var plan marmalade.Plan
+	err = json.Unmarshal(out.Bytes(), &plan)
+	assert.NoErr(t, err)
+	assert.Equal(t, fileName, plan.Upload.Key)
+
+	// nothing was uploaded
+	assert.Equal(t, 0, len(sv.GetVersions(fileName)))
+
+	// bad format
+	err = planBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{}, "yaml", &out)
+	assert.ErrContains(t, err, "unknown format: yaml")
+}
+
+func TestBackupStreamsInParts(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	s3config := s3.Config{
+		URL:               url,
+		Region:            "my-region",
+		KeyID:             "keyid",
+		KeySecret:         "shh",
+		Bucket:            "my-bucket",
+		Insecure:          true,
+		MultipartPartSize: 1024,
+		AllowSmallParts:   true,
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	content := make([]byte, 5000)
+	_, err = rand.Read(content)
+	assert.NoErr(t, err)
+
+	source := filepath.Join(t.TempDir(), "file.bin")
+	err = os.WriteFile(source, content, 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	var parts atomic.Int32
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.URL.Query().Has("partNumber") {
+			parts.Add(1)
+		}
+		return false
+	})
+
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{}, []age.Recipient{id.Recipient()})
+	assert.NoErr(t, err)
+	assert.True(t, parts.Load() >= 5)
+
+	fileName := time.Now().UTC().Format("2006-01-02") + ".bin.age"
+	versions := sv.GetVersions(fileName)
+	assert.Equal(t, 1, len(versions))
+	storedData := versions[0].Content
+
+	reader, err := age.Decrypt(bytes.NewReader(storedData), id)
+	assert.NoErr(t, err)
+	decrypted, err := io.ReadAll(reader)
+	assert.NoErr(t, err)
+	assert.True(t, bytes.Equal(content, decrypted))
+
+	// hash is of the encrypted data
+	hash := sha256.Sum256(storedData)
+	versions = sv.GetVersions(fileName + ".sha256")
+	assert.Equal(t, 1, len(versions))
+	assert.Equal(t, hex.EncodeToString(hash[:]), string(versions[0].Content))
+}
+
+func TestBackupAbortsWhenCancelled(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	s3config := s3.Config{
+		URL:               sv.GetEndpoint(),
+		Region:            "my-region",
+		KeyID:             "keyid",
+		KeySecret:         "shh",
+		Bucket:            "my-bucket",
+		Insecure:          true,
+		MultipartPartSize: 1024,
+		AllowSmallParts:   true,
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	source := filepath.Join(t.TempDir(), "file.bin")
+	err = os.WriteFile(source, make([]byte, 5000), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	// cancel once the upload has started
+	ctx, cancel := context.WithCancel(context.Background())
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.URL.Query().Has("partNumber") {
+			cancel()
+		}
+		return false
+	})
+
+	_, err = encryptAndBackup(ctx, s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{}, []age.Recipient{id.Recipient()})
+	assert.ErrContains(t, err, "context canceled")
+
+	fileName := time.Now().UTC().Format("2006-01-02") + ".bin.age"
+	assert.Equal(t, 0, len(sv.GetVersions(fileName)))
+	assert.Equal(t, 0, len(sv.GetVersions(fileName+".sha256")))
+	assert.Equal(t, 0, sv.GetMultipartUploadCount())
+}
+
+func TestBackupReport(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	source := filepath.Join(t.TempDir(), "dump.sql")
+	err := os.WriteFile(source, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	job := backupJob{
+		name: "postgres",
+		config: jobConfig{
+			Source:     source,
+			Schedule:   "1d",
+			Recipients: []string{id.Recipient().String()},
+		},
+		profile: profileConfig{
+			URL:       sv.GetEndpoint(),
+			Region:    "my-region",
+			KeyID:     "keyid",
+			KeySecret: "shh",
+			Bucket:    "my-bucket",
+			Insecure:  true,
+		},
+	}
+
+	// an unknown file that is still locked cannot be deleted
+	client := s3.NewClient(job.profile.s3Config())
+	err = client.PutObject("locked.txt", bytes.NewReader([]byte("abc")), 3, &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: time.Now().AddDate(1, 0, 0)})
+	assert.NoErr(t, err)
+
+	var out bytes.Buffer
+	err = runJob(context.Background(), job, time.Now().UTC(), false, "json", &out)
+	assert.True(t, errors.Is(err, marmalade.ErrPartialFailure))
+
+	var report backupReport
+	err = json.Unmarshal(out.Bytes(), &report)
+	assert.NoErr(t, err)
+
+	fileName := time.Now().UTC().Format("2006-01-02") + ".sql.age"
+	stored := sv.GetVersions(fileName)
+	assert.Equal(t, 1, len(stored))
+	hash := sha256.Sum256(stored[0].Content)
+
+	assert.Equal(t, "postgres", report.Job)
+	assert.Equal(t, fileName, report.Result.Upload.Key)
+	assert.Equal(t, int64(len(stored[0].Content)), report.Result.Upload.Size)
+	assert.Equal(t, hex.EncodeToString(hash[:]), report.Result.Upload.SHA256)
+	assert.Equal(t, 1, len(report.Result.Failures))
+	assert.Equal(t, "locked.txt", report.Result.Failures[0].Key)
+	assert.ErrContains(t, errors.New(report.Error), "backup partially failed")
+
+	err = runJob(context.Background(), job, time.Now().UTC(), false, "yaml", &out)
+	assert.ErrContains(t, err, "unknown format: yaml")
+}
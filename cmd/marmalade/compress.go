@@ -0,0 +1,126 @@
+package main
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// compressionMetadata is the S3 user metadata that records the codec name of a backup, or "none".
+// Restore reads it rather than trusting the key, whose extension may come from the source.
+const compressionMetadata = "marmalade-compression"
+
+// codec is a compression format applied to archives before they are encrypted. The extension is
+// added to the backup key for people browsing the bucket, and the name is stored in the
+// compressionMetadata of the backup.
+type codec struct {
+	name         string
+	extension    string
+	defaultLevel int
+	newWriter    func(w io.Writer, level int) (io.WriteCloser, error)
+	newReader    func(r io.Reader) (io.ReadCloser, error)
+}
+
+var codecs = []*codec{
+	{
+		name:         "gzip",
+		extension:    ".gz",
+		defaultLevel: gzip.DefaultCompression,
+		newWriter: func(w io.Writer, level int) (io.WriteCloser, error) {
+			return gzip.NewWriterLevel(w, level)
+		},
+		newReader: func(r io.Reader) (io.ReadCloser, error) {
+			return gzip.NewReader(r)
+		},
+	},
+}
+
+type compression struct {
+	codec *codec
+	level int
+}
+
+// parseCompression parses a codec name with an optional level, such as "gzip" or "gzip:9". An
+// empty string or "none" disables compression.
+func parseCompression(spec string) (compression, error) {
+	name, levelString, hasLevel := strings.Cut(strings.TrimSpace(spec), ":")
+	if name == "" || name == "none" {
+		return compression{}, nil
+	}
+
+	for _, c := range codecs {
+		if c.name != name {
+			continue
+		}
+
+		level := c.defaultLevel
+		if hasLevel {
+			var err error
+			level, err = strconv.Atoi(levelString)
+			if err != nil {
+				return compression{}, fmt.Errorf("compression level %s: %w", levelString, err)
+			}
+		}
+
+		// Check the level is valid now rather than when the backup is written.
+		if _, err := c.newWriter(io.Discard, level); err != nil {
+			return compression{}, fmt.Errorf("compression %s: %w", spec, err)
+		}
+
+		return compression{codec: c, level: level}, nil
+	}
+
+	return compression{}, fmt.Errorf("unknown compression: %s", name)
+}
+
+// name is the value of compressionMetadata for backups made with c.
+func (c compression) name() string {
+	if c.codec == nil {
+		return "none"
+	}
+	return c.codec.name
+}
+
+func (c compression) extension() string {
+	if c.codec == nil {
+		return ""
+	}
+	return c.codec.extension
+}
+
+// compress wraps w so everything written is compressed. Closing the returned writer does not
+// close w.
+func (c compression) compress(w io.Writer) (io.WriteCloser, error) {
+	if c.codec == nil {
+		return nopWriteCloser{w}, nil
+	}
+	return c.codec.newWriter(w, c.level)
+}
+
+// decompressorFor returns a reader that reverses the compression recorded in the metadata of a
+// backup. Backups made before the compression was recorded fall back to the extension of the
+// archive name, such as "2025-03-05.sql.gz" after the ".age" extension is removed.
+func decompressorFor(metadata map[string]string, name string, r io.Reader) (io.ReadCloser, error) {
+	codecName, recorded := metadata[compressionMetadata]
+	if recorded && codecName == "none" {
+		return io.NopCloser(r), nil
+	}
+
+	for _, c := range codecs {
+		if (recorded && c.name == codecName) || (!recorded && strings.HasSuffix(name, c.extension)) {
+			return c.newReader(r)
+		}
+	}
+	if recorded {
+		return nil, fmt.Errorf("unknown compression: %s", codecName)
+	}
+	return io.NopCloser(r), nil
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/marmalade"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestParseCompression(t *testing.T) {
+	c, err := parseCompression("")
+	assert.NoErr(t, err)
+	assert.Equal(t, nil, c.codec)
+	assert.Equal(t, "", c.extension())
+
+	c, err = parseCompression("none")
+	assert.NoErr(t, err)
+	assert.Equal(t, nil, c.codec)
+
+	c, err = parseCompression("gzip")
+	assert.NoErr(t, err)
+	assert.Equal(t, "gzip", c.codec.name)
+	assert.Equal(t, gzip.DefaultCompression, c.level)
+	assert.Equal(t, ".gz", c.extension())
+
+	c, err = parseCompression("gzip:9")
+	assert.NoErr(t, err)
+	assert.Equal(t, 9, c.level)
+
+	_, err = parseCompression("gzip:12")
+	assert.ErrContains(t, err, "compression gzip:12")
+
+	_, err = parseCompression("gzip:x")
+	assert.ErrContains(t, err, "compression level x")
+
+	_, err = parseCompression("zip")
+	assert.ErrContains(t, err, "unknown compression: zip")
+}
+
+func TestBackupAndRestoreCompressed(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	s3config := s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "dump.sql")
+	content := strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)
+	err = os.WriteFile(source, []byte(content), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	compression, err := parseCompression("gzip:9")
+	assert.NoErr(t, err)
+
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{compression: compression}, []age.Recipient{id.Recipient()})
+	assert.NoErr(t, err)
+
+	// the stored archive is compressed before being encrypted
+	key := time.Now().UTC().Format("2006-01-02") + ".sql.gz.age"
+	versions := sv.GetVersions(key)
+	assert.Equal(t, 1, len(versions))
+	assert.True(t, len(versions[0].Content) < len(content)/10)
+
+	reader, err := age.Decrypt(bytes.NewReader(versions[0].Content), id)
+	assert.NoErr(t, err)
+	gz, err := gzip.NewReader(reader)
+	assert.NoErr(t, err)
+	decompressed, err := io.ReadAll(gz)
+	assert.NoErr(t, err)
+	assert.Equal(t, content, string(decompressed))
+
+	assert.Equal(t, "gzip", versions[0].Metadata["marmalade-compression"])
+
+	// restore reverses the compression
+	output := filepath.Join(dir, "restored.sql")
+	err = downloadAndRestore(context.Background(), s3config, key, output, id.String(), marmalade.ThawOptions{})
+	assert.NoErr(t, err)
+
+	restored, err := os.ReadFile(output)
+	assert.NoErr(t, err)
+	assert.Equal(t, content, string(restored))
+}
+
+func TestBackupAndRestoreUncompressedGzipSource(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	s3config := s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	// the source is already gzipped, so its backup ends in .gz without being compressed again
+	var content bytes.Buffer
+	gz := gzip.NewWriter(&content)
+	_, err = gz.Write([]byte(strings.Repeat("INSERT INTO t VALUES (1);\n", 1000)))
+	assert.NoErr(t, err)
+	assert.NoErr(t, gz.Close())
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "dump.sql.gz")
+	err = os.WriteFile(source, content.Bytes(), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{}, []age.Recipient{id.Recipient()})
+	assert.NoErr(t, err)
+
+	key := time.Now().UTC().Format("2006-01-02") + ".sql.gz.age"
+	assert.Equal(t, "none", sv.GetVersions(key)[0].Metadata["marmalade-compression"])
+
+	// restore gives back the gzipped source as it was
+	output := filepath.Join(dir, "restored.sql.gz")
+	err = downloadAndRestore(context.Background(), s3config, key, output, id.String(), marmalade.ThawOptions{})
+	assert.NoErr(t, err)
+
+	restored, err := os.ReadFile(output)
+	assert.NoErr(t, err)
+	assert.True(t, bytes.Equal(content.Bytes(), restored))
+}
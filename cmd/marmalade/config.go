@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/marmalade"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+// fileConfig is the layout of the JSON file passed with -config.
+//
+//	{
+//	  "profiles": {"default": {"url": "https://s3.example.com", "bucket": "backups"}},
+//	  "jobs": {"postgres": {"source": "/var/backups/pg", "prefix": "pg/", "schedule": "7d 12m", "cron": "0 3 * * *"}}
+//	}
+type fileConfig struct {
+	Profiles map[string]profileConfig `json:"profiles"`
+	Jobs     map[string]jobConfig     `json:"jobs"`
+}
+
+// profileConfig is an S3 bucket and the credentials used to reach it. Without a key id, the
+// credentials are found the way AWS tools find them, using CredentialsProfile from the shared
+// credentials file. With a role arn, those credentials are only used to assume the role, unless a
+// web identity token file is set, which is exchanged for the role instead.
+type profileConfig struct {
+	URL                string `json:"url"`
+	Region             string `json:"region"`
+	KeyID              string `json:"key_id"`
+	KeySecret          string `json:"key_secret"`
+	SessionToken       string `json:"session_token"`
+	CredentialsProfile string `json:"credentials_profile"`
+	RoleARN            string `json:"role_arn"`
+	RoleSessionName    string `json:"role_session_name"`
+	ExternalID         string `json:"external_id"`
+	WebIdentityFile    string `json:"web_identity_token_file"`
+	STSEndpoint        string `json:"sts_endpoint"`
+	Bucket             string `json:"bucket"`
+	StorageClass       string `json:"storage_class"`
+	Insecure           bool   `json:"insecure"`
+	AddressingStyle    string `json:"addressing_style"`
+	PartSize           int64  `json:"part_size"`
+	Concurrency        int    `json:"concurrency"`
+
+	// Retries of failed requests, durations are strings such as "30s".
+	MaxAttempts    int      `json:"max_attempts"`
+	RetryBaseDelay duration `json:"retry_base_delay"`
+	RetryMaxDelay  duration `json:"retry_max_delay"`
+	RetryBudget    duration `json:"retry_budget"`
+
+	// Connections to the endpoint. Without a proxy url, HTTPS_PROXY is honored.
+	CAFile         string   `json:"ca_file"`
+	ClientCertFile string   `json:"client_cert_file"`
+	ClientKeyFile  string   `json:"client_key_file"`
+	ProxyURL       string   `json:"proxy_url"`
+	Timeout        duration `json:"timeout"`
+}
+
+type duration time.Duration
+
+func (d *duration) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return fmt.Errorf("duration must be a string: %w", err)
+	}
+
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+type jobConfig struct {
+	Source             string   `json:"source"`
+	Prefix             string   `json:"prefix"`
+	Schedule           string   `json:"schedule"`
+	Recipients         []string `json:"recipients"`
+	RecipientsFiles    []string `json:"recipients_files"`
+	Exclude            []string `json:"exclude"`
+	Compression        string   `json:"compression"`
+	MultipartThreshold int64    `json:"multipart_threshold"`
+	Profile            string   `json:"profile"`
+
+	// Cron is when the daemon runs the job, such as "0 3 * * *".
+	Cron string `json:"cron"`
+
+	// Server-side encryption on top of age, either "AES256" or "aws:kms" with an optional key id
+	// and context. A customer key file holds a base64 encoded 32 byte key for SSE-C instead.
+	SSE                string            `json:"sse"`
+	SSEKMSKeyID        string            `json:"sse_kms_key_id"`
+	SSEKMSContext      map[string]string `json:"sse_kms_context"`
+	SSECustomerKeyFile string            `json:"sse_customer_key_file"`
+}
+
+// backupJob is a job from the config file with the environment applied over it. Without a config
+// file the job is unnamed and comes entirely from the environment.
+type backupJob struct {
+	name    string
+	config  jobConfig
+	profile profileConfig
+
+	// bypassGovernance is only set by the backup command's flag, never from a config file.
+	bypassGovernance bool
+}
+
+const defaultProfile = "default"
+
+func readConfigFile(path string) (fileConfig, error) {
+	var config fileConfig
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return config, fmt.Errorf("read config: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &config); err != nil {
+		return config, fmt.Errorf("parse config %s: %w", path, err)
+	}
+
+	return config, nil
+}
+
+// jobEnv are the MARMALADE_* environment variables that only apply to a single job. Applied to
+// several jobs, they would put them in the same backup set, where they delete each other's
+// backups.
+var jobEnv = []string{"MARMALADE_SOURCE", "MARMALADE_PREFIX", "MARMALADE_SCHEDULE"}
+
+// loadJobs returns the named job, or every job if all is set. With neither, it returns a single
+// job built from the environment.
+func loadJobs(configPath string, name string, all bool) ([]backupJob, error) {
+	if name == "" && !all {
+		job := backupJob{}
+		if err := job.applyEnv(); err != nil {
+			return nil, err
+		}
+		return []backupJob{job}, nil
+	}
+
+	if name != "" && all {
+		return nil, fmt.Errorf("-job and -all cannot be used together")
+	}
+	if configPath == "" {
+		return nil, fmt.Errorf("a config file is required to select jobs, set -config or MARMALADE_CONFIG")
+	}
+
+	config, err := readConfigFile(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{name}
+	if all {
+		names = make([]string, 0, len(config.Jobs))
+		for jobName := range config.Jobs {
+			names = append(names, jobName)
+		}
+		slices.Sort(names)
+	}
+
+	if len(names) > 1 {
+		for _, env := range jobEnv {
+			if os.Getenv(env) != "" {
+				return nil, fmt.Errorf("%s cannot be used with more than one job, set it for each job in the config file instead", env)
+			}
+		}
+	}
+
+	jobs := make([]backupJob, 0, len(names))
+	for _, jobName := range names {
+		job, err := config.job(jobName)
+		if err != nil {
+			return nil, err
+		}
+		if err := job.applyEnv(); err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, job)
+	}
+
+	return jobs, nil
+}
+
+func (c fileConfig) job(name string) (backupJob, error) {
+	jobConfig, ok := c.Jobs[name]
+	if !ok {
+		return backupJob{}, fmt.Errorf("unknown job: %s", name)
+	}
+
+	profileName := jobConfig.Profile
+	if profileName == "" {
+		profileName = defaultProfile
+	}
+
+	profile, ok := c.Profiles[profileName]
+	if !ok && jobConfig.Profile != "" {
+		return backupJob{}, fmt.Errorf("job %s: unknown profile: %s", name, jobConfig.Profile)
+	}
+
+	return backupJob{name: name, config: jobConfig, profile: profile}, nil
+}
+
+// applyEnv overrides settings of the job with any MARMALADE_* environment variables that are set.
+func (j *backupJob) applyEnv() error {
+	setFromEnv(&j.config.Source, "MARMALADE_SOURCE")
+	setFromEnv(&j.config.Prefix, "MARMALADE_PREFIX")
+	setFromEnv(&j.config.Schedule, "MARMALADE_SCHEDULE")
+	setFromEnv(&j.config.Compression, "MARMALADE_COMPRESSION")
+	setFromEnv(&j.config.SSE, "MARMALADE_SSE")
+	setFromEnv(&j.config.SSEKMSKeyID, "MARMALADE_SSE_KMS_KEY_ID")
+	setFromEnv(&j.config.SSECustomerKeyFile, "MARMALADE_SSE_CUSTOMER_KEY_FILE")
+
+	threshold, err := envInt("MARMALADE_MULTIPART_THRESHOLD")
+	if err != nil {
+		return err
+	}
+	if threshold != 0 {
+		j.config.MultipartThreshold = threshold
+	}
+
+	// Recipients are replaced as a whole, mixing them with the file would be surprising.
+	key := os.Getenv("MARMALADE_AGE_PUBLIC_KEY")
+	file := os.Getenv("MARMALADE_AGE_RECIPIENTS_FILE")
+	if key != "" || file != "" {
+		j.config.Recipients = nil
+		j.config.RecipientsFiles = nil
+		if key != "" {
+			j.config.Recipients = []string{key}
+		}
+		if file != "" {
+			j.config.RecipientsFiles = []string{file}
+		}
+	}
+
+	if err := marmalade.ValidatePrefix(j.config.Prefix); err != nil {
+		return err
+	}
+
+	return j.profile.applyEnv()
+}
+
+func (p *profileConfig) applyEnv() error {
+	setFromEnv(&p.URL, "MARMALADE_S3_URL")
+	setFromEnv(&p.Region, "MARMALADE_S3_REGION")
+	setFromEnv(&p.KeyID, "MARMALADE_S3_KEY_ID")
+	setFromEnv(&p.KeySecret, "MARMALADE_S3_KEY_SECRET")
+	setFromEnv(&p.SessionToken, "MARMALADE_S3_SESSION_TOKEN")
+	setFromEnv(&p.CredentialsProfile, "MARMALADE_S3_CREDENTIALS_PROFILE")
+	setFromEnv(&p.RoleARN, "MARMALADE_S3_ROLE_ARN")
+	setFromEnv(&p.RoleSessionName, "MARMALADE_S3_ROLE_SESSION_NAME")
+	setFromEnv(&p.ExternalID, "MARMALADE_S3_EXTERNAL_ID")
+	setFromEnv(&p.WebIdentityFile, "MARMALADE_S3_WEB_IDENTITY_TOKEN_FILE")
+	setFromEnv(&p.STSEndpoint, "MARMALADE_S3_STS_ENDPOINT")
+	setFromEnv(&p.Bucket, "MARMALADE_S3_BUCKET")
+	setFromEnv(&p.StorageClass, "MARMALADE_S3_STORAGE_CLASS")
+	setFromEnv(&p.AddressingStyle, "MARMALADE_S3_ADDRESSING_STYLE")
+	setFromEnv(&p.CAFile, "MARMALADE_S3_CA_FILE")
+	setFromEnv(&p.ClientCertFile, "MARMALADE_S3_CLIENT_CERT_FILE")
+	setFromEnv(&p.ClientKeyFile, "MARMALADE_S3_CLIENT_KEY_FILE")
+	setFromEnv(&p.ProxyURL, "MARMALADE_S3_PROXY_URL")
+	if _, err := s3.ParseAddressingStyle(p.AddressingStyle); err != nil {
+		return err
+	}
+
+	partSize, err := envInt("MARMALADE_S3_PART_SIZE")
+	if err != nil {
+		return err
+	}
+	if partSize != 0 {
+		p.PartSize = partSize
+	}
+
+	concurrency, err := envInt("MARMALADE_S3_CONCURRENCY")
+	if err != nil {
+		return err
+	}
+	if concurrency != 0 {
+		p.Concurrency = int(concurrency)
+	}
+
+	maxAttempts, err := envInt("MARMALADE_S3_MAX_ATTEMPTS")
+	if err != nil {
+		return err
+	}
+	if maxAttempts != 0 {
+		p.MaxAttempts = int(maxAttempts)
+	}
+
+	if err := setDurationFromEnv(&p.RetryBaseDelay, "MARMALADE_S3_RETRY_BASE_DELAY"); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&p.RetryMaxDelay, "MARMALADE_S3_RETRY_MAX_DELAY"); err != nil {
+		return err
+	}
+	if err := setDurationFromEnv(&p.RetryBudget, "MARMALADE_S3_RETRY_BUDGET"); err != nil {
+		return err
+	}
+	return setDurationFromEnv(&p.Timeout, "MARMALADE_S3_TIMEOUT")
+}
+
+// s3Config is the profile of the job with the encryption of the job applied.
+func (j backupJob) s3Config() (s3.Config, error) {
+	config := j.profile.s3Config()
+	config.Encryption = s3.Encryption{
+		Algorithm:  j.config.SSE,
+		KMSKeyID:   j.config.SSEKMSKeyID,
+		KMSContext: j.config.SSEKMSContext,
+	}
+
+	if j.config.SSECustomerKeyFile != "" {
+		data, err := os.ReadFile(j.config.SSECustomerKeyFile)
+		if err != nil {
+			return config, fmt.Errorf("read sse customer key: %w", err)
+		}
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil {
+			return config, fmt.Errorf("decode sse customer key %s: %w", j.config.SSECustomerKeyFile, err)
+		}
+		config.Encryption.CustomerKey = key
+	}
+
+	return config, nil
+}
+
+func (p profileConfig) s3Config() s3.Config {
+	var credentials s3.CredentialsProvider
+	if p.KeyID == "" {
+		credentials = s3.DefaultCredentials(p.CredentialsProfile)
+	}
+
+	if p.RoleARN != "" && p.WebIdentityFile != "" {
+		credentials = s3.WebIdentityCredentials{
+			RoleARN:     p.RoleARN,
+			TokenFile:   p.WebIdentityFile,
+			SessionName: p.RoleSessionName,
+			Region:      p.Region,
+			Endpoint:    p.STSEndpoint,
+		}
+	} else if p.RoleARN != "" {
+		source := credentials
+		if source == nil {
+			source = s3.StaticCredentials{AccessKeyID: p.KeyID, SecretAccessKey: p.KeySecret, SessionToken: p.SessionToken}
+		}
+		credentials = s3.AssumeRoleCredentials{
+			Source:      source,
+			RoleARN:     p.RoleARN,
+			SessionName: p.RoleSessionName,
+			ExternalID:  p.ExternalID,
+			Region:      p.Region,
+			Endpoint:    p.STSEndpoint,
+		}
+	}
+
+	// Checked when the environment was applied.
+	addressingStyle, _ := s3.ParseAddressingStyle(p.AddressingStyle)
+
+	return s3.Config{
+		URL:          p.URL,
+		Region:       p.Region,
+		KeyID:        p.KeyID,
+		KeySecret:    p.KeySecret,
+		SessionToken: p.SessionToken,
+		Credentials:  credentials,
+		Bucket:       p.Bucket,
+		StorageClass: p.StorageClass,
+		Insecure:     p.Insecure,
+
+		AddressingStyle: addressingStyle,
+
+		MultipartPartSize:    p.PartSize,
+		MultipartConcurrency: p.Concurrency,
+
+		Retry: s3.RetryPolicy{
+			MaxAttempts: p.MaxAttempts,
+			BaseDelay:   time.Duration(p.RetryBaseDelay),
+			MaxDelay:    time.Duration(p.RetryMaxDelay),
+			Budget:      time.Duration(p.RetryBudget),
+		},
+
+		Transport: s3.TransportConfig{
+			CAFile:         p.CAFile,
+			ClientCertFile: p.ClientCertFile,
+			ClientKeyFile:  p.ClientKeyFile,
+			ProxyURL:       p.ProxyURL,
+			Timeout:        time.Duration(p.Timeout),
+		},
+	}
+}
+
+func setFromEnv(value *string, name string) {
+	if env := os.Getenv(name); env != "" {
+		*value = env
+	}
+}
+
+func setDurationFromEnv(value *duration, name string) error {
+	env := os.Getenv(name)
+	if env == "" {
+		return nil
+	}
+
+	parsed, err := time.ParseDuration(env)
+	if err != nil {
+		return fmt.Errorf("parse %s: %w", name, err)
+	}
+	*value = duration(parsed)
+	return nil
+}
+
+// envInt reads an optional integer from the environment, returning zero if it is not set.
+func envInt(name string) (int64, error) {
+	value := os.Getenv(name)
+	if value == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse %s: %w", name, err)
+	}
+	return parsed, nil
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+const testConfig = `{
+  "profiles": {
+    "default": {"url": "https://default.example.com", "region": "us-east-1", "bucket": "main"},
+    "offsite": {"url": "https://offsite.example.com", "region": "eu-west-1", "bucket": "far", "part_size": 1024, "max_attempts": 4, "retry_budget": "2m", "ca_file": "/etc/ssl/offsite.pem", "timeout": "15m"}
+  },
+  "jobs": {
+    "postgres": {"source": "/var/backups/pg", "prefix": "pg/", "schedule": "7d", "recipients": ["age1abc"]},
+    "photos": {"source": "/srv/photos", "prefix": "photos/", "schedule": "12m", "profile": "offsite", "exclude": ["*.tmp"]},
+    "broken": {"source": "/srv/broken", "schedule": "1d", "profile": "missing"}
+  }
+}`
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "marmalade.json")
+	err := os.WriteFile(path, []byte(content), 0600)
+	assert.NoErr(t, err)
+	return path
+}
+
+func TestLoadJobs(t *testing.T) {
+	path := writeConfig(t, testConfig)
+
+	// named job uses the default profile
+	jobs, err := loadJobs(path, "postgres", false)
+	assert.NoErr(t, err)
+	assert.Equal(t, 1, len(jobs))
+	assert.Equal(t, "postgres", jobs[0].name)
+	assert.Equal(t, "/var/backups/pg", jobs[0].config.Source)
+	assert.Equal(t, "pg/", jobs[0].config.Prefix)
+	assert.Equal(t, "main", jobs[0].profile.Bucket)
+
+	// named profile
+	jobs, err = loadJobs(path, "photos", false)
+	assert.NoErr(t, err)
+	assert.Equal(t, "far", jobs[0].profile.Bucket)
+	assert.Equal(t, int64(1024), jobs[0].profile.s3Config().MultipartPartSize)
+	assert.Equal(t, s3.RetryPolicy{MaxAttempts: 4, Budget: 2 * time.Minute}, jobs[0].profile.s3Config().Retry)
+	assert.Equal(t, "/etc/ssl/offsite.pem", jobs[0].profile.s3Config().Transport.CAFile)
+	assert.Equal(t, 15*time.Minute, jobs[0].profile.s3Config().Transport.Timeout)
+	assert.Equal(t, "*.tmp", strings.Join(jobs[0].config.Exclude, ","))
+
+	// errors
+	_, err = loadJobs(path, "mysql", false)
+	assert.ErrContains(t, err, "unknown job: mysql")
+
+	_, err = loadJobs(path, "broken", false)
+	assert.ErrContains(t, err, "job broken: unknown profile: missing")
+
+	_, err = loadJobs("", "postgres", false)
+	assert.ErrContains(t, err, "a config file is required")
+
+	_, err = loadJobs(path, "postgres", true)
+	assert.ErrContains(t, err, "-job and -all cannot be used together")
+
+	_, err = loadJobs(writeConfig(t, "{"), "postgres", false)
+	assert.ErrContains(t, err, "parse config")
+}
+
+func TestLoadJobsAll(t *testing.T) {
+	path := writeConfig(t, strings.Replace(testConfig, `"profile": "missing"`, `"profile": "offsite"`, 1))
+
+	jobs, err := loadJobs(path, "", true)
+	assert.NoErr(t, err)
+
+	names := []string{}
+	for _, job := range jobs {
+		names = append(names, job.name)
+	}
+	assert.Equal(t, "broken,photos,postgres", strings.Join(names, ","))
+
+	// settings for a single job would merge every job into one backup set
+	t.Setenv("MARMALADE_PREFIX", "db/")
+	_, err = loadJobs(path, "", true)
+	assert.ErrContains(t, err, "MARMALADE_PREFIX cannot be used with more than one job")
+
+	jobs, err = loadJobs(path, "postgres", false)
+	assert.NoErr(t, err)
+	assert.Equal(t, "db/", jobs[0].config.Prefix)
+}
+
+func TestEnvironmentOverridesConfig(t *testing.T) {
+	path := writeConfig(t, testConfig)
+
+	t.Setenv("MARMALADE_PREFIX", "db/")
+	t.Setenv("MARMALADE_S3_BUCKET", "other")
+	t.Setenv("MARMALADE_S3_CONCURRENCY", "8")
+	t.Setenv("MARMALADE_S3_RETRY_MAX_DELAY", "5s")
+	t.Setenv("MARMALADE_S3_ADDRESSING_STYLE", "virtual-hosted")
+	t.Setenv("MARMALADE_S3_PROXY_URL", "http://proxy.internal:3128")
+	t.Setenv("MARMALADE_AGE_RECIPIENTS_FILE", "/etc/marmalade/recipients")
+
+	jobs, err := loadJobs(path, "postgres", false)
+	assert.NoErr(t, err)
+
+	job := jobs[0]
+	assert.Equal(t, "db/", job.config.Prefix)
+	assert.Equal(t, "7d", job.config.Schedule)
+	assert.Equal(t, "other", job.profile.Bucket)
+	assert.Equal(t, "us-east-1", job.profile.Region)
+	assert.Equal(t, 8, job.profile.Concurrency)
+	assert.Equal(t, 5*time.Second, job.profile.s3Config().Retry.MaxDelay)
+	assert.Equal(t, s3.AddressingVirtualHosted, job.profile.s3Config().AddressingStyle)
+	assert.Equal(t, "http://proxy.internal:3128", job.profile.s3Config().Transport.ProxyURL)
+	assert.Equal(t, 0, len(job.config.Recipients))
+	assert.Equal(t, "/etc/marmalade/recipients", strings.Join(job.config.RecipientsFiles, ","))
+
+	// without a job, everything comes from the environment
+	jobs, err = loadJobs(path, "", false)
+	assert.NoErr(t, err)
+	assert.Equal(t, "", jobs[0].name)
+	assert.Equal(t, "", jobs[0].config.Source)
+	assert.Equal(t, "other", jobs[0].profile.Bucket)
+
+	t.Setenv("MARMALADE_S3_CONCURRENCY", "many")
+	_, err = loadJobs(path, "postgres", false)
+	assert.ErrContains(t, err, "parse MARMALADE_S3_CONCURRENCY")
+
+	t.Setenv("MARMALADE_S3_CONCURRENCY", "")
+	t.Setenv("MARMALADE_S3_RETRY_BUDGET", "soon")
+	_, err = loadJobs(path, "postgres", false)
+	assert.ErrContains(t, err, "parse MARMALADE_S3_RETRY_BUDGET")
+
+	t.Setenv("MARMALADE_S3_RETRY_BUDGET", "")
+	t.Setenv("MARMALADE_S3_ADDRESSING_STYLE", "sideways")
+	_, err = loadJobs(path, "postgres", false)
+	assert.ErrContains(t, err, "unknown addressing style: sideways")
+
+	t.Setenv("MARMALADE_S3_ADDRESSING_STYLE", "")
+	t.Setenv("MARMALADE_PREFIX", "db")
+	_, err = loadJobs(path, "postgres", false)
+	assert.ErrContains(t, err, `prefix "db" must end in /`)
+}
+
+func TestRunJob(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	source := filepath.Join(t.TempDir(), "dump.sql")
+	err := os.WriteFile(source, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	job := backupJob{
+		name: "postgres",
+		config: jobConfig{
+			Source:     source,
+			Prefix:     "pg/",
+			Schedule:   "1d",
+			Recipients: []string{id.Recipient().String()},
+		},
+		profile: profileConfig{
+			URL:       sv.GetEndpoint(),
+			Region:    "my-region",
+			KeyID:     "keyid",
+			KeySecret: "shh",
+			Bucket:    "my-bucket",
+			Insecure:  true,
+		},
+	}
+
+	fileName := "pg/" + time.Now().UTC().Format("2006-01-02") + ".sql.age"
+
+	// dry run leaves the bucket alone
+	var out bytes.Buffer
+	err = runJob(context.Background(), job, time.Now().UTC(), true, "text", &out)
+	assert.NoErr(t, err)
+	assert.True(t, strings.Contains(out.String(), "upload "+fileName))
+	assert.Equal(t, 0, len(sv.GetVersions(fileName)))
+
+	err = runJob(context.Background(), job, time.Now().UTC(), false, "text", &out)
+	assert.NoErr(t, err)
+	assert.Equal(t, 1, len(sv.GetVersions(fileName)))
+	assert.Equal(t, 1, len(sv.GetVersions(fileName+".sha256")))
+
+	job.config.Schedule = "1x"
+	err = runJob(context.Background(), job, time.Now().UTC(), false, "text", &out)
+	assert.ErrContains(t, err, "parse schedule")
+}
+
+func TestProfileCredentials(t *testing.T) {
+	// keys in the profile are used as they are
+	config := profileConfig{KeyID: "keyid", KeySecret: "shh", SessionToken: "token"}.s3Config()
+	assert.Equal(t, "token", config.SessionToken)
+	assert.True(t, config.Credentials == nil)
+
+	// otherwise they are looked up, starting with the environment
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+	config = profileConfig{CredentialsProfile: "backups"}.s3Config()
+	assert.Equal[s3.CredentialsProvider](t, s3.SharedCredentialsFile{Profile: "backups"}, config.Credentials.(s3.CredentialsChain)[2])
+
+	credentials, err := config.Credentials.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, "env-key", credentials.AccessKeyID)
+
+	// a role is assumed with the keys of the profile
+	config = profileConfig{KeyID: "keyid", KeySecret: "shh", RoleARN: "arn:aws:iam::1:role/backup", ExternalID: "ext"}.s3Config()
+	assert.Equal[s3.CredentialsProvider](t, s3.AssumeRoleCredentials{
+		Source:     s3.StaticCredentials{AccessKeyID: "keyid", SecretAccessKey: "shh"},
+		RoleARN:    "arn:aws:iam::1:role/backup",
+		ExternalID: "ext",
+	}, config.Credentials)
+
+	// or with a web identity token
+	config = profileConfig{RoleARN: "arn:aws:iam::1:role/backup", WebIdentityFile: "/var/run/token", Region: "eu-west-1"}.s3Config()
+	assert.Equal[s3.CredentialsProvider](t, s3.WebIdentityCredentials{RoleARN: "arn:aws:iam::1:role/backup", TokenFile: "/var/run/token", Region: "eu-west-1"}, config.Credentials)
+}
+
+func TestJobEncryption(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "sse.key")
+	err := os.WriteFile(keyFile, []byte(base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))+"\n"), 0600)
+	assert.NoErr(t, err)
+
+	path := writeConfig(t, strings.Replace(testConfig,
+		`"prefix": "pg/",`,
+		`"prefix": "pg/", "sse": "aws:kms", "sse_kms_key_id": "alias/pg", "sse_kms_context": {"job": "postgres"},`, 1))
+
+	jobs, err := loadJobs(path, "postgres", false)
+	assert.NoErr(t, err)
+	config, err := jobs[0].s3Config()
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.SSEKMS, config.Encryption.Algorithm)
+	assert.Equal(t, "alias/pg", config.Encryption.KMSKeyID)
+	assert.Equal(t, "postgres", config.Encryption.KMSContext["job"])
+
+	// other jobs of the profile are not encrypted
+	jobs, err = loadJobs(path, "photos", false)
+	assert.NoErr(t, err)
+	config, err = jobs[0].s3Config()
+	assert.NoErr(t, err)
+	assert.Equal(t, "", config.Encryption.Algorithm)
+
+	// a customer key is read from its file
+	t.Setenv("MARMALADE_SSE_CUSTOMER_KEY_FILE", keyFile)
+	jobs, err = loadJobs(path, "photos", false)
+	assert.NoErr(t, err)
+	config, err = jobs[0].s3Config()
+	assert.NoErr(t, err)
+	assert.Equal(t, string(bytes.Repeat([]byte{7}, 32)), string(config.Encryption.CustomerKey))
+
+	t.Setenv("MARMALADE_SSE_CUSTOMER_KEY_FILE", path)
+	jobs, err = loadJobs(path, "photos", false)
+	assert.NoErr(t, err)
+	_, err = jobs[0].s3Config()
+	assert.ErrContains(t, err, "decode sse customer key")
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/marmalade"
+)
+
+// runDaemon runs the jobs on their cron schedules until ctx is cancelled.
+func runDaemon(ctx context.Context, jobs []backupJob, options marmalade.DaemonOptions) error {
+	daemonJobs := make([]marmalade.DaemonJob, 0, len(jobs))
+	for _, job := range jobs {
+		if job.config.Cron == "" {
+			return fmt.Errorf("job %s: cron is required", job.name)
+		}
+
+		schedule, err := marmalade.ParseCron(job.config.Cron)
+		if err != nil {
+			return fmt.Errorf("job %s: parse cron: %w", job.name, err)
+		}
+
+		daemonJobs = append(daemonJobs, marmalade.DaemonJob{
+			Name:     job.name,
+			Schedule: schedule,
+			Run: func(ctx context.Context, at time.Time) error {
+				return runJob(ctx, job, at.UTC(), false, "text", io.Discard)
+			},
+		})
+	}
+
+	daemon, err := marmalade.NewDaemon(daemonJobs, options)
+	if err != nil {
+		return err
+	}
+
+	return daemon.Run(ctx)
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/marmalade"
+)
+
+func TestRunDaemonChecksCron(t *testing.T) {
+	jobs := []backupJob{{name: "postgres", config: jobConfig{Schedule: "7d"}}}
+
+	err := runDaemon(context.Background(), jobs, marmalade.DaemonOptions{})
+	assert.ErrContains(t, err, "job postgres: cron is required")
+
+	jobs[0].config.Cron = "0 25 * * *"
+	err = runDaemon(context.Background(), jobs, marmalade.DaemonOptions{})
+	assert.ErrContains(t, err, "job postgres: parse cron: hour out of range")
+}
+
+func TestRunDaemonStops(t *testing.T) {
+	jobs := []backupJob{{name: "postgres", config: jobConfig{Schedule: "7d", Cron: "0 3 * * *"}}}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := runDaemon(ctx, jobs, marmalade.DaemonOptions{})
+	assert.NoErr(t, err)
+}
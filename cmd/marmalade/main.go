@@ -1,24 +1,80 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/bradenrayhorn/marmalade/marmalade"
 	"github.com/bradenrayhorn/marmalade/s3"
 )
 
+// Exit codes of the backup command. A partial failure means the backup was uploaded but some locks
+// or deletions failed.
+const (
+	exitFailure        = 1
+	exitPartialFailure = 2
+)
+
 func main() {
 	backupCmd := flag.NewFlagSet("backup", flag.ExitOnError)
-	backupFile := backupCmd.String("f", "", "Path to back up")
+	backupConfig := backupCmd.String("config", os.Getenv("MARMALADE_CONFIG"), "Path to a JSON config file of backup jobs")
+	backupJobName := backupCmd.String("job", "", "Name of the job in the config file to run")
+	backupAll := backupCmd.Bool("all", false, "Run every job in the config file")
+	backupFile := backupCmd.String("f", "", "Path to back up, directories are archived with tar")
+	var backupExcludes stringList
+	backupCmd.Var(&backupExcludes, "exclude", "Pattern of paths to leave out of a directory backup, may be repeated")
+	var backupRecipients, backupRecipientFiles stringList
+	backupCmd.Var(&backupRecipients, "r", "age or ssh public key to encrypt to, may be repeated")
+	backupCmd.Var(&backupRecipientFiles, "R", "Path to an age recipients file, may be repeated")
+	backupCompression := backupCmd.String("compress", "", "Compression applied before encryption, such as gzip or gzip:9, defaults to MARMALADE_COMPRESSION")
+	backupDryRun := backupCmd.Bool("dry-run", false, "Print what the backup would do without changing anything")
+	backupFormat := backupCmd.String("format", "text", "Output format of the run report or -dry-run plan, text or json")
+	backupBypassGovernance := backupCmd.Bool("bypass-governance", false, "Delete backups that are no longer retained even if they are locked in GOVERNANCE mode, for cleaning up after a mistaken schedule")
+
+	restoreCmd := flag.NewFlagSet("restore", flag.ExitOnError)
+	restoreConfig := restoreCmd.String("config", os.Getenv("MARMALADE_CONFIG"), "Path to a JSON config file of backup jobs")
+	restoreJobName := restoreCmd.String("job", "", "Name of the job in the config file to restore from")
+	restoreKey := restoreCmd.String("k", "", "Key of the backup to restore")
+	restoreOutput := restoreCmd.String("o", "", "Path to write the restored file to")
+	restoreIdentityFile := restoreCmd.String("i", "", "Path to an age identity file, defaults to MARMALADE_AGE_IDENTITY")
+	restoreNoWait := restoreCmd.Bool("no-wait", false, "Start restoring an archived backup from its storage class and exit, instead of waiting for it to be ready")
+	restoreThawTier := restoreCmd.String("thaw-tier", s3.RestoreTierStandard, "Retrieval tier of archived backups, Expedited, Standard or Bulk")
+	restoreThawDays := restoreCmd.Int("thaw-days", marmalade.DefaultThawDays, "Days to keep the restored copy of an archived backup")
+
+	verifyCmd := flag.NewFlagSet("verify", flag.ExitOnError)
+	verifyConfig := verifyCmd.String("config", os.Getenv("MARMALADE_CONFIG"), "Path to a JSON config file of backup jobs")
+	verifyJobName := verifyCmd.String("job", "", "Name of the job in the config file to verify a backup of")
+	verifyKey := verifyCmd.String("k", "", "Key of the backup to verify")
+	verifyNoWait := verifyCmd.Bool("no-wait", false, "Start restoring an archived backup from its storage class and exit, instead of waiting for it to be ready")
+	verifyThawTier := verifyCmd.String("thaw-tier", s3.RestoreTierStandard, "Retrieval tier of archived backups, Expedited, Standard or Bulk")
+	verifyThawDays := verifyCmd.Int("thaw-days", marmalade.DefaultThawDays, "Days to keep the restored copy of an archived backup")
+
+	daemonCmd := flag.NewFlagSet("daemon", flag.ExitOnError)
+	daemonConfig := daemonCmd.String("config", os.Getenv("MARMALADE_CONFIG"), "Path to a JSON config file of backup jobs")
+	daemonJitter := daemonCmd.Duration("jitter", 0, "Delay each run by a random duration up to this")
+	daemonShutdownTimeout := daemonCmd.Duration("shutdown-timeout", 5*time.Minute, "How long in-flight backups may take to finish on shutdown before they are aborted")
+	daemonState := daemonCmd.String("state", os.Getenv("MARMALADE_STATE"), "Path to a file recording when jobs last ran, so missed runs are caught up after downtime")
 
 	// Check if a command was provided
 	if len(os.Args) < 2 {
-		fmt.Println("Expected 'backup' command")
+		fmt.Println("Expected 'backup', 'restore', 'verify' or 'daemon' command")
 		os.Exit(1)
 	}
 
+	// Cancel on SIGINT or SIGTERM. The daemon lets in-flight backups finish first, a second signal
+	// exits straight away.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
 	// Parse the command
 	switch os.Args[1] {
 	case "backup":
@@ -26,19 +82,177 @@ func main() {
 			backupCmd.PrintDefaults()
 			os.Exit(1)
 		}
-		if *backupFile == "" {
-			fmt.Println("backup: -f flags are required")
-			backupCmd.PrintDefaults()
+
+		jobs, err := loadJobs(*backupConfig, *backupJobName, *backupAll)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		if *backupFile != "" && len(jobs) > 1 {
+			fmt.Println("backup: -f cannot be used with -all")
+			os.Exit(1)
+		}
+
+		// Flags take precedence over the config file and the environment.
+		for i := range jobs {
+			job := &jobs[i]
+			if *backupFile != "" {
+				job.config.Source = *backupFile
+			}
+			if len(backupExcludes) > 0 {
+				job.config.Exclude = backupExcludes
+			}
+			if len(backupRecipients) > 0 || len(backupRecipientFiles) > 0 {
+				job.config.Recipients = backupRecipients
+				job.config.RecipientsFiles = backupRecipientFiles
+			}
+			if *backupCompression != "" {
+				job.config.Compression = *backupCompression
+			}
+			job.bypassGovernance = *backupBypassGovernance
+
+			if job.config.Source == "" {
+				if job.name == "" {
+					fmt.Println("backup: -f flags are required")
+					backupCmd.PrintDefaults()
+				} else {
+					fmt.Printf("job %s: source is required\n", job.name)
+				}
+				os.Exit(1)
+			}
+		}
+
+		// Keep going after a failed job so one broken job does not stop the others.
+		exitCode := 0
+		for _, job := range jobs {
+			err := runJob(ctx, job, time.Now().UTC(), *backupDryRun, *backupFormat, os.Stdout)
+			if err == nil {
+				continue
+			}
+
+			if job.name != "" {
+				fmt.Fprintf(os.Stderr, "job %s: %v\n", job.name, err)
+			} else {
+				fmt.Fprintln(os.Stderr, err)
+			}
+
+			// A job that failed outright outweighs partial failures of other jobs.
+			if !errors.Is(err, marmalade.ErrPartialFailure) {
+				exitCode = exitFailure
+			} else if exitCode == 0 {
+				exitCode = exitPartialFailure
+			}
+		}
+		if exitCode != 0 {
+			os.Exit(exitCode)
+		}
+
+		return
+	case "restore":
+		if err := restoreCmd.Parse(os.Args[2:]); err != nil {
+			restoreCmd.PrintDefaults()
+			os.Exit(1)
+		}
+		if *restoreKey == "" || *restoreOutput == "" {
+			fmt.Println("restore: -k and -o flags are required")
+			restoreCmd.PrintDefaults()
+			os.Exit(1)
+		}
+
+		identity := os.Getenv("MARMALADE_AGE_IDENTITY")
+		if *restoreIdentityFile != "" {
+			data, err := os.ReadFile(*restoreIdentityFile)
+			if err != nil {
+				fmt.Printf("read identity file: %v\n", err)
+				os.Exit(1)
+			}
+			identity = string(data)
+		}
+
+		jobs, err := loadJobs(*restoreConfig, *restoreJobName, false)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		job := jobs[0]
+
+		key := job.config.Prefix + *restoreKey
+
+		s3config, err := job.s3Config()
+		if err == nil {
+			err = downloadAndRestore(ctx, s3config, key, *restoreOutput, identity, marmalade.ThawOptions{
+				Days:   *restoreThawDays,
+				Tier:   *restoreThawTier,
+				NoWait: *restoreNoWait,
+			})
+		}
+		if errors.Is(err, marmalade.ErrThawing) {
+			fmt.Printf("%v, run restore again once it is ready\n", err)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		return
+	case "verify":
+		if err := verifyCmd.Parse(os.Args[2:]); err != nil {
+			verifyCmd.PrintDefaults()
+			os.Exit(1)
+		}
+		if *verifyKey == "" {
+			fmt.Println("verify: -k flag is required")
+			verifyCmd.PrintDefaults()
 			os.Exit(1)
 		}
 
-		schedule, err := marmalade.ParseSchedule(os.Getenv("MARMALADE_SCHEDULE"))
+		jobs, err := loadJobs(*verifyConfig, *verifyJobName, false)
 		if err != nil {
-			fmt.Printf("parse schedule: %v\n", err)
+			fmt.Println(err)
 			os.Exit(1)
 		}
+		job := jobs[0]
 
-		err = encryptAndBackup(loadConfig(), schedule, *backupFile, os.Getenv("MARMALADE_AGE_PUBLIC_KEY"))
+		key := job.config.Prefix + *verifyKey
+
+		s3config, err := job.s3Config()
+		if err == nil {
+			err = downloadAndVerify(ctx, s3config, key, marmalade.ThawOptions{
+				Days:   *verifyThawDays,
+				Tier:   *verifyThawTier,
+				NoWait: *verifyNoWait,
+			})
+		}
+		if errors.Is(err, marmalade.ErrThawing) {
+			fmt.Printf("%v, run verify again once it is ready\n", err)
+			return
+		}
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		fmt.Printf("%s verified\n", key)
+		return
+	case "daemon":
+		if err := daemonCmd.Parse(os.Args[2:]); err != nil {
+			daemonCmd.PrintDefaults()
+			os.Exit(1)
+		}
+
+		jobs, err := loadJobs(*daemonConfig, "", true)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+
+		err = runDaemon(ctx, jobs, marmalade.DaemonOptions{
+			Jitter:          *daemonJitter,
+			ShutdownTimeout: *daemonShutdownTimeout,
+			StatePath:       *daemonState,
+		})
 		if err != nil {
 			fmt.Println(err)
 			os.Exit(1)
@@ -47,20 +261,7 @@ func main() {
 		return
 	default:
 		fmt.Printf("Unknown command: %s\n", os.Args[1])
-		fmt.Println("Expected 'backup' command")
+		fmt.Println("Expected 'backup', 'restore', 'verify' or 'daemon' command")
 		os.Exit(1)
 	}
 }
-
-func loadConfig() s3.Config {
-	config := s3.Config{
-		URL:          os.Getenv("MARMALADE_S3_URL"),
-		Region:       os.Getenv("MARMALADE_S3_REGION"),
-		KeyID:        os.Getenv("MARMALADE_S3_KEY_ID"),
-		KeySecret:    os.Getenv("MARMALADE_S3_KEY_SECRET"),
-		Bucket:       os.Getenv("MARMALADE_S3_BUCKET"),
-		StorageClass: os.Getenv("MARMALADE_S3_STORAGE_CLASS"),
-	}
-
-	return config
-}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/agessh"
+)
+
+// parseRecipients parses one recipient per line, in the format of an age recipients file. Blank
+// lines and lines starting with # are ignored. Both age X25519 and ssh-ed25519/ssh-rsa public
+// keys are accepted.
+func parseRecipients(source string, r io.Reader) ([]age.Recipient, error) {
+	recipients := []age.Recipient{}
+
+	scanner := bufio.NewScanner(r)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		recipient, err := parseRecipient(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", source, lineNumber, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read %s: %w", source, err)
+	}
+
+	return recipients, nil
+}
+
+func parseRecipient(s string) (age.Recipient, error) {
+	switch {
+	case strings.HasPrefix(s, "age1"):
+		return age.ParseX25519Recipient(s)
+	case strings.HasPrefix(s, "ssh-"):
+		return agessh.ParseRecipient(s)
+	default:
+		kind, _, _ := strings.Cut(s, " ")
+		if len(kind) > 12 {
+			kind = kind[:12] + "..."
+		}
+		return nil, fmt.Errorf("unknown recipient type %q, expected an age1 or ssh- public key", kind)
+	}
+}
+
+// loadRecipients gathers recipients from the given keys and recipients files.
+func loadRecipients(keys []string, files []string) ([]age.Recipient, error) {
+	recipients := []age.Recipient{}
+
+	for i, key := range keys {
+		parsed, err := parseRecipients(fmt.Sprintf("recipient %d", i+1), strings.NewReader(key))
+		if err != nil {
+			return nil, err
+		}
+		recipients = append(recipients, parsed...)
+	}
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, fmt.Errorf("open recipients file: %w", err)
+		}
+
+		parsed, err := parseRecipients(file, f)
+		_ = f.Close()
+		if err != nil {
+			return nil, err
+		}
+		if len(parsed) == 0 {
+			return nil, fmt.Errorf("recipients file %s has no recipients", file)
+		}
+		recipients = append(recipients, parsed...)
+	}
+
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no age recipients configured")
+	}
+
+	return recipients, nil
+}
+
+// parseIdentities parses an age identity file, or an unencrypted ssh private key.
+func parseIdentities(data string) ([]age.Identity, error) {
+	if strings.Contains(data, "-----BEGIN") {
+		identity, err := agessh.ParseIdentity([]byte(data))
+		if err != nil {
+			return nil, err
+		}
+		return []age.Identity{identity}, nil
+	}
+
+	return age.ParseIdentities(strings.NewReader(data))
+}
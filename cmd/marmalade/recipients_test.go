@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func generateSSHKey(t *testing.T) (string, string) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoErr(t, err)
+
+	sshPublic, err := ssh.NewPublicKey(public)
+	assert.NoErr(t, err)
+
+	block, err := ssh.MarshalPrivateKey(private, "")
+	assert.NoErr(t, err)
+
+	return string(ssh.MarshalAuthorizedKey(sshPublic)), string(pem.EncodeToMemory(block))
+}
+
+func TestParseRecipients(t *testing.T) {
+	first, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+	second, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+	sshPublic, sshPrivate := generateSSHKey(t)
+
+	file := "# ops key\n" + first.Recipient().String() + "\n\n  # escrow\n" + second.Recipient().String() + "\n" + sshPublic
+	recipients, err := parseRecipients("recipients.txt", strings.NewReader(file))
+	assert.NoErr(t, err)
+	assert.Equal(t, 3, len(recipients))
+
+	// every recipient can decrypt
+	var buf bytes.Buffer
+	w, err := age.Encrypt(&buf, recipients...)
+	assert.NoErr(t, err)
+	_, err = w.Write([]byte("abc"))
+	assert.NoErr(t, err)
+	assert.NoErr(t, w.Close())
+
+	sshIdentities, err := parseIdentities(sshPrivate)
+	assert.NoErr(t, err)
+
+	for _, identities := range [][]age.Identity{{first}, {second}, sshIdentities} {
+		r, err := age.Decrypt(bytes.NewReader(buf.Bytes()), identities...)
+		assert.NoErr(t, err)
+		data, err := io.ReadAll(r)
+		assert.NoErr(t, err)
+		assert.Equal(t, "abc", string(data))
+	}
+
+	// invalid entries report where they are
+	_, err = parseRecipients("recipients.txt", strings.NewReader(first.Recipient().String()+"\nage1invalid\n"))
+	assert.ErrContains(t, err, "recipients.txt line 2: malformed recipient")
+
+	_, err = parseRecipients("recipients.txt", strings.NewReader("pgp-key abc"))
+	assert.ErrContains(t, err, `recipients.txt line 1: unknown recipient type "pgp-key"`)
+
+	_, err = parseRecipients("recipients.txt", strings.NewReader("ssh-ed25519 notbase64"))
+	assert.ErrContains(t, err, "recipients.txt line 1:")
+}
+
+func TestLoadRecipients(t *testing.T) {
+	first, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+	second, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	file := filepath.Join(t.TempDir(), "recipients.txt")
+	err = os.WriteFile(file, []byte(second.Recipient().String()+"\n"), 0600)
+	assert.NoErr(t, err)
+
+	recipients, err := loadRecipients([]string{first.Recipient().String()}, []string{file})
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(recipients))
+
+	_, err = loadRecipients([]string{}, []string{})
+	assert.ErrContains(t, err, "no age recipients configured")
+
+	empty := filepath.Join(t.TempDir(), "empty.txt")
+	err = os.WriteFile(empty, []byte("# nothing\n"), 0600)
+	assert.NoErr(t, err)
+	_, err = loadRecipients([]string{}, []string{empty})
+	assert.ErrContains(t, err, "has no recipients")
+
+	_, err = loadRecipients([]string{}, []string{filepath.Join(t.TempDir(), "missing.txt")})
+	assert.ErrContains(t, err, "open recipients file")
+
+	_, err = loadRecipients([]string{"nope"}, []string{})
+	assert.ErrContains(t, err, "recipient 1 line 1")
+}
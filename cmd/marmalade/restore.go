@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/bradenrayhorn/marmalade/marmalade"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func downloadAndRestore(ctx context.Context, s3config s3.Config, key, outputPath, ageIdentity string, thaw marmalade.ThawOptions) error {
+	client := s3.NewClient(s3config)
+
+	identities, err := parseIdentities(ageIdentity)
+	if err != nil {
+		return fmt.Errorf("age identity: %w", err)
+	}
+
+	// Archived backups are restored to a readable copy first, which can take hours.
+	if err := marmalade.ThawContext(ctx, client, key, thaw); err != nil {
+		return fmt.Errorf("thaw: %w", err)
+	}
+
+	// The compression of the backup is recorded in its metadata.
+	head, err := client.HeadObjectContext(ctx, key)
+	if err != nil {
+		if s3.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+			return fmt.Errorf("backup %s not found: %w", key, err)
+		}
+		return fmt.Errorf("head object: %w", err)
+	}
+
+	// Write into a temporary file next to the output so it can be atomically renamed into place.
+	output, err := os.CreateTemp(filepath.Dir(outputPath), "."+filepath.Base(outputPath)+".marmalade-*")
+	if err != nil {
+		return fmt.Errorf("create temporary file: %w", err)
+	}
+	defer func() {
+		_ = output.Close()
+		_ = os.Remove(output.Name())
+	}()
+
+	err = marmalade.RestoreContext(ctx, client, key, func(r io.Reader) error {
+		return decrypt(identities, strings.TrimSuffix(key, ".age"), head.Metadata, r, output)
+	})
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	if err := output.Sync(); err != nil {
+		return fmt.Errorf("sync %s: %w", output.Name(), err)
+	}
+	if err := output.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", output.Name(), err)
+	}
+	if err := os.Rename(output.Name(), outputPath); err != nil {
+		return fmt.Errorf("rename to %s: %w", outputPath, err)
+	}
+
+	return nil
+}
+
+// downloadAndVerify checks that the backup at key can be restored, by downloading it and checking
+// its hash file and the checksum S3 stored. It is not decrypted, so no identity is needed.
+func downloadAndVerify(ctx context.Context, s3config s3.Config, key string, thaw marmalade.ThawOptions) error {
+	client := s3.NewClient(s3config)
+
+	// Archived backups are restored to a readable copy first, which can take hours.
+	if err := marmalade.ThawContext(ctx, client, key, thaw); err != nil {
+		return fmt.Errorf("thaw: %w", err)
+	}
+
+	if err := marmalade.VerifyContext(ctx, client, key); err != nil {
+		return fmt.Errorf("verify: %w", err)
+	}
+
+	return nil
+}
+
+// decrypt writes the plaintext of src to dst, reversing any compression recorded in metadata, or
+// named by the extension of archiveName for older backups.
+func decrypt(identities []age.Identity, archiveName string, metadata map[string]string, src io.Reader, dst io.Writer) error {
+	r, err := age.Decrypt(src, identities...)
+	if err != nil {
+		return fmt.Errorf("age decrypt: %w", err)
+	}
+
+	decompressed, err := decompressorFor(metadata, archiveName, r)
+	if err != nil {
+		return fmt.Errorf("decompress: %w", err)
+	}
+	defer func() { _ = decompressed.Close() }()
+
+	if _, err := io.Copy(dst, decompressed); err != nil {
+		return fmt.Errorf("copy from age: %w", err)
+	}
+
+	return nil
+}
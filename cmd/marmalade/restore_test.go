@@ -0,0 +1,171 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"filippo.io/age"
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/marmalade"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestRestore(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	s3config := s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "data.txt")
+	err = os.WriteFile(source, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, time.Now().UTC(), marmalade.BackupOptions{}, source, archiveOptions{}, []age.Recipient{id.Recipient()})
+	assert.NoErr(t, err)
+
+	// restore the backup
+	key := time.Now().UTC().Format("2006-01-02") + ".txt.age"
+	output := filepath.Join(dir, "restored.txt")
+	err = downloadAndRestore(context.Background(), s3config, key, output, id.String(), marmalade.ThawOptions{})
+	assert.NoErr(t, err)
+
+	restored, err := os.ReadFile(output)
+	assert.NoErr(t, err)
+	assert.Equal(t, "abc", string(restored))
+
+	// restoring with the wrong identity leaves no output behind
+	otherID, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	output = filepath.Join(dir, "restored-2.txt")
+	err = downloadAndRestore(context.Background(), s3config, key, output, otherID.String(), marmalade.ThawOptions{})
+	assert.ErrContains(t, err, "age decrypt")
+
+	entries, err := os.ReadDir(dir)
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(entries))
+}
+
+func TestRestoreArchived(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	s3config := s3.Config{
+		URL:          sv.GetEndpoint(),
+		Region:       "my-region",
+		KeyID:        "keyid",
+		KeySecret:    "shh",
+		Bucket:       "my-bucket",
+		Insecure:     true,
+		StorageClass: "GLACIER",
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "data.txt")
+	err = os.WriteFile(source, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	now := time.Now().UTC()
+	sv.SetNow(now)
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, now, marmalade.BackupOptions{}, source, archiveOptions{}, []age.Recipient{id.Recipient()})
+	assert.NoErr(t, err)
+
+	// -no-wait only starts the thaw
+	key := now.Format("2006-01-02") + ".txt.age"
+	output := filepath.Join(dir, "restored.txt")
+	err = downloadAndRestore(context.Background(), s3config, key, output, id.String(), marmalade.ThawOptions{NoWait: true})
+	assert.ErrIs(t, err, marmalade.ErrThawing)
+	_, err = os.Stat(output)
+	assert.True(t, os.IsNotExist(err))
+
+	// once the thaw is done it can be restored
+	sv.SetNow(now.Add(fakes3.DefaultRestoreDelay))
+	err = downloadAndRestore(context.Background(), s3config, key, output, id.String(), marmalade.ThawOptions{NoWait: true})
+	assert.NoErr(t, err)
+
+	restored, err := os.ReadFile(output)
+	assert.NoErr(t, err)
+	assert.Equal(t, "abc", string(restored))
+}
+
+func TestVerifyArchived(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	s3config := s3.Config{
+		URL:          sv.GetEndpoint(),
+		Region:       "my-region",
+		KeyID:        "keyid",
+		KeySecret:    "shh",
+		Bucket:       "my-bucket",
+		Insecure:     true,
+		StorageClass: "GLACIER",
+	}
+
+	schedule, err := marmalade.ParseSchedule("1d")
+	assert.NoErr(t, err)
+
+	dir := t.TempDir()
+	source := filepath.Join(dir, "data.txt")
+	err = os.WriteFile(source, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	id, err := age.GenerateX25519Identity()
+	assert.NoErr(t, err)
+
+	now := time.Now().UTC()
+	sv.SetNow(now)
+	_, err = encryptAndBackup(context.Background(), s3config, schedule, now, marmalade.BackupOptions{}, source, archiveOptions{}, []age.Recipient{id.Recipient()})
+	assert.NoErr(t, err)
+
+	// -no-wait only starts the thaw
+	key := now.Format("2006-01-02") + ".txt.age"
+	err = downloadAndVerify(context.Background(), s3config, key, marmalade.ThawOptions{NoWait: true})
+	assert.ErrIs(t, err, marmalade.ErrThawing)
+
+	// once the thaw is done it is verified without an identity, and nothing is written
+	sv.SetNow(now.Add(fakes3.DefaultRestoreDelay))
+	err = downloadAndVerify(context.Background(), s3config, key, marmalade.ThawOptions{NoWait: true})
+	assert.NoErr(t, err)
+
+	entries, err := os.ReadDir(dir)
+	assert.NoErr(t, err)
+	assert.Equal(t, 1, len(entries))
+
+	// a corrupted backup fails verification
+	versions := sv.GetVersions(key)
+	versions[len(versions)-1].Content[0] ^= 1
+	err = downloadAndVerify(context.Background(), s3config, key, marmalade.ThawOptions{NoWait: true})
+	assert.ErrIs(t, err, s3.ErrChecksumMismatch)
+}
@@ -2,9 +2,12 @@ module github.com/bradenrayhorn/marmalade
 
 go 1.24.4
 
-require filippo.io/age v1.2.1
+require (
+	filippo.io/age v1.2.1
+	golang.org/x/crypto v0.24.0
+)
 
 require (
-	golang.org/x/crypto v0.24.0 // indirect
+	filippo.io/edwards25519 v1.1.0 // indirect
 	golang.org/x/sys v0.21.0 // indirect
 )
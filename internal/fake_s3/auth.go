@@ -0,0 +1,155 @@
+package fakes3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Key is an access key the server accepts. If SessionToken is set, requests signed with the key
+// must also send it in x-amz-security-token.
+type Key struct {
+	ID           string
+	Secret       string
+	SessionToken string
+}
+
+// SetCredentials makes the server check that requests are signed with the given keys, replacing
+// any keys added before.
+func (s *FakeS3) SetCredentials(keyID, secret, sessionToken string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = []Key{{ID: keyID, Secret: secret, SessionToken: sessionToken}}
+}
+
+// AddKey accepts another key, such as temporary credentials handed out by a fake STS.
+func (s *FakeS3) AddKey(key Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.keys = append(s.keys, key)
+}
+
+// checkSignature writes an error response and returns false if the request is not signed by one
+// of the configured keys.
+func (s *FakeS3) checkSignature(w http.ResponseWriter, r *http.Request) bool {
+	s.mu.RLock()
+	keys := s.keys
+	s.mu.RUnlock()
+
+	if keys == nil {
+		return true
+	}
+
+	if code, message := VerifySignature(r, keys); code != "" {
+		WriteError(w, http.StatusForbidden, code, message)
+		return false
+	}
+	return true
+}
+
+// VerifySignature checks that r has a SigV4 signature from one of keys. If it does not, it returns
+// the S3 error code and message to respond with.
+func VerifySignature(r *http.Request, keys []Key) (code string, message string) {
+	// AWS4-HMAC-SHA256 Credential=KEY/DATE/REGION/SERVICE/aws4_request, SignedHeaders=a;b, Signature=HEX
+	fields := map[string]string{}
+	algorithm, params, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	for _, param := range strings.Split(params, ",") {
+		if key, value, ok := strings.Cut(strings.TrimSpace(param), "="); ok {
+			fields[key] = value
+		}
+	}
+	scope := strings.Split(fields["Credential"], "/")
+	if algorithm != "AWS4-HMAC-SHA256" || len(scope) != 5 {
+		return "AccessDenied", "Request is not signed."
+	}
+
+	i := slices.IndexFunc(keys, func(k Key) bool { return k.ID == scope[0] })
+	if i == -1 {
+		return "InvalidAccessKeyId", "The AWS Access Key Id you provided does not exist in our records."
+	}
+	key := keys[i]
+
+	if r.Header.Get("x-amz-security-token") != key.SessionToken {
+		return "InvalidToken", "The provided token is malformed or otherwise invalid."
+	}
+
+	signedHeaders := strings.Split(fields["SignedHeaders"], ";")
+	if key.SessionToken != "" && !slices.Contains(signedHeaders, "x-amz-security-token") {
+		return "AccessDenied", "x-amz-security-token must be signed."
+	}
+
+	canonicalHeaders := ""
+	for _, name := range signedHeaders {
+		value := strings.Join(r.Header.Values(name), ",")
+		if name == "host" {
+			value = r.Host
+		}
+		canonicalHeaders += name + ":" + value + "\n"
+	}
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		r.URL.EscapedPath(),
+		canonicalQuery(r),
+		canonicalHeaders,
+		fields["SignedHeaders"],
+		r.Header.Get("x-amz-content-sha256"),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+
+	stringToSign := strings.Join([]string{
+		algorithm,
+		r.Header.Get("x-amz-date"),
+		strings.Join(scope[1:], "/"),
+		hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	signingKey := []byte("AWS4" + key.Secret)
+	for _, part := range scope[1:] {
+		signingKey = sign(signingKey, part)
+	}
+	expected := hex.EncodeToString(sign(signingKey, stringToSign))
+
+	if !hmac.Equal([]byte(expected), []byte(fields["Signature"])) {
+		return "SignatureDoesNotMatch", "The request signature we calculated does not match the signature you provided."
+	}
+	return "", ""
+}
+
+// canonicalQuery sorts the query of r by key and value, escaping everything but unreserved
+// characters.
+func canonicalQuery(r *http.Request) string {
+	query := r.URL.Query()
+	parts := []string{}
+	for _, key := range slices.Sorted(maps.Keys(query)) {
+		for _, value := range slices.Sorted(slices.Values(query[key])) {
+			parts = append(parts, escape(key)+"="+escape(value))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sign(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
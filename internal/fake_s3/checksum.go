@@ -0,0 +1,49 @@
+package fakes3
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+func checksumSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// compositeChecksum is the checksum of a multipart upload made of parts.
+func compositeChecksum(parts [][]byte) string {
+	hash := sha256.New()
+	for _, part := range parts {
+		sum := sha256.Sum256(part)
+		hash.Write(sum[:])
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(hash.Sum(nil)), len(parts))
+}
+
+// checkChecksum writes an error response and returns false if the x-amz-checksum-sha256 header of
+// r, when there is one, does not match body.
+func checkChecksum(w http.ResponseWriter, r *http.Request, body []byte) bool {
+	checksum := r.Header.Get("x-amz-checksum-sha256")
+	if checksum != "" && checksum != checksumSHA256(body) {
+		WriteError(w, http.StatusBadRequest, "BadDigest", "The SHA256 you specified did not match the calculated checksum.")
+		return false
+	}
+	return true
+}
+
+// writeChecksumHeaders adds the checksum of obj to a response if the request asked for it.
+func writeChecksumHeaders(w http.ResponseWriter, r *http.Request, obj *ObjectVersion) {
+	if r.Header.Get("x-amz-checksum-mode") != "ENABLED" || obj.ChecksumSHA256 == "" {
+		return
+	}
+
+	w.Header().Set("x-amz-checksum-sha256", obj.ChecksumSHA256)
+	if strings.Contains(obj.ChecksumSHA256, "-") {
+		w.Header().Set("x-amz-checksum-type", "COMPOSITE")
+	} else {
+		w.Header().Set("x-amz-checksum-type", "FULL_OBJECT")
+	}
+}
@@ -0,0 +1,103 @@
+package fakes3
+
+import (
+	"encoding/xml"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+type copyObjectResult struct {
+	XMLName        xml.Name  `xml:"CopyObjectResult"`
+	Xmlns          string    `xml:"xmlns,attr"`
+	ETag           string    `xml:"ETag"`
+	LastModified   time.Time `xml:"LastModified"`
+	ChecksumSHA256 string    `xml:"ChecksumSHA256,omitempty"`
+}
+
+func (s *FakeS3) handleCopyObject(w http.ResponseWriter, r *http.Request, key string) {
+	// x-amz-copy-source: /{bucket}/{key}?versionId={versionID}
+	source, rawQuery, _ := strings.Cut(r.Header.Get("x-amz-copy-source"), "?")
+	source, err := url.PathUnescape(source)
+	query, queryErr := url.ParseQuery(rawQuery)
+	if err != nil || queryErr != nil {
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey.")
+		return
+	}
+	sourceBucket, sourceKey, ok := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !ok || sourceKey == "" {
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "Copy Source must mention the source bucket and key: sourcebucket/sourcekey.")
+		return
+	}
+	if sourceBucket != s.bucket {
+		WriteError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
+		return
+	}
+
+	encryption, ok := encryptionHeaders(w, r)
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions := s.objects[sourceKey]
+	var sourceObj *ObjectVersion
+	if versionID := query.Get("versionId"); versionID != "" {
+		sourceObj = versions[versionID]
+		if sourceObj == nil {
+			WriteError(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.")
+			return
+		}
+	} else {
+		sourceObj = latestVersion(versions)
+	}
+	if sourceObj == nil || sourceObj.DeleteMarker {
+		WriteError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	if !checkCopySourceKey(w, r, sourceObj.Encryption) {
+		return
+	}
+	if !s.readable(sourceObj) {
+		WriteError(w, http.StatusForbidden, "InvalidObjectState", "The operation is not valid for the object's storage class.")
+		return
+	}
+
+	obj := &ObjectVersion{
+		Key:          key,
+		VersionID:    s.generateVersionID(),
+		Content:      slices.Clone(sourceObj.Content),
+		LastModified: s.now,
+		StorageClass: storageClassHeader(r),
+		Retention:    retentionHeaders(r),
+		Encryption:   encryption,
+		Metadata:     maps.Clone(sourceObj.Metadata),
+	}
+	if r.Header.Get("x-amz-metadata-directive") == "REPLACE" {
+		obj.Metadata = metadataHeaders(r)
+	}
+	if r.Header.Get("x-amz-checksum-algorithm") == "SHA256" {
+		obj.ChecksumSHA256 = checksumSHA256(obj.Content)
+	}
+
+	if _, exists := s.objects[key]; !exists {
+		s.objects[key] = make(map[string]*ObjectVersion)
+	}
+	s.objects[key][obj.VersionID] = obj
+
+	w.Header().Set("x-amz-version-id", obj.VersionID)
+	w.Header().Set("x-amz-copy-source-version-id", sourceObj.VersionID)
+	writeEncryptionHeaders(w, encryption)
+	writeXML(w, copyObjectResult{
+		Xmlns:          "http://s3.amazonaws.com/doc/2006-03-01/",
+		ETag:           etag(obj.Content),
+		LastModified:   obj.LastModified,
+		ChecksumSHA256: obj.ChecksumSHA256,
+	})
+}
@@ -29,7 +29,7 @@ type deletedError struct {
 func (s *FakeS3) handleDeleteObjects(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading body: %v", err), http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Error reading body: %v", err))
 		return
 	}
 
@@ -43,7 +43,7 @@ func (s *FakeS3) handleDeleteObjects(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := xml.Unmarshal(body, &deleteReq); err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing XML: %v", err), http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "MalformedXML", fmt.Sprintf("Error parsing XML: %v", err))
 		return
 	}
 
@@ -62,12 +62,12 @@ func (s *FakeS3) handleDeleteObjects(w http.ResponseWriter, r *http.Request) {
 			if versionID != "" {
 				// Delete specific version
 				if version, versionExists := versions[versionID]; versionExists {
-					if version.Retention != nil && version.Retention.Until.After(s.now) {
+					if message := s.lockError(r, version); message != "" {
 						result.Error = append(result.Error, deletedError{
 							Key:       key,
 							VersionID: versionID,
 							Code:      "ObjectLocked",
-							Message:   "Object is locked",
+							Message:   message,
 						})
 						continue
 					}
@@ -111,7 +111,7 @@ func (s *FakeS3) handleDeleteObjects(w http.ResponseWriter, r *http.Request) {
 	encoder := xml.NewEncoder(w)
 	encoder.Indent("", "  ")
 	if err := encoder.Encode(result); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding XML: %v", err), http.StatusInternalServerError)
+		WriteError(w, http.StatusInternalServerError, "InternalError", fmt.Sprintf("Error encoding XML: %v", err))
 		return
 	}
 }
@@ -0,0 +1,136 @@
+package fakes3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+)
+
+// Encryption is the server-side encryption an object was stored with.
+type Encryption struct {
+	Algorithm  string // AES256 or aws:kms, empty for SSE-C
+	KMSKeyID   string
+	KMSContext map[string]string
+
+	// CustomerKeyMD5 is the base64 MD5 of the SSE-C key, which must be sent again to use the object.
+	CustomerKeyMD5 string
+}
+
+// encryptionHeaders reads the encryption of a request that creates an object. It writes an error
+// response and returns false if the headers are invalid.
+func encryptionHeaders(w http.ResponseWriter, r *http.Request) (Encryption, bool) {
+	encryption := Encryption{
+		Algorithm: r.Header.Get("x-amz-server-side-encryption"),
+		KMSKeyID:  r.Header.Get("x-amz-server-side-encryption-aws-kms-key-id"),
+	}
+
+	keyMD5, ok := customerKeyHeaders(w, r)
+	if !ok {
+		return Encryption{}, false
+	}
+	encryption.CustomerKeyMD5 = keyMD5
+
+	switch {
+	case encryption.Algorithm != "" && encryption.Algorithm != "AES256" && encryption.Algorithm != "aws:kms":
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "The encryption method specified is not supported.")
+		return Encryption{}, false
+	case encryption.Algorithm != "" && keyMD5 != "":
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "Server Side Encryption with Customer provided key is incompatible with the encryption method specified.")
+		return Encryption{}, false
+	case encryption.Algorithm != "aws:kms" && (encryption.KMSKeyID != "" || r.Header.Get("x-amz-server-side-encryption-context") != ""):
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "Server Side Encryption with KMS managed key requires HTTP header x-amz-server-side-encryption : aws:kms.")
+		return Encryption{}, false
+	}
+
+	if context := r.Header.Get("x-amz-server-side-encryption-context"); context != "" {
+		data, err := base64.StdEncoding.DecodeString(context)
+		if err != nil || json.Unmarshal(data, &encryption.KMSContext) != nil {
+			WriteError(w, http.StatusBadRequest, "InvalidArgument", "The encryption context must be base64 encoded JSON.")
+			return Encryption{}, false
+		}
+	}
+
+	return encryption, true
+}
+
+// customerKeyHeaders checks the SSE-C headers of a request, if there are any, and returns the MD5
+// of the key. It writes an error response and returns false if they are inconsistent.
+func customerKeyHeaders(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return customerKey(w, r, "x-amz-server-side-encryption-customer-")
+}
+
+// copySourceKeyHeaders is like customerKeyHeaders for the key of the source of a copy.
+func copySourceKeyHeaders(w http.ResponseWriter, r *http.Request) (string, bool) {
+	return customerKey(w, r, "x-amz-copy-source-server-side-encryption-customer-")
+}
+
+func customerKey(w http.ResponseWriter, r *http.Request, prefix string) (string, bool) {
+	algorithm := r.Header.Get(prefix + "algorithm")
+	key := r.Header.Get(prefix + "key")
+	keyMD5 := r.Header.Get(prefix + "key-MD5")
+	if algorithm == "" && key == "" && keyMD5 == "" {
+		return "", true
+	}
+
+	if algorithm != "AES256" {
+		WriteError(w, http.StatusBadRequest, "InvalidEncryptionAlgorithmError", "The encryption request that you specified is not valid. The valid value is AES256.")
+		return "", false
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil || len(decoded) != 32 {
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "The secret key was invalid for the specified algorithm.")
+		return "", false
+	}
+
+	sum := md5.Sum(decoded)
+	if base64.StdEncoding.EncodeToString(sum[:]) != keyMD5 {
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "The calculated MD5 hash of the key did not match the hash that was provided.")
+		return "", false
+	}
+
+	return keyMD5, true
+}
+
+// checkCustomerKey writes an error response and returns false unless the request has the SSE-C
+// key that encryption was stored with. Objects stored without one must not be sent a key.
+func checkCustomerKey(w http.ResponseWriter, r *http.Request, encryption Encryption) bool {
+	keyMD5, ok := customerKeyHeaders(w, r)
+	return ok && matchCustomerKey(w, keyMD5, encryption)
+}
+
+// checkCopySourceKey is like checkCustomerKey for the source of a copy.
+func checkCopySourceKey(w http.ResponseWriter, r *http.Request, encryption Encryption) bool {
+	keyMD5, ok := copySourceKeyHeaders(w, r)
+	return ok && matchCustomerKey(w, keyMD5, encryption)
+}
+
+func matchCustomerKey(w http.ResponseWriter, keyMD5 string, encryption Encryption) bool {
+	switch {
+	case encryption.CustomerKeyMD5 == "" && keyMD5 != "":
+		WriteError(w, http.StatusBadRequest, "InvalidRequest", "The encryption parameters are not applicable to this object.")
+		return false
+	case encryption.CustomerKeyMD5 != "" && keyMD5 == "":
+		WriteError(w, http.StatusBadRequest, "InvalidRequest", "The object was stored using a form of Server Side Encryption. The correct parameters must be provided to retrieve the object.")
+		return false
+	case encryption.CustomerKeyMD5 != keyMD5:
+		WriteError(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+		return false
+	}
+	return true
+}
+
+// writeEncryptionHeaders describes the encryption of an object in a response.
+func writeEncryptionHeaders(w http.ResponseWriter, encryption Encryption) {
+	if encryption.Algorithm != "" {
+		w.Header().Set("x-amz-server-side-encryption", encryption.Algorithm)
+	}
+	if encryption.KMSKeyID != "" {
+		w.Header().Set("x-amz-server-side-encryption-aws-kms-key-id", encryption.KMSKeyID)
+	}
+	if encryption.CustomerKeyMD5 != "" {
+		w.Header().Set("x-amz-server-side-encryption-customer-algorithm", "AES256")
+		w.Header().Set("x-amz-server-side-encryption-customer-key-MD5", encryption.CustomerKeyMD5)
+	}
+}
@@ -0,0 +1,34 @@
+package fakes3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+const hostID = "ZmFrZXMzLWhvc3Q="
+
+var requestCount atomic.Uint64
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	RequestID string   `xml:"RequestId"`
+	HostID    string   `xml:"HostId"`
+}
+
+// WriteError responds with an S3 error document, for use in interceptors.
+func WriteError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+
+	_, _ = w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(errorResponse{
+		Code:      code,
+		Message:   message,
+		RequestID: fmt.Sprintf("%016X", requestCount.Add(1)),
+		HostID:    hostID,
+	})
+}
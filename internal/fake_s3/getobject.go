@@ -0,0 +1,83 @@
+package fakes3
+
+import (
+	"fmt"
+	"net/http"
+)
+
+func (s *FakeS3) handleGetObject(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj := s.findObject(w, r, key)
+	if obj == nil {
+		return
+	}
+
+	if !s.readable(obj) {
+		WriteError(w, http.StatusForbidden, "InvalidObjectState", "The operation is not valid for the object's storage class.")
+		return
+	}
+
+	s.writeObjectHeaders(w, r, obj)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(obj.Content)
+}
+
+func (s *FakeS3) handleHeadObject(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj := s.findObject(w, r, key)
+	if obj == nil {
+		return
+	}
+
+	s.writeObjectHeaders(w, r, obj)
+	w.WriteHeader(http.StatusOK)
+}
+
+// findObject looks up the version of key a read request is for, checking its SSE-C key. It writes
+// an error response and returns nil if it cannot be read.
+func (s *FakeS3) findObject(w http.ResponseWriter, r *http.Request, key string) *ObjectVersion {
+	versions, exists := s.objects[key]
+	if !exists {
+		WriteError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return nil
+	}
+
+	versionID := r.URL.Query().Get("versionId")
+
+	var obj *ObjectVersion
+	if versionID != "" {
+		obj = versions[versionID]
+	} else {
+		obj = latestVersion(versions)
+		if obj != nil && obj.DeleteMarker {
+			obj = nil
+		}
+	}
+
+	if obj == nil || obj.DeleteMarker {
+		WriteError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return nil
+	}
+
+	if !checkCustomerKey(w, r, obj.Encryption) {
+		return nil
+	}
+
+	return obj
+}
+
+func (s *FakeS3) writeObjectHeaders(w http.ResponseWriter, r *http.Request, obj *ObjectVersion) {
+	writeEncryptionHeaders(w, obj.Encryption)
+	writeChecksumHeaders(w, r, obj)
+	s.writeStorageHeaders(w, obj)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", fmt.Sprintf("%d", len(obj.Content)))
+	w.Header().Set("x-amz-version-id", obj.VersionID)
+	for name, value := range obj.Metadata {
+		w.Header().Set("x-amz-meta-"+name, value)
+	}
+}
@@ -0,0 +1,95 @@
+package fakes3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+func (s *FakeS3) handlePutObjectLegalHold(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Error reading body: %v", err))
+		return
+	}
+
+	var legalHoldReq struct {
+		XMLName xml.Name `xml:"LegalHold"`
+		Status  string   `xml:"Status"`
+	}
+	if err := xml.Unmarshal(body, &legalHoldReq); err != nil || (legalHoldReq.Status != "ON" && legalHoldReq.Status != "OFF") {
+		WriteError(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, exists := s.objects[key]
+	if !exists {
+		WriteError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	var obj *ObjectVersion
+	if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+		obj = versions[versionID]
+	} else {
+		obj = latestVersion(versions)
+	}
+	if obj == nil || obj.DeleteMarker {
+		WriteError(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.")
+		return
+	}
+
+	obj.LegalHold = legalHoldReq.Status == "ON"
+}
+
+func (s *FakeS3) handleGetObjectLegalHold(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, exists := s.objects[key]
+	if !exists {
+		WriteError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+
+	var obj *ObjectVersion
+	if versionID := r.URL.Query().Get("versionId"); versionID != "" {
+		obj = versions[versionID]
+	} else {
+		obj = latestVersion(versions)
+	}
+	if obj == nil || obj.DeleteMarker {
+		WriteError(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.")
+		return
+	}
+
+	// Released holds are not remembered, so they are reported like versions that never had one.
+	if !obj.LegalHold {
+		WriteError(w, http.StatusNotFound, "NoSuchObjectLockConfiguration", "The specified object does not have a ObjectLock configuration")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	_, _ = fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?>
+<LegalHold xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Status>ON</Status></LegalHold>`)
+}
+
+// lockError is why a version cannot be deleted, or its retention shortened, by a request. It is
+// empty if nothing stops it. GOVERNANCE mode locks can be bypassed, COMPLIANCE mode locks and
+// legal holds cannot.
+func (s *FakeS3) lockError(r *http.Request, obj *ObjectVersion) string {
+	if obj.LegalHold {
+		return "Object is under legal hold"
+	}
+	if obj.Retention == nil || !obj.Retention.Until.After(s.now) {
+		return ""
+	}
+	if obj.Retention.Mode == "GOVERNANCE" && r.Header.Get("x-amz-bypass-governance-retention") == "true" {
+		return ""
+	}
+	return "Object is locked"
+}
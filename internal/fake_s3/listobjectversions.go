@@ -53,6 +53,7 @@ func (s *FakeS3) handleListObjectVersions(w http.ResponseWriter, r *http.Request
 	defer s.mu.RUnlock()
 
 	// Parse query parameters
+	prefix := r.URL.Query().Get("prefix")
 	keyMarker := r.URL.Query().Get("key-marker")
 	versionIdMarker := r.URL.Query().Get("version-id-marker")
 	maxKeysStr := r.URL.Query().Get("max-keys")
@@ -61,11 +62,15 @@ func (s *FakeS3) handleListObjectVersions(w http.ResponseWriter, r *http.Request
 	if maxKeysStr != "" {
 		_, _ = fmt.Sscanf(maxKeysStr, "%d", &maxKeys)
 	}
+	if s.maxKeys > 0 && (maxKeys > s.maxKeys || maxKeys < 1) {
+		maxKeys = s.maxKeys
+	}
+	maxKeys = max(maxKeys, 1)
 
 	result := listObjectVersionsResponse{
 		Xmlns:           "http://s3.amazonaws.com/doc/2006-03-01/",
 		Name:            bucket,
-		Prefix:          "",
+		Prefix:          prefix,
 		KeyMarker:       keyMarker,
 		VersionIdMarker: versionIdMarker,
 		MaxKeys:         maxKeys,
@@ -77,23 +82,13 @@ func (s *FakeS3) handleListObjectVersions(w http.ResponseWriter, r *http.Request
 	}
 
 	allVersions := make(map[string]map[string]versionWithLatest)
-	latestVersions := make(map[string]*ObjectVersion)
 
-	// First, determine the latest version for each key
 	for key, versions := range s.objects {
-		var latest *ObjectVersion
-		var latestTime time.Time
-
-		for _, v := range versions {
-			if latest == nil || (v.LastModified.Equal(latestTime) && v.VersionID > latest.VersionID) || v.LastModified.After(latestTime) {
-				latest = v
-				latestTime = v.LastModified
-			}
+		if !strings.HasPrefix(key, prefix) {
+			continue
 		}
 
-		if latest != nil {
-			latestVersions[key] = latest
-		}
+		latest := latestVersion(versions)
 
 		// Initialize the versions map for this key
 		if _, exists := allVersions[key]; !exists {
@@ -132,11 +127,17 @@ func (s *FakeS3) handleListObjectVersions(w http.ResponseWriter, r *http.Request
 		return flatVersions[i].version.Key < flatVersions[j].version.Key
 	})
 
-	// Apply markers
+	// Apply markers. Listing resumes after the marker version, or after every version of the
+	//  marker key if no version marker is given.
 	startIdx := 0
 	if keyMarker != "" {
+		startIdx = len(flatVersions)
 		for i, v := range flatVersions {
-			if v.version.Key > keyMarker || (v.version.Key == keyMarker && v.version.VersionID > versionIdMarker) {
+			if versionIdMarker != "" && v.version.Key == keyMarker && v.version.VersionID == versionIdMarker {
+				startIdx = i + 1
+				break
+			}
+			if v.version.Key > keyMarker {
 				startIdx = i
 				break
 			}
@@ -197,7 +198,7 @@ func (s *FakeS3) handleListObjectVersions(w http.ResponseWriter, r *http.Request
 	encoder := xml.NewEncoder(w)
 	encoder.Indent("", "  ")
 	if err := encoder.Encode(result); err != nil {
-		http.Error(w, fmt.Sprintf("Error encoding XML: %v", err), http.StatusInternalServerError)
+		WriteError(w, http.StatusInternalServerError, "InternalError", fmt.Sprintf("Error encoding XML: %v", err))
 		return
 	}
 }
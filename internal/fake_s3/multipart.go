@@ -0,0 +1,265 @@
+package fakes3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+// DefaultMinPartSize is the smallest part S3 accepts, other than the last part of an upload.
+const DefaultMinPartSize = 5 * 1024 * 1024
+
+// SetMinPartSize changes the smallest part accepted, so tests can upload small files in several
+// parts.
+func (s *FakeS3) SetMinPartSize(size int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.minPartSize = size
+}
+
+type multipartUpload struct {
+	key          string
+	storageClass string
+	retention    *ObjectLockRetention
+	encryption   Encryption
+	metadata     map[string]string
+	// checksum is the algorithm every part must be sent with, if any
+	checksum string
+	parts    map[int][]byte
+}
+
+type initiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"InitiateMultipartUploadResult"`
+	Xmlns    string   `xml:"xmlns,attr"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadID string   `xml:"UploadId"`
+}
+
+type completeMultipartUploadResult struct {
+	XMLName xml.Name `xml:"CompleteMultipartUploadResult"`
+	Xmlns   string   `xml:"xmlns,attr"`
+	Bucket  string   `xml:"Bucket"`
+	Key     string   `xml:"Key"`
+	ETag    string   `xml:"ETag"`
+
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+func (s *FakeS3) handleCreateMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	encryption, ok := encryptionHeaders(w, r)
+	if !ok {
+		return
+	}
+
+	checksum := r.Header.Get("x-amz-checksum-algorithm")
+	if checksum != "" && checksum != "SHA256" {
+		WriteError(w, http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextUploadID++
+	uploadID := fmt.Sprintf("upload%d", s.nextUploadID)
+
+	s.uploads[uploadID] = &multipartUpload{
+		key:          key,
+		storageClass: storageClassHeader(r),
+		retention:    retentionHeaders(r),
+		encryption:   encryption,
+		metadata:     metadataHeaders(r),
+		checksum:     checksum,
+		parts:        map[int][]byte{},
+	}
+
+	writeEncryptionHeaders(w, encryption)
+	writeXML(w, initiateMultipartUploadResult{
+		Xmlns:    "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket:   s.bucket,
+		Key:      key,
+		UploadID: uploadID,
+	})
+}
+
+func (s *FakeS3) handleUploadPart(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Error reading body: %v", err))
+		return
+	}
+
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > 10000 {
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "Part number must be an integer between 1 and 10000.")
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	upload, exists := s.uploads[r.URL.Query().Get("uploadId")]
+	if !exists || upload.key != key {
+		WriteError(w, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+
+	if !checkCustomerKey(w, r, upload.encryption) {
+		return
+	}
+
+	if upload.checksum != "" && r.Header.Get("x-amz-checksum-sha256") == "" {
+		WriteError(w, http.StatusBadRequest, "InvalidRequest", "The upload was created using a sha256 checksum. The part was uploaded without a checksum.")
+		return
+	}
+	if !checkChecksum(w, r, body) {
+		return
+	}
+
+	upload.parts[partNumber] = body
+
+	w.Header().Set("ETag", etag(body))
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *FakeS3) handleCompleteMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Error reading body: %v", err))
+		return
+	}
+
+	var completeReq struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []struct {
+			PartNumber     int    `xml:"PartNumber"`
+			ETag           string `xml:"ETag"`
+			ChecksumSHA256 string `xml:"ChecksumSHA256"`
+		} `xml:"Part"`
+	}
+
+	if err := xml.Unmarshal(body, &completeReq); err != nil {
+		WriteError(w, http.StatusBadRequest, "MalformedXML", fmt.Sprintf("Error parsing XML: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uploadID := r.URL.Query().Get("uploadId")
+	upload, exists := s.uploads[uploadID]
+	if !exists || upload.key != key {
+		WriteError(w, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+
+	// Uploads created with a checksum need the SSE-C key again to compute the checksum of the
+	// object.
+	if upload.checksum != "" && !checkCustomerKey(w, r, upload.encryption) {
+		return
+	}
+
+	if len(completeReq.Parts) == 0 {
+		WriteError(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed.")
+		return
+	}
+
+	var content bytes.Buffer
+	parts := [][]byte{}
+	objectParts := []ObjectPart{}
+	for i, part := range completeReq.Parts {
+		if i > 0 && part.PartNumber <= completeReq.Parts[i-1].PartNumber {
+			WriteError(w, http.StatusBadRequest, "InvalidPartOrder", "The list of parts was not in ascending order.")
+			return
+		}
+
+		data, ok := upload.parts[part.PartNumber]
+		if !ok || etag(data) != part.ETag || (upload.checksum != "" && checksumSHA256(data) != part.ChecksumSHA256) {
+			WriteError(w, http.StatusBadRequest, "InvalidPart", "One or more of the specified parts could not be found.")
+			return
+		}
+		if i < len(completeReq.Parts)-1 && len(data) < s.minPartSize {
+			WriteError(w, http.StatusBadRequest, "EntityTooSmall", "Your proposed upload is smaller than the minimum allowed object size.")
+			return
+		}
+		content.Write(data)
+		parts = append(parts, data)
+		objectParts = append(objectParts, ObjectPart{PartNumber: part.PartNumber, Size: len(data), ChecksumSHA256: checksumSHA256(data)})
+	}
+
+	checksum := ""
+	if upload.checksum != "" {
+		checksum = compositeChecksum(parts)
+	}
+
+	delete(s.uploads, uploadID)
+
+	obj := &ObjectVersion{
+		Key:          key,
+		VersionID:    s.generateVersionID(),
+		Content:      content.Bytes(),
+		LastModified: s.now,
+		StorageClass: upload.storageClass,
+		Retention:    upload.retention,
+		Encryption:   upload.encryption,
+		Metadata:     upload.metadata,
+
+		ChecksumSHA256: checksum,
+		Parts:          objectParts,
+	}
+
+	if _, exists := s.objects[key]; !exists {
+		s.objects[key] = make(map[string]*ObjectVersion)
+	}
+	s.objects[key][obj.VersionID] = obj
+
+	w.Header().Set("x-amz-version-id", obj.VersionID)
+	writeEncryptionHeaders(w, obj.Encryption)
+	writeXML(w, completeMultipartUploadResult{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Bucket: s.bucket,
+		Key:    key,
+		ETag:   etag(obj.Content),
+
+		ChecksumSHA256: checksum,
+	})
+}
+
+func (s *FakeS3) handleAbortMultipartUpload(w http.ResponseWriter, r *http.Request, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	uploadID := r.URL.Query().Get("uploadId")
+	upload, exists := s.uploads[uploadID]
+	if !exists || upload.key != key {
+		WriteError(w, http.StatusNotFound, "NoSuchUpload", "The specified multipart upload does not exist.")
+		return
+	}
+
+	delete(s.uploads, uploadID)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func etag(data []byte) string {
+	sum := md5.Sum(data)
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+func writeXML(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := xml.NewEncoder(w)
+	encoder.Indent("", "  ")
+	if err := encoder.Encode(v); err != nil {
+		WriteError(w, http.StatusInternalServerError, "InternalError", fmt.Sprintf("Error encoding XML: %v", err))
+		return
+	}
+}
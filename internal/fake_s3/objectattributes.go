@@ -0,0 +1,86 @@
+package fakes3
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ObjectPart is a part of an object uploaded with a multipart upload.
+type ObjectPart struct {
+	PartNumber     int    `xml:"PartNumber"`
+	Size           int    `xml:"Size"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+type objectParts struct {
+	IsTruncated          bool         `xml:"IsTruncated"`
+	MaxParts             int          `xml:"MaxParts"`
+	PartNumberMarker     int          `xml:"PartNumberMarker"`
+	NextPartNumberMarker int          `xml:"NextPartNumberMarker"`
+	PartsCount           int          `xml:"PartsCount"`
+	Parts                []ObjectPart `xml:"Part"`
+}
+
+type getObjectAttributesResponse struct {
+	XMLName     xml.Name     `xml:"GetObjectAttributesResponse"`
+	Xmlns       string       `xml:"xmlns,attr"`
+	ETag        string       `xml:"ETag,omitempty"`
+	ObjectSize  int          `xml:"ObjectSize,omitempty"`
+	ObjectParts *objectParts `xml:"ObjectParts,omitempty"`
+}
+
+// handleGetObjectAttributes supports the ETag, ObjectSize and ObjectParts attributes.
+func (s *FakeS3) handleGetObjectAttributes(w http.ResponseWriter, r *http.Request, key string) {
+	attributes := r.Header.Get("x-amz-object-attributes")
+	if attributes == "" {
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "x-amz-object-attributes header specifying the attributes to be retrieved is either missing or empty")
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	obj := s.findObject(w, r, key)
+	if obj == nil {
+		return
+	}
+
+	response := getObjectAttributesResponse{Xmlns: "http://s3.amazonaws.com/doc/2006-03-01/"}
+	for _, attribute := range strings.Split(attributes, ",") {
+		switch strings.TrimSpace(attribute) {
+		case "ETag":
+			response.ETag = etag(obj.Content)
+		case "ObjectSize":
+			response.ObjectSize = len(obj.Content)
+		case "ObjectParts":
+			if len(obj.Parts) == 0 {
+				continue
+			}
+
+			maxParts := 1000
+			if s.maxKeys > 0 {
+				maxParts = s.maxKeys
+			}
+			marker, _ := strconv.Atoi(r.Header.Get("x-amz-part-number-marker"))
+
+			parts := &objectParts{MaxParts: maxParts, PartNumberMarker: marker, PartsCount: len(obj.Parts), Parts: []ObjectPart{}}
+			for _, part := range obj.Parts {
+				if part.PartNumber <= marker {
+					continue
+				}
+				if len(parts.Parts) == maxParts {
+					parts.IsTruncated = true
+					break
+				}
+				parts.Parts = append(parts.Parts, part)
+				parts.NextPartNumberMarker = part.PartNumber
+			}
+			response.ObjectParts = parts
+		}
+	}
+
+	w.Header().Set("x-amz-version-id", obj.VersionID)
+	writeXML(w, response)
+}
@@ -11,7 +11,7 @@ import (
 func (s *FakeS3) handlePutObjectRetention(w http.ResponseWriter, r *http.Request, key string) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading body: %v", err), http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Error reading body: %v", err))
 		return
 	}
 
@@ -23,12 +23,12 @@ func (s *FakeS3) handlePutObjectRetention(w http.ResponseWriter, r *http.Request
 	}
 
 	if err := xml.Unmarshal(body, &retentionReq); err != nil {
-		http.Error(w, fmt.Sprintf("Error parsing XML: %v", err), http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "MalformedXML", fmt.Sprintf("Error parsing XML: %v", err))
 		return
 	}
 
 	if retentionReq.RetainUntilDate.Before(s.now) {
-		http.Error(w, "RetainUntil must be after now", http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "The retain until date must be in the future.")
 		return
 	}
 
@@ -37,7 +37,7 @@ func (s *FakeS3) handlePutObjectRetention(w http.ResponseWriter, r *http.Request
 
 	versions, exists := s.objects[key]
 	if !exists {
-		http.Error(w, "NoSuchKey", http.StatusNotFound)
+		WriteError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
 		return
 	}
 
@@ -60,10 +60,23 @@ func (s *FakeS3) handlePutObjectRetention(w http.ResponseWriter, r *http.Request
 	}
 
 	if obj == nil {
-		http.Error(w, "NoSuchVersion", http.StatusNotFound)
+		WriteError(w, http.StatusNotFound, "NoSuchVersion", "The specified version does not exist.")
 		return
 	}
 
+	// the key is not needed to change retention, but one that is sent must be right
+	if r.Header.Get("x-amz-server-side-encryption-customer-algorithm") != "" && !checkCustomerKey(w, r, obj.Encryption) {
+		return
+	}
+
+	// an active lock can always be extended, anything else is held to the rules of deleting it
+	if old := obj.Retention; old != nil && (retentionReq.RetainUntilDate.Before(old.Until) || retentionReq.Mode != old.Mode) {
+		if obj.Retention.Until.After(s.now) && (old.Mode != "GOVERNANCE" || r.Header.Get("x-amz-bypass-governance-retention") != "true") {
+			WriteError(w, http.StatusForbidden, "AccessDenied", "Access Denied because object protected by object lock.")
+			return
+		}
+	}
+
 	obj.Retention = &ObjectLockRetention{
 		Mode:  retentionReq.Mode,
 		Until: retentionReq.RetainUntilDate,
@@ -0,0 +1,109 @@
+package fakes3
+
+import (
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// DefaultRestoreDelay is how long a restore of an archived object takes unless SetRestoreDelay
+// is used. Time only passes through SetNow.
+const DefaultRestoreDelay = 4 * time.Hour
+
+// archivedStorageClasses cannot be read, or copied, without restoring them first.
+var archivedStorageClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+// RestoredCopy is the temporary copy of an archived object made by a restore request.
+type RestoredCopy struct {
+	Tier    string
+	ReadyAt time.Time
+	Expires time.Time
+}
+
+type restoreRequest struct {
+	Days                 int `xml:"Days"`
+	GlacierJobParameters struct {
+		Tier string `xml:"Tier"`
+	} `xml:"GlacierJobParameters"`
+}
+
+// SetRestoreDelay sets how long restores take to finish after they are requested.
+func (s *FakeS3) SetRestoreDelay(delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.restoreDelay = delay
+}
+
+func (s *FakeS3) handleRestoreObject(w http.ResponseWriter, r *http.Request, key string) {
+	var request restoreRequest
+	if err := xml.NewDecoder(r.Body).Decode(&request); err != nil {
+		WriteError(w, http.StatusBadRequest, "MalformedXML", "The XML you provided was not well-formed or did not validate against our published schema.")
+		return
+	}
+	if request.Days < 1 {
+		WriteError(w, http.StatusBadRequest, "InvalidArgument", "Days must be at least 1.")
+		return
+	}
+	tier := request.GlacierJobParameters.Tier
+	if tier == "" {
+		tier = "Standard"
+	}
+	if !slices.Contains([]string{"Expedited", "Standard", "Bulk"}, tier) {
+		WriteError(w, http.StatusBadRequest, "MalformedXML", fmt.Sprintf("Unknown retrieval tier: %s", tier))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	obj := latestVersion(s.objects[key])
+	if obj == nil || obj.DeleteMarker {
+		WriteError(w, http.StatusNotFound, "NoSuchKey", "The specified key does not exist.")
+		return
+	}
+	if !slices.Contains(archivedStorageClasses, obj.StorageClass) {
+		WriteError(w, http.StatusForbidden, "InvalidObjectState", "Restore is not allowed for the object's current storage class.")
+		return
+	}
+
+	days := time.Duration(request.Days) * 24 * time.Hour
+	switch {
+	case obj.Restored != nil && s.now.Before(obj.Restored.ReadyAt):
+		WriteError(w, http.StatusConflict, "RestoreAlreadyInProgress", "Object restore is already in progress.")
+	case obj.Restored != nil && s.now.Before(obj.Restored.Expires):
+		obj.Restored.Expires = s.now.Add(days)
+		w.WriteHeader(http.StatusOK)
+	default:
+		readyAt := s.now.Add(s.restoreDelay)
+		obj.Restored = &RestoredCopy{Tier: tier, ReadyAt: readyAt, Expires: readyAt.Add(days)}
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// readable reports whether an object can be read, which archived objects only can while they
+// have a restored copy.
+func (s *FakeS3) readable(obj *ObjectVersion) bool {
+	if !slices.Contains(archivedStorageClasses, obj.StorageClass) {
+		return true
+	}
+	return obj.Restored != nil && !s.now.Before(obj.Restored.ReadyAt) && s.now.Before(obj.Restored.Expires)
+}
+
+// writeStorageHeaders describes the storage class and restore state of an object in a response.
+func (s *FakeS3) writeStorageHeaders(w http.ResponseWriter, obj *ObjectVersion) {
+	if obj.StorageClass != "STANDARD" {
+		w.Header().Set("x-amz-storage-class", obj.StorageClass)
+	}
+
+	if obj.Restored == nil {
+		return
+	}
+	if s.now.Before(obj.Restored.ReadyAt) {
+		w.Header().Set("x-amz-restore", `ongoing-request="true"`)
+	} else if s.now.Before(obj.Restored.Expires) {
+		w.Header().Set("x-amz-restore", fmt.Sprintf(`ongoing-request="false", expiry-date="%s"`, obj.Restored.Expires.Format(http.TimeFormat)))
+	}
+}
@@ -1,9 +1,12 @@
 package fakes3
 
 import (
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"maps"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -26,6 +29,18 @@ type ObjectVersion struct {
 	StorageClass string
 	DeleteMarker bool
 	Retention    *ObjectLockRetention
+	LegalHold    bool
+	Encryption   Encryption
+	Metadata     map[string]string
+
+	// ChecksumSHA256 is the base64 checksum sent with the object, or the composite checksum of a
+	// multipart upload.
+	ChecksumSHA256 string
+	// Parts are the parts of a multipart upload, which GetObjectAttributes lists.
+	Parts []ObjectPart
+
+	// Restored is set once an archived object has been restored, or is being restored.
+	Restored *RestoredCopy
 }
 
 type ObjectLockRetention struct {
@@ -40,15 +55,26 @@ type FakeS3 struct {
 	objects       map[string]map[string]*ObjectVersion // map[key]map[versionID]*ObjectVersion
 	nextVersionID int
 	now           time.Time
+	restoreDelay  time.Duration
+	minPartSize   int
+	maxKeys       int
+	basePath      string
+
+	uploads      map[string]*multipartUpload // map[uploadID]*multipartUpload
+	nextUploadID int
 
 	interceptor func(r *http.Request, w http.ResponseWriter) bool
+	keys        []Key
 }
 
 func NewFakeS3(bucket string) *FakeS3 {
 	return &FakeS3{
-		objects: make(map[string]map[string]*ObjectVersion),
-		bucket:  bucket,
-		now:     time.Now().UTC(),
+		objects:      make(map[string]map[string]*ObjectVersion),
+		uploads:      make(map[string]*multipartUpload),
+		bucket:       bucket,
+		now:          time.Now().UTC(),
+		restoreDelay: DefaultRestoreDelay,
+		minPartSize:  DefaultMinPartSize,
 	}
 }
 
@@ -56,6 +82,24 @@ func (s *FakeS3) StartServer() {
 	s.server = httptest.NewServer(http.HandlerFunc(s.handleRequest))
 }
 
+// StartTLSServer serves over https with a certificate for 127.0.0.1. If clientCAs is not nil, a
+// client certificate signed by one of them is required.
+func (s *FakeS3) StartTLSServer(clientCAs *x509.CertPool) {
+	s.server = httptest.NewUnstartedServer(http.HandlerFunc(s.handleRequest))
+	if clientCAs != nil {
+		s.server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	}
+	s.server.StartTLS()
+}
+
+// GetCertificate returns the certificate of a server started with StartTLSServer.
+func (s *FakeS3) GetCertificate() *x509.Certificate {
+	if s.server == nil {
+		return nil
+	}
+	return s.server.Certificate()
+}
+
 func (s *FakeS3) StopServer() {
 	if s.server != nil {
 		s.server.Close()
@@ -64,9 +108,23 @@ func (s *FakeS3) StopServer() {
 }
 
 func (s *FakeS3) SetNow(time time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
 	s.now = time.UTC()
 }
 
+// SetMaxKeys caps the number of entries returned by a single list request, and the number of
+// parts returned by GetObjectAttributes.
+func (s *FakeS3) SetMaxKeys(maxKeys int) {
+	s.maxKeys = maxKeys
+}
+
+// SetBasePath serves the bucket below path, as some gateways do.
+func (s *FakeS3) SetBasePath(path string) {
+	s.basePath = path
+}
+
 func (s *FakeS3) SetInterceptor(i func(r *http.Request, w http.ResponseWriter) bool) {
 	s.interceptor = i
 }
@@ -84,6 +142,16 @@ func (s *FakeS3) Reset() {
 	defer s.mu.Unlock()
 
 	s.objects = make(map[string]map[string]*ObjectVersion)
+	s.uploads = make(map[string]*multipartUpload)
+}
+
+// GetMultipartUploadCount returns the number of multipart uploads that have been started but not
+// completed or aborted.
+func (s *FakeS3) GetMultipartUploadCount() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.uploads)
 }
 
 func (s *FakeS3) GetVersions(key string) []*ObjectVersion {
@@ -103,6 +171,17 @@ func (s *FakeS3) GetVersions(key string) []*ObjectVersion {
 	return []*ObjectVersion{}
 }
 
+// latestVersion returns the newest version of a key, which may be a delete marker.
+func latestVersion(versions map[string]*ObjectVersion) *ObjectVersion {
+	var latest *ObjectVersion
+	for _, v := range versions {
+		if latest == nil || (v.LastModified.Equal(latest.LastModified) && v.VersionID > latest.VersionID) || v.LastModified.After(latest.LastModified) {
+			latest = v
+		}
+	}
+	return latest
+}
+
 func (s *FakeS3) generateVersionID() string {
 	s.nextVersionID++
 	return fmt.Sprintf("v%d", s.nextVersionID)
@@ -111,8 +190,9 @@ func (s *FakeS3) generateVersionID() string {
 // handleRequest handles incoming HTTP requests
 func (s *FakeS3) handleRequest(w http.ResponseWriter, r *http.Request) {
 	// Parse the bucket and key from the URL path
-	// Path format: /{bucket}/{key}
-	parts := strings.SplitN(r.URL.Path, "/", 3)
+	// Path format: {basePath}/{bucket}/{key}
+	path := strings.TrimPrefix(r.URL.Path, s.basePath)
+	parts := strings.SplitN(path, "/", 3)
 	bucket := ""
 	key := ""
 	if len(parts) > 1 {
@@ -122,14 +202,28 @@ func (s *FakeS3) handleRequest(w http.ResponseWriter, r *http.Request) {
 		key = parts[2]
 	}
 
+	// Virtual-hosted style puts the bucket in the host: {bucket}.{host}/{key}
+	hostname := r.Host
+	if h, _, err := net.SplitHostPort(r.Host); err == nil {
+		hostname = h
+	}
+	if strings.HasPrefix(hostname, s.bucket+".") {
+		bucket = s.bucket
+		key = strings.TrimPrefix(path, "/")
+	}
+
 	if s.interceptor != nil {
 		if s.interceptor(r, w) {
 			return
 		}
 	}
 
+	if !s.checkSignature(w, r) {
+		return
+	}
+
 	if bucket != s.bucket {
-		http.Error(w, "Invalid bucket", http.StatusBadRequest)
+		WriteError(w, http.StatusNotFound, "NoSuchBucket", "The specified bucket does not exist.")
 		return
 	}
 
@@ -138,23 +232,49 @@ func (s *FakeS3) handleRequest(w http.ResponseWriter, r *http.Request) {
 	case http.MethodGet:
 		if _, ok := r.URL.Query()["versions"]; ok {
 			s.handleListObjectVersions(w, r, bucket)
+		} else if _, ok := r.URL.Query()["attributes"]; ok && key != "" {
+			s.handleGetObjectAttributes(w, r, key)
+		} else if _, ok := r.URL.Query()["legal-hold"]; ok && key != "" {
+			s.handleGetObjectLegalHold(w, r, key)
+		} else if key != "" {
+			s.handleGetObject(w, r, key)
 		} else {
-			http.Error(w, "Not Implmemented", http.StatusNotImplemented)
+			WriteError(w, http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
 		}
+	case http.MethodHead:
+		s.handleHeadObject(w, r, key)
 	case http.MethodPut:
 		if _, ok := r.URL.Query()["retention"]; ok {
 			s.handlePutObjectRetention(w, r, key)
+		} else if _, ok := r.URL.Query()["legal-hold"]; ok {
+			s.handlePutObjectLegalHold(w, r, key)
+		} else if _, ok := r.URL.Query()["uploadId"]; ok {
+			s.handleUploadPart(w, r, key)
+		} else if r.Header.Get("x-amz-copy-source") != "" {
+			s.handleCopyObject(w, r, key)
 		} else {
 			s.handlePutObject(w, r, key)
 		}
 	case http.MethodPost:
 		if _, ok := r.URL.Query()["delete"]; ok {
 			s.handleDeleteObjects(w, r)
+		} else if _, ok := r.URL.Query()["uploads"]; ok {
+			s.handleCreateMultipartUpload(w, r, key)
+		} else if _, ok := r.URL.Query()["uploadId"]; ok {
+			s.handleCompleteMultipartUpload(w, r, key)
+		} else if _, ok := r.URL.Query()["restore"]; ok {
+			s.handleRestoreObject(w, r, key)
 		} else {
-			http.Error(w, "Not Implemented", http.StatusNotImplemented)
+			WriteError(w, http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
+		}
+	case http.MethodDelete:
+		if _, ok := r.URL.Query()["uploadId"]; ok {
+			s.handleAbortMultipartUpload(w, r, key)
+		} else {
+			WriteError(w, http.StatusNotImplemented, "NotImplemented", "A header or query you provided implies functionality that is not implemented.")
 		}
 	default:
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+		WriteError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", "The specified method is not allowed against this resource.")
 	}
 }
 
@@ -162,32 +282,28 @@ func (s *FakeS3) handleRequest(w http.ResponseWriter, r *http.Request) {
 func (s *FakeS3) handlePutObject(w http.ResponseWriter, r *http.Request, key string) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading body: %v", err), http.StatusBadRequest)
+		WriteError(w, http.StatusBadRequest, "IncompleteBody", fmt.Sprintf("Error reading body: %v", err))
 		return
 	}
 
-	storageClass := "STANDARD"
-	if sc := r.Header.Get("x-amz-storage-class"); sc != "" {
-		storageClass = sc
+	if !checkChecksum(w, r, body) {
+		return
 	}
 
-	obj := &ObjectVersion{
-		Key:          key,
-		Content:      body,
-		LastModified: s.now,
-		StorageClass: storageClass,
+	encryption, ok := encryptionHeaders(w, r)
+	if !ok {
+		return
 	}
 
-	lockMode := r.Header.Get("x-amz-object-lock-mode")
-	lockDate := r.Header.Get("x-amz-object-lock-retain-until-date")
-	if lockMode != "" && lockDate != "" {
-		retainUntil, err := time.Parse(time.RFC3339, lockDate)
-		if err == nil {
-			obj.Retention = &ObjectLockRetention{
-				Mode:  lockMode,
-				Until: retainUntil,
-			}
-		}
+	obj := &ObjectVersion{
+		Key:            key,
+		Content:        body,
+		LastModified:   s.now,
+		StorageClass:   storageClassHeader(r),
+		Retention:      retentionHeaders(r),
+		Encryption:     encryption,
+		Metadata:       metadataHeaders(r),
+		ChecksumSHA256: r.Header.Get("x-amz-checksum-sha256"),
 	}
 
 	s.mu.Lock()
@@ -203,5 +319,42 @@ func (s *FakeS3) handlePutObject(w http.ResponseWriter, r *http.Request, key str
 
 	s.objects[key][versionID] = obj
 
+	writeEncryptionHeaders(w, encryption)
 	w.WriteHeader(http.StatusOK)
 }
+
+func storageClassHeader(r *http.Request) string {
+	if sc := r.Header.Get("x-amz-storage-class"); sc != "" {
+		return sc
+	}
+	return "STANDARD"
+}
+
+// metadataHeaders returns the x-amz-meta-* headers of a request, nil if there are none.
+func metadataHeaders(r *http.Request) map[string]string {
+	var metadata map[string]string
+	for name := range r.Header {
+		if suffix, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			if metadata == nil {
+				metadata = map[string]string{}
+			}
+			metadata[suffix] = r.Header.Get(name)
+		}
+	}
+	return metadata
+}
+
+func retentionHeaders(r *http.Request) *ObjectLockRetention {
+	lockMode := r.Header.Get("x-amz-object-lock-mode")
+	lockDate := r.Header.Get("x-amz-object-lock-retain-until-date")
+	if lockMode != "" && lockDate != "" {
+		retainUntil, err := time.Parse(time.RFC3339, lockDate)
+		if err == nil {
+			return &ObjectLockRetention{
+				Mode:  lockMode,
+				Until: retainUntil,
+			}
+		}
+	}
+	return nil
+}
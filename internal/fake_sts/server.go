@@ -0,0 +1,243 @@
+package fakests
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"time"
+
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+)
+
+// AssumedRole is a request the server handed out credentials for.
+type AssumedRole struct {
+	Action      string
+	RoleARN     string
+	SessionName string
+	ExternalID  string
+	Key         fakes3.Key
+	Expiration  time.Time
+}
+
+// FakeSTS answers AssumeRole and AssumeRoleWithWebIdentity. Every call returns a new key, which
+// is passed to the OnIssue callback so a FakeS3 can accept it.
+type FakeSTS struct {
+	mu     sync.Mutex
+	server *httptest.Server
+
+	callers          []fakes3.Key
+	roles            map[string]string // map[roleARN]externalID
+	webIdentityToken string
+	lifetime         time.Duration
+
+	issued  []AssumedRole
+	onIssue func(key fakes3.Key)
+}
+
+func NewFakeSTS() *FakeSTS {
+	return &FakeSTS{roles: map[string]string{}}
+}
+
+func (s *FakeSTS) StartServer() {
+	s.server = httptest.NewServer(http.HandlerFunc(s.handleRequest))
+}
+
+func (s *FakeSTS) StopServer() {
+	if s.server != nil {
+		s.server.Close()
+		s.server = nil
+	}
+}
+
+func (s *FakeSTS) GetURL() string {
+	if s.server == nil {
+		return ""
+	}
+	return s.server.URL
+}
+
+// AddCaller allows key to call AssumeRole.
+func (s *FakeSTS) AddCaller(key fakes3.Key) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.callers = append(s.callers, key)
+}
+
+// AddRole allows roleARN to be assumed. A non-empty externalID must be sent with AssumeRole.
+func (s *FakeSTS) AddRole(roleARN, externalID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.roles[roleARN] = externalID
+}
+
+// SetWebIdentityToken is the token AssumeRoleWithWebIdentity accepts.
+func (s *FakeSTS) SetWebIdentityToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.webIdentityToken = token
+}
+
+// SetLifetime makes issued credentials expire after lifetime instead of the requested duration,
+// which cannot be shorter than 15 minutes.
+func (s *FakeSTS) SetLifetime(lifetime time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lifetime = lifetime
+}
+
+func (s *FakeSTS) OnIssue(onIssue func(key fakes3.Key)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.onIssue = onIssue
+}
+
+func (s *FakeSTS) GetIssued() []AssumedRole {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return append([]AssumedRole{}, s.issued...)
+}
+
+type errorResponse struct {
+	XMLName   xml.Name `xml:"ErrorResponse"`
+	Type      string   `xml:"Error>Type"`
+	Code      string   `xml:"Error>Code"`
+	Message   string   `xml:"Error>Message"`
+	RequestID string   `xml:"RequestId"`
+}
+
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(status)
+	_ = xml.NewEncoder(w).Encode(errorResponse{Type: "Sender", Code: code, Message: message, RequestID: "fake-sts-request"})
+}
+
+type credentials struct {
+	AccessKeyID     string    `xml:"AccessKeyId"`
+	SecretAccessKey string    `xml:"SecretAccessKey"`
+	SessionToken    string    `xml:"SessionToken"`
+	Expiration      time.Time `xml:"Expiration"`
+}
+
+// assumeRoleResponse is named after the action, such as AssumeRoleResponse containing
+// AssumeRoleResult.
+type assumeRoleResponse struct {
+	XMLName   xml.Name
+	Result    assumeRoleResult
+	RequestID string `xml:"ResponseMetadata>RequestId"`
+}
+
+type assumeRoleResult struct {
+	XMLName     xml.Name
+	Credentials credentials `xml:"Credentials"`
+}
+
+func (s *FakeSTS) handleRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "InvalidAction", "Requests must be POST.")
+		return
+	}
+
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedInput", fmt.Sprintf("Error reading body: %v", err))
+		return
+	}
+	form, err := url.ParseQuery(string(body))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "MalformedInput", fmt.Sprintf("Error parsing form: %v", err))
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	action := form.Get("Action")
+	switch action {
+	case "AssumeRole":
+		hash := sha256.Sum256(body)
+		if r.Header.Get("x-amz-content-sha256") != hex.EncodeToString(hash[:]) {
+			writeError(w, http.StatusForbidden, "SignatureDoesNotMatch", "The body does not match its signed hash.")
+			return
+		}
+		if code, message := fakes3.VerifySignature(r, s.callers); code != "" {
+			writeError(w, http.StatusForbidden, code, message)
+			return
+		}
+		if externalID, ok := s.roles[form.Get("RoleArn")]; ok && externalID != form.Get("ExternalId") {
+			writeError(w, http.StatusForbidden, "AccessDenied", "The external id does not match.")
+			return
+		}
+	case "AssumeRoleWithWebIdentity":
+		if s.webIdentityToken == "" || form.Get("WebIdentityToken") != s.webIdentityToken {
+			writeError(w, http.StatusBadRequest, "InvalidIdentityToken", "The web identity token is not valid.")
+			return
+		}
+	default:
+		writeError(w, http.StatusBadRequest, "InvalidAction", fmt.Sprintf("Could not find operation %s.", action))
+		return
+	}
+
+	roleARN := form.Get("RoleArn")
+	if _, ok := s.roles[roleARN]; !ok {
+		writeError(w, http.StatusForbidden, "AccessDenied", fmt.Sprintf("Not authorized to perform sts:%s on %s.", action, roleARN))
+		return
+	}
+
+	seconds, err := strconv.Atoi(form.Get("DurationSeconds"))
+	if err != nil || seconds < 900 || seconds > 43200 {
+		writeError(w, http.StatusBadRequest, "ValidationError", "DurationSeconds must be between 900 and 43200.")
+		return
+	}
+	lifetime := time.Duration(seconds) * time.Second
+	if s.lifetime > 0 {
+		lifetime = s.lifetime
+	}
+
+	n := len(s.issued) + 1
+	key := fakes3.Key{
+		ID:           fmt.Sprintf("ASIA%04d", n),
+		Secret:       fmt.Sprintf("secret-%d", n),
+		SessionToken: fmt.Sprintf("session-token-%d", n),
+	}
+	expiration := time.Now().Add(lifetime).UTC().Truncate(time.Second)
+	s.issued = append(s.issued, AssumedRole{
+		Action:      action,
+		RoleARN:     roleARN,
+		SessionName: form.Get("RoleSessionName"),
+		ExternalID:  form.Get("ExternalId"),
+		Key:         key,
+		Expiration:  expiration,
+	})
+	if s.onIssue != nil {
+		s.onIssue(key)
+	}
+
+	w.Header().Set("Content-Type", "text/xml")
+	w.WriteHeader(http.StatusOK)
+	_ = xml.NewEncoder(w).Encode(assumeRoleResponse{
+		XMLName: xml.Name{Local: action + "Response"},
+		Result: assumeRoleResult{
+			XMLName: xml.Name{Local: action + "Result"},
+			Credentials: credentials{
+				AccessKeyID:     key.ID,
+				SecretAccessKey: key.Secret,
+				SessionToken:    key.SessionToken,
+				Expiration:      expiration,
+			},
+		},
+		RequestID: fmt.Sprintf("fake-sts-request-%d", n),
+	})
+}
@@ -2,205 +2,337 @@ package marmalade
 
 import (
 	"bytes"
+	"context"
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
 	"io"
 	"log/slog"
-	"maps"
 	"os"
 	"path"
-	"slices"
 	"strings"
 	"time"
 
 	"github.com/bradenrayhorn/marmalade/s3"
 )
 
-func Backup(client *s3.Client, schedule RetentionSchedule, at time.Time, filePath string) error {
-	stat, err := os.Stat(filePath)
-	if err != nil {
-		return fmt.Errorf("file stat: %w", err)
-	}
+const DefaultMultipartThreshold = 64 * 1024 * 1024
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		return fmt.Errorf("read file: %w", err)
-	}
+type BackupOptions struct {
+	// Prefix scopes the backup set to keys starting with it, such as "postgres/". It must be empty
+	// or end in "/", so that it cannot match the keys of a sibling set such as "postgres2/". Keys
+	// under the prefix that contain a further "/" belong to another backup set and are never
+	// touched.
+	Prefix string
 
-	hash := sha256.New()
-	if _, err := io.Copy(hash, file); err != nil {
-		return err
+	// BypassGovernance deletes versions that are no longer retained, and shortens locks, even
+	// while they are locked in GOVERNANCE mode. It is meant for cleaning up after a mistaken
+	// schedule and needs the s3:BypassGovernanceRetention permission.
+	BypassGovernance bool
+
+	// Metadata is stored with the backup as S3 user metadata, but not with its hash file.
+	Metadata map[string]string
+
+	// MultipartThreshold is the file size in bytes above which a multipart upload is used.
+	// Defaults to DefaultMultipartThreshold. Streamed backups always use multipart uploads once
+	// they are larger than a single part.
+	MultipartThreshold int64
+}
+
+// ValidatePrefix checks that prefix is empty or ends in "/".
+func ValidatePrefix(prefix string) error {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		return fmt.Errorf("prefix %q must end in /", prefix)
 	}
-	if _, err := file.Seek(0, io.SeekStart); err != nil {
-		return err
+	return nil
+}
+
+func (o BackupOptions) multipartThreshold() int64 {
+	if o.MultipartThreshold <= 0 {
+		return DefaultMultipartThreshold
 	}
-	sha256Sum := []byte(hex.EncodeToString(hash.Sum(nil)))
+	return o.MultipartThreshold
+}
 
-	pathParts := strings.Split(path.Base(filePath), ".")
-	backupFileName := fmt.Sprintf("%s.%s", at.Format("2006-01-02"), strings.Join(pathParts[1:], "."))
+func Backup(client *s3.Client, schedule RetentionSchedule, at time.Time, filePath string, options BackupOptions) (*BackupResult, error) {
+	return BackupContext(context.Background(), client, schedule, at, filePath, options)
+}
 
-	// Get all objectVersions out of the bucket and check retention.
-	objectVersions, err := client.ListObjectVersions("", "", "", 500)
+// BackupContext is like Backup, but stops as soon as ctx is done. An interrupted upload is aborted.
+func BackupContext(ctx context.Context, client *s3.Client, schedule RetentionSchedule, at time.Time, filePath string, options BackupOptions) (*BackupResult, error) {
+	plan, err := PlanBackupContext(ctx, client, schedule, at, path.Base(filePath), options)
 	if err != nil {
-		return fmt.Errorf("list object versions: %w", err)
+		return nil, err
 	}
 
-	backups := map[string]struct{}{}
-	for _, object := range objectVersions.Versions {
-		if !object.IsLatest { // Only consider latest version of files
-			continue
-		}
+	return plan.ExecuteContext(ctx, client, filePath)
+}
 
-		// Remove sha256 hash files
-		if !strings.HasSuffix(object.Key, ".sha256") {
-			backups[object.Key] = struct{}{}
-		}
-	}
+// Execute carries out the plan. filePath is only read if the plan contains an upload.
+func (p *Plan) Execute(client *s3.Client, filePath string) (*BackupResult, error) {
+	return p.ExecuteContext(context.Background(), client, filePath)
+}
 
-	oldRetained := calculateRetention(slices.Collect(maps.Keys(backups)), schedule)
-	retained := calculateRetention(append(slices.Collect(maps.Keys(backups)), backupFileName), schedule)
+func (p *Plan) ExecuteContext(ctx context.Context, client *s3.Client, filePath string) (*BackupResult, error) {
+	return p.execute(ctx, client, func() (*UploadResult, error) { return p.uploadFile(ctx, client, filePath) })
+}
 
-	// Upload file if it will be retained AND it has not been uploaded already.
-	if _, ok := backups[backupFileName]; !ok && slices.Contains(retained.All(), backupFileName) {
-		slog.Info(fmt.Sprintf("Uploading %s", backupFileName))
+// ExecuteStream carries out the plan, uploading the backup from r as it is read. r is only read
+// if the plan contains an upload. The hash file is uploaded once the backup is complete.
+func (p *Plan) ExecuteStream(client *s3.Client, r io.Reader) (*BackupResult, error) {
+	return p.ExecuteStreamContext(context.Background(), client, r)
+}
 
-		var lockHours int
+func (p *Plan) ExecuteStreamContext(ctx context.Context, client *s3.Client, r io.Reader) (*BackupResult, error) {
+	return p.execute(ctx, client, func() (*UploadResult, error) { return p.uploadStream(ctx, client, r) })
+}
 
-		if slices.Contains(retained.yearly, backupFileName) {
-			lockHours = schedule.yearlyLock.lockHours
-		}
-		if slices.Contains(retained.monthly, backupFileName) {
-			lockHours = schedule.monthlyLock.lockHours
-		}
-		if slices.Contains(retained.daily, backupFileName) {
-			lockHours = schedule.dailyLock.lockHours
-		}
+// execute uploads the backup, then moves backups between storage classes, places legal holds,
+// extends locks and deletes versions that are no longer retained. Copies, holds, locks and
+// deletions that fail are recorded in the result without stopping the run.
+func (p *Plan) execute(ctx context.Context, client *s3.Client, upload func() (*UploadResult, error)) (*BackupResult, error) {
+	result := newBackupResult(p)
 
-		var retention *s3.ObjectLockRetention
-		if lockHours > 0 {
-			retention = &s3.ObjectLockRetention{
-				Mode:  "COMPLIANCE",
-				Until: at.Add(time.Hour * time.Duration(lockHours)),
-			}
-		}
+	// Locks and deletions go through a client that may override GOVERNANCE mode locks.
+	lockClient := client
+	if p.options.BypassGovernance {
+		lockClient = client.WithGovernanceBypass()
+	}
 
-		if err := client.PutObject(backupFileName+".sha256", bytes.NewReader(sha256Sum), int64(len(sha256Sum)), retention); err != nil {
-			return fmt.Errorf("put object hash: %w", err)
-		}
-		if err := client.PutObject(backupFileName, file, stat.Size(), retention); err != nil {
-			return fmt.Errorf("put object: %w", err)
+	if p.Upload != nil {
+		uploaded, err := upload()
+		if err != nil {
+			return result, err
 		}
+		result.Upload = uploaded
 	} else {
-		slog.Info(fmt.Sprintf("skipping upload, %s will not be retained", backupFileName))
+		slog.Info(fmt.Sprintf("skipping upload, %s", p.SkipReason))
 	}
 
-	// Update object lock retention.
-	if schedule.dailyLock.lockHours > 0 {
-		until := at.Add(time.Hour * time.Duration(schedule.dailyLock.lockHours))
-		retention := &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: until}
+	// Move backups to the storage class of their period.
+	failedCopies := map[s3.ObjectIdentifier]struct{}{}
+	for _, move := range p.Copies {
+		slog.Info(fmt.Sprintf("moving %s to %s", move.Key, move.StorageClass), "period", move.Period)
 
-		for _, file := range retained.daily {
-			if file == backupFileName {
-				continue
+		copyClient := client.WithStorageClass(move.StorageClass).WithMetadata(withSupersedes(move.metadata, move.Supersedes))
+		err := copyClient.CopyObjectContext(ctx, move.Key, move.VersionID, move.Key, move.retention())
+		if err != nil {
+			if ctx.Err() != nil || s3.IsErrorCode(err, s3.ErrCodeAccessDenied) {
+				return result, fmt.Errorf("copy %s: %w", move.Key, err)
 			}
+			slog.Warn("could not move backup", "key", move.Key, "storageClass", move.StorageClass, "error", err)
+			result.Failures = append(result.Failures, ObjectFailure{Operation: operationCopy, Key: move.Key, VersionID: move.VersionID, Message: err.Error()})
+			failedCopies[s3.ObjectIdentifier{Key: move.Key, VersionID: move.VersionID}] = struct{}{}
+			continue
+		}
+		result.Copies = append(result.Copies, move)
+	}
 
-			if schedule.dailyLock.lockType == lockTypeRolling || !slices.Contains(oldRetained.daily, file) {
-				slog.Info(fmt.Sprintf("extending lock for %s", file), "period", "daily")
-
-				err := client.PutObjectRetention(file, retention)
-				if err != nil {
-					return fmt.Errorf("set retention %s: %w", file, err)
-				}
-				err = client.PutObjectRetention(file+".sha256", retention)
-				if err != nil {
-					return fmt.Errorf("set retention %s.sha256: %w", file, err)
-				}
+	// Place legal holds.
+	for _, hold := range p.Holds {
+		slog.Info(fmt.Sprintf("placing legal hold on %s", hold.Key), "period", hold.Period)
+
+		err := client.PutObjectLegalHoldContext(ctx, hold.Key, true)
+		if err != nil {
+			// Access denied or object lock being unavailable affects every object, so give up.
+			if ctx.Err() != nil || s3.IsErrorCode(err, s3.ErrCodeAccessDenied, s3.ErrCodeInvalidRequest) {
+				return result, fmt.Errorf("place legal hold %s: %w", hold.Key, err)
 			}
+			slog.Warn("could not place legal hold", "key", hold.Key, "error", err)
+			result.Failures = append(result.Failures, ObjectFailure{Operation: operationHold, Key: hold.Key, Message: err.Error()})
+			continue
 		}
+		result.Holds = append(result.Holds, hold)
 	}
 
-	if schedule.monthlyLock.lockHours > 0 {
-		until := at.Add(time.Hour * time.Duration(schedule.monthlyLock.lockHours))
-		retention := &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: until}
+	// Update object lock retention.
+	for _, lock := range p.Locks {
+		slog.Info(fmt.Sprintf("extending lock for %s", lock.Key), "period", lock.Period)
 
-		for _, file := range retained.monthly {
-			if file == backupFileName {
-				continue
+		err := lockClient.PutObjectRetentionContext(ctx, lock.Key, &s3.ObjectLockRetention{Mode: lock.Retention.Mode, Until: lock.Retention.Until})
+		if err != nil {
+			// Access denied or object lock being unavailable affects every object, so give up.
+			if ctx.Err() != nil || s3.IsErrorCode(err, s3.ErrCodeAccessDenied, s3.ErrCodeInvalidRequest) {
+				return result, fmt.Errorf("set retention %s: %w", lock.Key, err)
 			}
+			slog.Warn("could not extend lock", "key", lock.Key, "error", err)
+			result.Failures = append(result.Failures, ObjectFailure{Operation: operationLock, Key: lock.Key, Message: err.Error()})
+			continue
+		}
+		result.Locks = append(result.Locks, lock)
+	}
 
-			if schedule.monthlyLock.lockType == lockTypeRolling || !slices.Contains(oldRetained.monthly, file) {
-				slog.Info(fmt.Sprintf("extending lock for %s", file), "period", "monthly")
-
-				err := client.PutObjectRetention(file, retention)
-				if err != nil {
-					return fmt.Errorf("set retention %s: %w", file, err)
-				}
-				err = client.PutObjectRetention(file+".sha256", retention)
-				if err != nil {
-					return fmt.Errorf("set retention %s.sha256: %w", file, err)
-				}
-			}
+	// Delete non-retained files, and versions superseded by a copy. A version whose copy failed
+	// is still the only one of its backup.
+	deletions := []PlannedDeletion{}
+	toDelete := []s3.ObjectIdentifier{}
+	for _, deletion := range p.Deletions {
+		object := s3.ObjectIdentifier{Key: deletion.Key, VersionID: deletion.VersionID}
+		if _, ok := failedCopies[object]; ok {
+			continue
+		}
+		deletions = append(deletions, deletion)
+		toDelete = append(toDelete, object)
+
+		if deletion.Superseded {
+			slog.Info(fmt.Sprintf("%s::%s superseded, deleting", deletion.Key, deletion.VersionID))
+		} else {
+			slog.Info(fmt.Sprintf("%s::%s not retained, deleting", deletion.Key, deletion.VersionID))
 		}
 	}
 
-	if schedule.yearlyLock.lockHours > 0 {
-		until := at.Add(time.Hour * time.Duration(schedule.yearlyLock.lockHours))
-		retention := &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: until}
+	for _, held := range p.Held {
+		slog.Info(fmt.Sprintf("%s::%s not retained, kept by its legal hold", held.Key, held.VersionID))
+	}
 
-		for _, file := range retained.yearly {
-			if file == backupFileName {
+	if len(toDelete) > 0 {
+		deleted, err := lockClient.DeleteObjectsContext(ctx, toDelete)
+		if err != nil {
+			return result, fmt.Errorf("delete objects: %w", err)
+		}
+
+		superseded := map[s3.ObjectIdentifier]bool{}
+		for _, deletion := range deletions {
+			superseded[s3.ObjectIdentifier{Key: deletion.Key, VersionID: deletion.VersionID}] = deletion.Superseded
+		}
+
+		// Deletes are quiet, so only failures are listed. Everything else was deleted.
+		failed := map[s3.ObjectIdentifier]struct{}{}
+		for _, deleteError := range deleted.Error {
+			// Versions that no longer exist are as good as deleted.
+			if deleteError.Code == s3.ErrCodeNoSuchKey || deleteError.Code == s3.ErrCodeNoSuchVersion {
 				continue
 			}
 
-			if schedule.yearlyLock.lockType == lockTypeRolling || !slices.Contains(oldRetained.yearly, file) {
-				slog.Info(fmt.Sprintf("extending lock for %s", file), "period", "yearly")
-
-				err := client.PutObjectRetention(file, retention)
-				if err != nil {
-					return fmt.Errorf("set retention %s: %w", file, err)
-				}
-				err = client.PutObjectRetention(file+".sha256", retention)
-				if err != nil {
-					return fmt.Errorf("set retention %s.sha256: %w", file, err)
-				}
+			object := s3.ObjectIdentifier{Key: deleteError.Key, VersionID: deleteError.VersionID}
+			failed[object] = struct{}{}
+
+			// Superseded versions are usually still locked from their previous period. The backup
+			// itself is safe in its copy, so they are left for a later run.
+			if superseded[object] {
+				slog.Info("superseded version not deleted yet", "key", deleteError.Key, "version", deleteError.VersionID, "message", deleteError.Message)
+				continue
+			}
+
+			slog.Warn("could not delete file", "key", deleteError.Key, "version", deleteError.VersionID, "message", deleteError.Message)
+			result.Failures = append(result.Failures, ObjectFailure{Operation: operationDelete, Key: deleteError.Key, VersionID: deleteError.VersionID, Message: deleteError.Message})
+		}
+		for _, deletion := range deletions {
+			if _, ok := failed[s3.ObjectIdentifier{Key: deletion.Key, VersionID: deletion.VersionID}]; !ok {
+				result.Deletions = append(result.Deletions, deletion)
 			}
 		}
 	}
 
-	// Delete non-retained files.
-	allRetained := retained.All()
+	if len(result.Failures) > 0 {
+		return result, fmt.Errorf("%w: %d objects could not be updated", ErrPartialFailure, len(result.Failures))
+	}
 
-	toDelete := []s3.ObjectIdentifier{}
-	for _, object := range objectVersions.Versions {
-		key := strings.TrimSuffix(object.Key, ".sha256") // remove hash suffix if it exists
+	return result, nil
+}
 
-		if !slices.Contains(allRetained, key) {
-			toDelete = append(toDelete, s3.ObjectIdentifier{Key: object.Key, VersionID: object.VersionId})
-			slog.Info(fmt.Sprintf("%s::%s not retained, deleting", object.Key, object.VersionId))
-		}
+func (p *Plan) uploadFile(ctx context.Context, client *s3.Client, filePath string) (*UploadResult, error) {
+	stat, err := os.Stat(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("file stat: %w", err)
 	}
 
-	for _, object := range objectVersions.DeleteMarkers {
-		key := strings.TrimSuffix(object.Key, ".sha256") // remove hash suffix if it exists
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("read file: %w", err)
+	}
+	defer func() { _ = file.Close() }()
 
-		if !slices.Contains(allRetained, key) {
-			toDelete = append(toDelete, s3.ObjectIdentifier{Key: object.Key, VersionID: object.VersionId})
-			slog.Info(fmt.Sprintf("%s::%s not retained, deleting", object.Key, object.VersionId))
-		}
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return nil, err
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, err
 	}
+	sha256Sum := []byte(hex.EncodeToString(hash.Sum(nil)))
 
-	if len(toDelete) > 0 {
-		result, err := client.DeleteObjects(toDelete)
-		if err != nil {
-			return fmt.Errorf("delete objects: %w", err)
+	slog.Info(fmt.Sprintf("Uploading %s", p.Upload.Key))
+
+	retention := p.Upload.retention()
+
+	if err := client.PutObjectContext(ctx, p.Upload.HashKey, bytes.NewReader(sha256Sum), int64(len(sha256Sum)), retention); err != nil {
+		return nil, fmt.Errorf("put object hash: %w", err)
+	}
+	dataClient := p.uploadClient(client)
+	if stat.Size() > p.options.multipartThreshold() {
+		if err := dataClient.PutObjectMultipartContext(ctx, p.Upload.Key, file, stat.Size(), retention); err != nil {
+			return nil, fmt.Errorf("put object multipart: %w", err)
 		}
-		if len(result.Error) > 0 {
-			for _, deleteError := range result.Error {
-				slog.Warn("could not delete file", "key", deleteError.Key, "version", deleteError.VersionID, "message", deleteError.Message)
-			}
+	} else {
+		if err := dataClient.PutObjectContext(ctx, p.Upload.Key, file, stat.Size(), retention); err != nil {
+			return nil, fmt.Errorf("put object: %w", err)
 		}
 	}
 
-	return nil
+	return p.Upload.result(stat.Size(), string(sha256Sum)), nil
+}
+
+func (p *Plan) uploadStream(ctx context.Context, client *s3.Client, r io.Reader) (*UploadResult, error) {
+	slog.Info(fmt.Sprintf("Uploading %s", p.Upload.Key))
+
+	retention := p.Upload.retention()
+
+	hash := sha256.New()
+	size, err := p.uploadClient(client).PutObjectStreamContext(ctx, p.Upload.Key, io.TeeReader(r, hash), retention)
+	if err != nil {
+		return nil, fmt.Errorf("put object stream: %w", err)
+	}
+	slog.Info(fmt.Sprintf("Uploaded %d bytes to %s", size, p.Upload.Key))
+
+	sha256Sum := []byte(hex.EncodeToString(hash.Sum(nil)))
+	if err := client.PutObjectContext(ctx, p.Upload.HashKey, bytes.NewReader(sha256Sum), int64(len(sha256Sum)), retention); err != nil {
+		return nil, fmt.Errorf("put object hash: %w", err)
+	}
+
+	return p.Upload.result(size, string(sha256Sum)), nil
+}
+
+// uploadClient uploads the backup into the storage class of its period, with the metadata of the
+// options. Hash files are uploaded with the client as it is, into the default class.
+func (p *Plan) uploadClient(client *s3.Client) *s3.Client {
+	if p.Upload.StorageClass != "" {
+		client = client.WithStorageClass(p.Upload.StorageClass)
+	}
+	if metadata := withSupersedes(p.options.Metadata, p.Upload.Supersedes); len(metadata) > 0 {
+		client = client.WithMetadata(metadata)
+	}
+	return client
+}
+
+func (u *PlannedUpload) result(size int64, sha256Sum string) *UploadResult {
+	return &UploadResult{
+		Key:          u.Key,
+		HashKey:      u.HashKey,
+		Period:       u.Period,
+		StorageClass: u.StorageClass,
+		Retention:    u.Retention,
+		Size:         size,
+		SHA256:       sha256Sum,
+	}
+}
+
+func (u *PlannedUpload) retention() *s3.ObjectLockRetention {
+	if u.Retention == nil {
+		return nil
+	}
+	return &s3.ObjectLockRetention{
+		Mode:  u.Retention.Mode,
+		Until: u.Retention.Until,
+	}
+}
+
+func (c *PlannedCopy) retention() *s3.ObjectLockRetention {
+	if c.Retention == nil {
+		return nil
+	}
+	return &s3.ObjectLockRetention{
+		Mode:  c.Retention.Mode,
+		Until: c.Retention.Until,
+	}
 }
@@ -2,7 +2,14 @@ package marmalade
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
 	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -12,20 +19,27 @@ import (
 )
 
 func setupTest(t *testing.T) (*s3.Client, *fakes3.FakeS3, string) {
+	return setupTestWithConfig(t, s3.Config{})
+}
+
+func setupTestWithConfig(t *testing.T, config s3.Config) (*s3.Client, *fakes3.FakeS3, string) {
 	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMaxKeys(2) // keep pages small so every test exercises pagination
+	if config.AllowSmallParts {
+		sv.SetMinPartSize(0)
+	}
 
 	sv.StartServer()
 	t.Cleanup(func() { sv.StopServer() })
 	url := sv.GetEndpoint()
 
-	client := s3.NewClient(s3.Config{
-		URL:       url,
-		Region:    "my-region",
-		KeyID:     "keyid",
-		KeySecret: "shh",
-		Bucket:    "my-bucket",
-		Insecure:  true,
-	})
+	config.URL = url
+	config.Region = "my-region"
+	config.KeyID = "keyid"
+	config.KeySecret = "shh"
+	config.Bucket = "my-bucket"
+	config.Insecure = true
+	client := s3.NewClient(config)
 
 	file, err := os.CreateTemp("", "*.txt")
 	assert.NoErr(t, err)
@@ -43,27 +57,43 @@ func TestCanBackup(t *testing.T) {
 	client, fs3, file := setupTest(t)
 	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
 
-	err := Backup(client, schedule, now, file)
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*2))
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*2))
 
 	// try again, expect no changes - should never upload duplicate files
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*2))
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*2))
 }
 
+func TestHourlyScheduleKeepsSeveralBackupsADay(t *testing.T) {
+	schedule := RetentionSchedule{hourly: 2, daily: 1}
+	client, fs3, file := setupTest(t)
+	first := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	for i := range 3 {
+		_, err := Backup(client, schedule, first.Add(time.Duration(i)*time.Hour), file, BackupOptions{})
+		assert.NoErr(t, err)
+	}
+
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05T03.txt")))
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05T04.txt"), time.Time{})
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05T05.txt"), time.Time{})
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05T05.txt.sha256"), time.Time{})
+}
+
 func TestSkipsUploadIfNotRetaining(t *testing.T) {
 	schedule := RetentionSchedule{daily: 0}
 
 	client, fs3, file := setupTest(t)
 	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
 
-	err := Backup(client, schedule, now, file)
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05.txt")))
@@ -75,7 +105,7 @@ func TestCanBackupWithNoLock(t *testing.T) {
 	client, fs3, file := setupTest(t)
 	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
 
-	err := Backup(client, schedule, now, file)
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), time.Time{})
@@ -89,7 +119,7 @@ func TestDeletesUnknownFiles(t *testing.T) {
 	err := client.PutObject("randomfile.txt", bytes.NewReader([]byte("abc")), 3, nil)
 	assert.NoErr(t, err)
 
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.Equal(t, 0, len(fs3.GetVersions("randomfile.txt")))
@@ -97,6 +127,124 @@ func TestDeletesUnknownFiles(t *testing.T) {
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*2))
 }
 
+func TestDeletesUnknownFilesAcrossPages(t *testing.T) {
+	client, fs3, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	for _, key := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		err := client.PutObject(key, bytes.NewReader([]byte("abc")), 3, nil)
+		assert.NoErr(t, err)
+		err = client.PutObject(key, bytes.NewReader([]byte("abc")), 3, nil)
+		assert.NoErr(t, err)
+	}
+
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	for _, key := range []string{"a.txt", "b.txt", "c.txt", "d.txt", "e.txt"} {
+		assert.Equal(t, 0, len(fs3.GetVersions(key)))
+	}
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*2))
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*2))
+}
+
+func TestPrefixScopesBackupSet(t *testing.T) {
+	client, fs3, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	for _, key := range []string{"randomfile.txt", "other/2025-03-01.txt", "app/nested/2025-03-01.txt", "app/randomfile.txt"} {
+		err := client.PutObject(key, bytes.NewReader([]byte("abc")), 3, nil)
+		assert.NoErr(t, err)
+	}
+
+	_, err := Backup(client, schedule, now, file, BackupOptions{Prefix: "app/"})
+	assert.NoErr(t, err)
+
+	// only unknown files directly under the prefix are deleted
+	assert.Equal(t, 0, len(fs3.GetVersions("app/randomfile.txt")))
+	assert.HasOneVersion(t, fs3.GetVersions("randomfile.txt"), time.Time{})
+	assert.HasOneVersion(t, fs3.GetVersions("other/2025-03-01.txt"), time.Time{})
+	assert.HasOneVersion(t, fs3.GetVersions("app/nested/2025-03-01.txt"), time.Time{})
+
+	assert.HasOneVersion(t, fs3.GetVersions("app/2025-03-05.txt"), now.Add(time.Hour*2))
+	assert.HasOneVersion(t, fs3.GetVersions("app/2025-03-05.txt.sha256"), now.Add(time.Hour*2))
+
+	// a backup set at the root of the bucket leaves prefixed sets alone
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	assert.Equal(t, 0, len(fs3.GetVersions("randomfile.txt")))
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*2))
+	assert.HasOneVersion(t, fs3.GetVersions("app/2025-03-05.txt"), now.Add(time.Hour*2))
+	assert.HasOneVersion(t, fs3.GetVersions("other/2025-03-01.txt"), time.Time{})
+
+	// retention is calculated per prefix
+	next := now.Add(24 * time.Hour)
+	fs3.SetNow(next)
+	_, err = Backup(client, RetentionSchedule{daily: 1}, next, file, BackupOptions{Prefix: "app/"})
+	assert.NoErr(t, err)
+
+	assert.Equal(t, 0, len(fs3.GetVersions("app/2025-03-05.txt")))
+	assert.HasOneVersion(t, fs3.GetVersions("app/2025-03-06.txt"), time.Time{})
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*2))
+}
+
+func TestPrefixLeavesSiblingSetsAlone(t *testing.T) {
+	client, fs3, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	siblings := []string{"apple/randomfile.txt", "app2025-03-01.txt", "app-randomfile.txt"}
+	for _, key := range siblings {
+		err := client.PutObject(key, bytes.NewReader([]byte("abc")), 3, nil)
+		assert.NoErr(t, err)
+	}
+
+	// a prefix that could match sibling sets is rejected
+	_, err := Backup(client, schedule, now, file, BackupOptions{Prefix: "app"})
+	assert.ErrContains(t, err, `prefix "app" must end in /`)
+	assert.Equal(t, 0, len(fs3.GetVersions("app2025-03-05.txt")))
+
+	_, err = Backup(client, schedule, now, file, BackupOptions{Prefix: "app/"})
+	assert.NoErr(t, err)
+
+	for _, key := range siblings {
+		assert.HasOneVersion(t, fs3.GetVersions(key), time.Time{})
+	}
+	assert.HasOneVersion(t, fs3.GetVersions("app/2025-03-05.txt"), now.Add(time.Hour*2))
+}
+
+func TestUsesMultipartAboveThreshold(t *testing.T) {
+	client, fs3, file := setupTestWithConfig(t, s3.Config{MultipartPartSize: 4, AllowSmallParts: true})
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	err := os.WriteFile(file, []byte("abcdefghij"), 0600)
+	assert.NoErr(t, err)
+
+	var parts atomic.Int32
+	fs3.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.URL.Query().Has("partNumber") {
+			parts.Add(1)
+		}
+		return false
+	})
+
+	// at the threshold a single put is used
+	_, err = Backup(client, schedule, now, file, BackupOptions{MultipartThreshold: 10})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, parts.Load())
+
+	// above the threshold the file is uploaded in parts
+	fs3.Reset()
+	_, err = Backup(client, schedule, now, file, BackupOptions{MultipartThreshold: 9})
+	assert.NoErr(t, err)
+	assert.Equal(t, 3, parts.Load())
+
+	versions := fs3.GetVersions("2025-03-05.txt")
+	assert.HasOneVersion(t, versions, now.Add(time.Hour*2))
+	assert.Equal(t, "abcdefghij", string(versions[0].Content))
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*2))
+}
+
 func TestPutsWithLockTime(t *testing.T) {
 	client, fs3, file := setupTest(t)
 	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
@@ -104,7 +252,7 @@ func TestPutsWithLockTime(t *testing.T) {
 	// DAILY
 	fs3.Reset()
 	schedule := RetentionSchedule{daily: 1, dailyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 2}}
-	err := Backup(client, schedule, now, file)
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*2))
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*2))
@@ -112,7 +260,7 @@ func TestPutsWithLockTime(t *testing.T) {
 	// MONTHLY
 	fs3.Reset()
 	schedule = RetentionSchedule{monthly: 1, monthlyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 3}}
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*3))
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*3))
@@ -120,7 +268,7 @@ func TestPutsWithLockTime(t *testing.T) {
 	// YEARLY
 	fs3.Reset()
 	schedule = RetentionSchedule{yearly: 1, yearlyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 4}}
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*4))
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt.sha256"), now.Add(time.Hour*4))
@@ -137,23 +285,23 @@ func TestUpdatesRollingRetention(t *testing.T) {
 	// backup March 5 2025, April 5 2026, May 2 2026
 	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
 	fs3.SetNow(now)
-	err := Backup(client, schedule, now, file)
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	now = time.Date(2026, time.April, 5, 3, 0, 0, 0, time.UTC)
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	now = time.Date(2026, time.May, 2, 3, 0, 0, 0, time.UTC)
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	// do one more backup on May 3
 	now = time.Date(2026, time.May, 3, 3, 0, 0, 0, time.UTC)
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	// check retentions have been extended
@@ -182,7 +330,7 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
 	mar5 := now
 	fs3.SetNow(now)
-	err := Backup(client, schedule, now, file)
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), now.Add(time.Hour*2))
@@ -192,7 +340,7 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	now = time.Date(2025, time.March, 6, 3, 0, 0, 0, time.UTC)
 	mar6 := now
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), mar5.Add(time.Hour*2))
@@ -204,7 +352,7 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	now = time.Date(2025, time.April, 1, 3, 0, 0, 0, time.UTC)
 	apr1 := now
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05.txt")))
@@ -218,7 +366,7 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	now = time.Date(2025, time.May, 2, 3, 0, 0, 0, time.UTC)
 	may2 := now
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.HasOneVersion(t, fs3.GetVersions("2025-03-06.txt"), may2.Add(time.Hour*3)) // was upgraded to monthly
@@ -232,7 +380,7 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	now = time.Date(2026, time.October, 2, 3, 0, 0, 0, time.UTC)
 	oct2 := now
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-06.txt")))
@@ -248,7 +396,7 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	now = time.Date(2026, time.November, 2, 3, 0, 0, 0, time.UTC)
 	nov2 := now
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.Equal(t, 0, len(fs3.GetVersions("2025-04-01.txt")))
@@ -264,7 +412,7 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	now = time.Date(2026, time.December, 2, 3, 0, 0, 0, time.UTC)
 	dec2 := now
 	fs3.SetNow(now)
-	err = Backup(client, schedule, now, file)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
 	assert.NoErr(t, err)
 
 	assert.HasOneVersion(t, fs3.GetVersions("2025-05-02.txt"), dec2.Add(time.Hour*4)) // was upgrade to yearly
@@ -276,3 +424,282 @@ func TestUpdatesSimpleRetentionAndDeletes(t *testing.T) {
 	assert.HasOneVersion(t, fs3.GetVersions("2026-12-02.txt"), dec2.Add(time.Hour*2))
 	assert.HasOneVersion(t, fs3.GetVersions("2026-12-02.txt.sha256"), dec2.Add(time.Hour*2))
 }
+
+func TestMovesPromotedBackupsToStorageClass(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyLock: lockSchedule{lockType: lockTypeSimple, lockHours: 48},
+		monthly: 2, monthlyLock: lockSchedule{lockType: lockTypeSimple, lockHours: 72}, monthlyStorageClass: "GLACIER_IR",
+	}
+	client, fs3, file := setupTest(t)
+
+	// backup March 31 2025, kept daily in the default class
+	mar31 := time.Date(2025, time.March, 31, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar31)
+	_, err := Backup(client, schedule, mar31, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, "STANDARD", fs3.GetVersions("2025-03-31.txt")[0].StorageClass)
+
+	// backup April 1 2025, March 31 is promoted to monthly and copied into GLACIER_IR
+	apr1 := time.Date(2025, time.April, 1, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr1)
+	result, err := Backup(client, schedule, apr1, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	assert.Equal(t, 1, len(result.Copies))
+	assert.Equal(t, "2025-03-31.txt", result.Copies[0].Key)
+	assert.Equal(t, "monthly", result.Copies[0].Period)
+	assert.Equal(t, "GLACIER_IR", result.Copies[0].StorageClass)
+	assert.Equal(t, 0, len(result.Failures))
+
+	// the old version is still locked, so it is kept for now
+	versions := fs3.GetVersions("2025-03-31.txt")
+	assert.Equal(t, 2, len(versions))
+	assert.Equal(t, "STANDARD", versions[0].StorageClass)
+	assert.Equal(t, "GLACIER_IR", versions[1].StorageClass)
+	assert.Equal(t, apr1.Add(time.Hour*72), versions[1].Retention.Until)
+	assert.Equal(t, "STANDARD", fs3.GetVersions("2025-03-31.txt.sha256")[0].StorageClass)
+	assert.HasOneVersion(t, fs3.GetVersions("2025-04-01.txt"), apr1.Add(time.Hour*48))
+
+	// the copy records the version it supersedes
+	assert.Equal(t, versions[0].VersionID, versions[1].Metadata["marmalade-supersedes"])
+
+	// backup April 3 2025, once the old version is unlocked it is deleted
+	apr3 := time.Date(2025, time.April, 3, 6, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr3)
+	result, err = Backup(client, schedule, apr3, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(result.Copies))
+
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-31.txt"), apr1.Add(time.Hour*72))
+	assert.Equal(t, "GLACIER_IR", fs3.GetVersions("2025-03-31.txt")[0].StorageClass)
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-04-01.txt")))
+}
+
+func TestKeepsVersionsNotSuperseded(t *testing.T) {
+	schedule := RetentionSchedule{daily: 2, dailyStorageClass: "STANDARD", monthly: 2, monthlyStorageClass: "GLACIER_IR"}
+	client, fs3, file := setupTest(t)
+
+	mar30 := time.Date(2025, time.March, 30, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar30)
+	_, err := Backup(client, schedule, mar30, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// another version was put over the backup by something other than marmalade
+	err = client.PutObject("2025-03-30.txt", bytes.NewReader([]byte("other")), 5, nil)
+	assert.NoErr(t, err)
+
+	mar31 := time.Date(2025, time.March, 31, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar31)
+	plan, err := PlanBackup(client, schedule, mar31, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(plan.Deletions))
+
+	_, err = plan.Execute(client, file)
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(fs3.GetVersions("2025-03-30.txt")))
+}
+
+func TestDoesNotMoveHeldBackups(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily:   1,
+		monthly: 2, monthlyLock: lockSchedule{legalHold: true}, monthlyStorageClass: "GLACIER_IR",
+	}
+	client, fs3, file := setupTest(t)
+
+	mar31 := time.Date(2025, time.March, 31, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar31)
+	_, err := Backup(client, schedule, mar31, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// a held version could not be deleted once copied, so it is left in its storage class
+	err = client.PutObjectLegalHold("2025-03-31.txt", true)
+	assert.NoErr(t, err)
+
+	apr1 := time.Date(2025, time.April, 1, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr1)
+	result, err := Backup(client, schedule, apr1, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(result.Copies))
+	assert.Equal(t, 1, len(fs3.GetVersions("2025-03-31.txt")))
+	assert.Equal(t, "STANDARD", fs3.GetVersions("2025-03-31.txt")[0].StorageClass)
+}
+
+func TestBypassesGovernanceLocks(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyLock: lockSchedule{lockType: lockTypeSimple, lockHours: 48, mode: s3.LockModeGovernance},
+	}
+	client, fs3, file := setupTest(t)
+
+	mar5 := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar5)
+	_, err := Backup(client, schedule, mar5, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.LockModeGovernance, fs3.GetVersions("2025-03-05.txt")[0].Retention.Mode)
+
+	// March 5 is no longer retained, but still locked
+	mar6 := time.Date(2025, time.March, 6, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar6)
+	result, err := Backup(client, schedule, mar6, file, BackupOptions{})
+	assert.ErrIs(t, err, ErrPartialFailure)
+	assert.Equal(t, 2, len(result.Failures))
+	assert.Equal(t, 1, len(fs3.GetVersions("2025-03-05.txt")))
+
+	// bypassing governance deletes it anyway
+	result, err = Backup(client, schedule, mar6, file, BackupOptions{BypassGovernance: true})
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(result.Deletions))
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05.txt")))
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05.txt.sha256")))
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-06.txt"), mar6.Add(time.Hour*48))
+}
+
+func TestPlacesLegalHolds(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyLock: lockSchedule{lockType: lockTypeSimple, lockHours: 2},
+		monthly: 2, monthlyLock: lockSchedule{legalHold: true},
+	}
+	client, fs3, file := setupTest(t)
+
+	mar31 := time.Date(2025, time.March, 31, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar31)
+	result, err := Backup(client, schedule, mar31, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(result.Holds))
+	assert.True(t, !fs3.GetVersions("2025-03-31.txt")[0].LegalHold)
+
+	// March 31 is promoted to monthly and held, along with its hash
+	apr1 := time.Date(2025, time.April, 1, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr1)
+	result, err = Backup(client, schedule, apr1, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(result.Holds))
+	assert.Equal(t, "2025-03-31.txt", result.Holds[0].Key)
+	assert.Equal(t, "monthly", result.Holds[0].Period)
+	assert.True(t, fs3.GetVersions("2025-03-31.txt")[0].LegalHold)
+	assert.True(t, fs3.GetVersions("2025-03-31.txt.sha256")[0].LegalHold)
+	assert.True(t, !fs3.GetVersions("2025-04-01.txt")[0].LegalHold)
+
+	// once it ages out of the monthly backups, the hold keeps it without failing the run
+	apr30 := time.Date(2025, time.April, 30, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr30)
+	_, err = Backup(client, schedule, apr30, file, BackupOptions{BypassGovernance: true})
+	assert.NoErr(t, err)
+	may1 := time.Date(2025, time.May, 1, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(may1)
+	plan, err := PlanBackup(client, schedule, may1, file, BackupOptions{BypassGovernance: true})
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(plan.Held))
+	assert.Equal(t, "2025-03-31.txt", plan.Held[0].Key)
+	assert.True(t, !slices.ContainsFunc(plan.Deletions, func(d PlannedDeletion) bool { return strings.HasPrefix(d.Key, "2025-03-31.txt") }))
+
+	result, err = plan.Execute(client, file)
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(result.Failures))
+	assert.Equal(t, 1, len(fs3.GetVersions("2025-03-31.txt")))
+	assert.Equal(t, 1, len(fs3.GetVersions("2025-03-31.txt.sha256")))
+
+	// the next run is clean too
+	may2 := time.Date(2025, time.May, 2, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(may2)
+	result, err = Backup(client, schedule, may2, file, BackupOptions{BypassGovernance: true})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(result.Failures))
+
+	// releasing the hold lets the next run delete it
+	err = client.PutObjectLegalHold("2025-03-31.txt", false)
+	assert.NoErr(t, err)
+	err = client.PutObjectLegalHold("2025-03-31.txt.sha256", false)
+	assert.NoErr(t, err)
+	may3 := time.Date(2025, time.May, 3, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(may3)
+	_, err = Backup(client, schedule, may3, file, BackupOptions{BypassGovernance: true})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-31.txt")))
+}
+
+func TestExecuteStream(t *testing.T) {
+	client, fs3, _ := setupTestWithConfig(t, s3.Config{MultipartPartSize: 4, AllowSmallParts: true})
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	plan, err := PlanBackup(client, schedule, now, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+
+	_, err = plan.ExecuteStream(client, strings.NewReader("abcdefghij"))
+	assert.NoErr(t, err)
+
+	versions := fs3.GetVersions("2025-03-05.txt")
+	assert.HasOneVersion(t, versions, now.Add(time.Hour*2))
+	assert.Equal(t, "abcdefghij", string(versions[0].Content))
+
+	versions = fs3.GetVersions("2025-03-05.txt.sha256")
+	assert.HasOneVersion(t, versions, now.Add(time.Hour*2))
+	assert.Equal(t, "72399361da6a7754fec986dca5b7cbaf1c810a28ded4abaf56b2106d06cb78b0", string(versions[0].Content))
+
+	// the stream is not read if nothing is uploaded
+	plan, err = PlanBackup(client, schedule, now, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+
+	_, err = plan.ExecuteStream(client, &panicReader{})
+	assert.NoErr(t, err)
+}
+
+func TestUploadsAgainWithoutHash(t *testing.T) {
+	schedule := RetentionSchedule{daily: 1}
+	client, fs3, _ := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	// the run fails between uploading the backup and its hash
+	fs3.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.Method == http.MethodPut && strings.HasSuffix(r.URL.Path, ".sha256") {
+			fakes3.WriteError(w, http.StatusForbidden, "AccessDenied", "Access Denied")
+			return true
+		}
+		return false
+	})
+
+	plan, err := PlanBackup(client, schedule, now, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+	_, err = plan.ExecuteStream(client, strings.NewReader("abc"))
+	assert.ErrContains(t, err, "put object hash")
+	assert.Equal(t, 1, len(fs3.GetVersions("2025-03-05.txt")))
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05.txt.sha256")))
+
+	// the next run uploads it again, replacing the backup that cannot be restored
+	fs3.SetInterceptor(nil)
+	plan, err = PlanBackup(client, schedule, now, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+	assert.NotZero(t, plan.Upload)
+	incomplete := fs3.GetVersions("2025-03-05.txt")[0].VersionID
+	assert.Equal(t, incomplete, strings.Join(plan.Upload.Supersedes, ","))
+	_, err = plan.ExecuteStream(client, strings.NewReader("abcd"))
+	assert.NoErr(t, err)
+
+	versions := fs3.GetVersions("2025-03-05.txt")
+	assert.Equal(t, 1, len(versions))
+	assert.Equal(t, "abcd", string(versions[0].Content))
+	assert.Equal(t, incomplete, versions[0].Metadata["marmalade-supersedes"])
+	assert.Equal(t, 1, len(fs3.GetVersions("2025-03-05.txt.sha256")))
+
+	err = Restore(client, "2025-03-05.txt", func(r io.Reader) error { return nil })
+	assert.NoErr(t, err)
+}
+
+type panicReader struct{}
+
+func (panicReader) Read([]byte) (int, error) {
+	panic("stream should not be read")
+}
+
+func TestBackupContextStopsWhenCancelled(t *testing.T) {
+	client, fs3, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := BackupContext(ctx, client, schedule, now, file, BackupOptions{})
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05.txt")))
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-03-05.txt.sha256")))
+}
@@ -0,0 +1,66 @@
+package marmalade
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+)
+
+func TestCronNext(t *testing.T) {
+	from := time.Date(2025, time.March, 5, 3, 17, 42, 0, time.UTC) // Wednesday
+
+	testCases := []struct {
+		expression string
+		from       time.Time
+		expected   time.Time
+	}{
+		{"* * * * *", from, time.Date(2025, time.March, 5, 3, 18, 0, 0, time.UTC)},
+		{"0 3 * * *", from, time.Date(2025, time.March, 6, 3, 0, 0, 0, time.UTC)},
+		{"@daily", from, time.Date(2025, time.March, 6, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", from, time.Date(2025, time.March, 5, 4, 0, 0, 0, time.UTC)},
+		{"*/15 * * * *", from, time.Date(2025, time.March, 5, 3, 30, 0, 0, time.UTC)},
+		{"30 9-17/4 * * *", from, time.Date(2025, time.March, 5, 9, 30, 0, 0, time.UTC)},
+		{"0 0 * * 0", from, time.Date(2025, time.March, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", from, time.Date(2025, time.March, 9, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1,15 * *", from, time.Date(2025, time.March, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", from, time.Date(2028, time.February, 29, 0, 0, 0, 0, time.UTC)},
+		{"0 12 1 1 *", from, time.Date(2026, time.January, 1, 12, 0, 0, 0, time.UTC)},
+		// both day fields restricted, either matches
+		{"0 0 1 * 5", from, time.Date(2025, time.March, 7, 0, 0, 0, 0, time.UTC)},
+		// exactly on a match moves to the next one
+		{"0 3 * * *", time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC), time.Date(2025, time.March, 6, 3, 0, 0, 0, time.UTC)},
+		// never matches
+		{"0 0 30 2 *", from, time.Time{}},
+	}
+
+	for i, tc := range testCases {
+		schedule, err := ParseCron(tc.expression)
+		assert.NoErr(t, err)
+
+		actual := schedule.Next(tc.from)
+		if !actual.Equal(tc.expected) {
+			t.Errorf("%d: %s: expected=%s, actual=%s", i, tc.expression, tc.expected, actual)
+		}
+	}
+}
+
+func TestParseCronErrors(t *testing.T) {
+	testCases := []struct {
+		input string
+		error string
+	}{
+		{"", "cron expression must have 5 fields"},
+		{"0 3 * *", "cron expression must have 5 fields"},
+		{"60 * * * *", "minute out of range 0-59: 60"},
+		{"* 5-2 * * *", "hour out of range 0-23: 5-2"},
+		{"* * 0 * *", "day of month out of range 1-31: 0"},
+		{"*/0 * * * *", "invalid step in minute: */0"},
+		{"* * * jan *", "invalid month: jan"},
+	}
+
+	for _, tc := range testCases {
+		_, err := ParseCron(tc.input)
+		assert.ErrContains(t, err, tc.error)
+	}
+}
@@ -0,0 +1,236 @@
+package marmalade
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Clock is the source of time of the daemon, and of waiting for archived backups to be restored.
+type Clock interface {
+	Now() time.Time
+	After(d time.Duration) <-chan time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time                         { return time.Now() }
+func (systemClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+
+// DaemonJob is a backup run by the daemon on a cron schedule.
+type DaemonJob struct {
+	Name     string
+	Schedule CronSchedule
+
+	// Run performs the backup, normally through Backup. ctx is cancelled if the run is aborted
+	// while the daemon shuts down.
+	Run func(ctx context.Context, at time.Time) error
+}
+
+type DaemonOptions struct {
+	// Jitter delays each run by a random duration up to Jitter.
+	Jitter time.Duration
+
+	// ShutdownTimeout is how long in-flight runs may take to finish once the daemon is stopped.
+	// Runs still going after it are aborted. Zero aborts them straight away.
+	ShutdownTimeout time.Duration
+
+	// StatePath is a file recording when each job last ran successfully, so runs missed while
+	// the daemon was down are caught up when it starts. Missed runs are not caught up without it.
+	StatePath string
+
+	// Clock defaults to the system clock.
+	Clock Clock
+}
+
+type Daemon struct {
+	jobs    []DaemonJob
+	options DaemonOptions
+	clock   Clock
+	jitter  func(n int64) int64
+
+	mu    sync.Mutex
+	state daemonState
+}
+
+type daemonState struct {
+	LastRuns map[string]time.Time `json:"last_runs"`
+}
+
+func NewDaemon(jobs []DaemonJob, options DaemonOptions) (*Daemon, error) {
+	if len(jobs) == 0 {
+		return nil, fmt.Errorf("no jobs to run")
+	}
+
+	seen := map[string]struct{}{}
+	for _, job := range jobs {
+		if _, ok := seen[job.Name]; ok {
+			return nil, fmt.Errorf("duplicate job: %s", job.Name)
+		}
+		seen[job.Name] = struct{}{}
+	}
+
+	d := &Daemon{
+		jobs:    jobs,
+		options: options,
+		clock:   options.Clock,
+		jitter:  rand.Int64N,
+		state:   daemonState{LastRuns: map[string]time.Time{}},
+	}
+	if d.clock == nil {
+		d.clock = systemClock{}
+	}
+
+	if options.StatePath != "" {
+		data, err := os.ReadFile(options.StatePath)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("read daemon state: %w", err)
+		}
+		if err == nil {
+			if err := json.Unmarshal(data, &d.state); err != nil {
+				return nil, fmt.Errorf("parse daemon state %s: %w", options.StatePath, err)
+			}
+			if d.state.LastRuns == nil {
+				d.state.LastRuns = map[string]time.Time{}
+			}
+		}
+	}
+
+	return d, nil
+}
+
+// Run runs every job on its schedule until ctx is cancelled. A job never runs twice at once.
+// Once ctx is cancelled no new runs are started, and Run returns when in-flight runs have
+// finished or been aborted.
+func (d *Daemon) Run(ctx context.Context) error {
+	runCtx, abort := context.WithCancel(context.WithoutCancel(ctx))
+	defer abort()
+
+	var wg sync.WaitGroup
+	for _, job := range d.jobs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.runJob(ctx, runCtx, job)
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	slog.Info("daemon stopping, waiting for in-flight backups")
+	select {
+	case <-done:
+	case <-d.clock.After(d.options.ShutdownTimeout):
+		slog.Warn("aborting in-flight backups")
+		abort()
+		<-done
+	}
+
+	return nil
+}
+
+func (d *Daemon) runJob(ctx context.Context, runCtx context.Context, job DaemonJob) {
+	last, hasRun := d.lastRun(job.Name)
+
+	for {
+		now := d.clock.Now()
+		next := job.Schedule.Next(now)
+
+		// Runs missed while the daemon was down, or while a previous run was still going, are
+		// caught up with a single run.
+		if hasRun {
+			if missed := job.Schedule.Next(last); !missed.IsZero() && !missed.After(now) {
+				slog.Info("catching up on missed backup", "job", job.Name, "missed", missed)
+				next = now
+			}
+		}
+
+		if next.IsZero() {
+			slog.Warn("schedule never matches, job will not run", "job", job.Name)
+			return
+		}
+
+		wait := next.Sub(now)
+		if d.options.Jitter > 0 {
+			wait += time.Duration(d.jitter(int64(d.options.Jitter)))
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.clock.After(wait):
+		}
+
+		at := d.clock.Now()
+		last, hasRun = at, true
+
+		slog.Info("starting backup", "job", job.Name)
+		if err := job.Run(runCtx, at); err != nil {
+			slog.Error("backup failed", "job", job.Name, "error", err)
+			continue
+		}
+		slog.Info("finished backup", "job", job.Name)
+
+		if err := d.setLastRun(job.Name, at); err != nil {
+			slog.Warn("could not save daemon state", "error", err)
+		}
+	}
+}
+
+func (d *Daemon) lastRun(name string) (time.Time, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	last, ok := d.state.LastRuns[name]
+	return last, ok
+}
+
+func (d *Daemon) setLastRun(name string, at time.Time) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.state.LastRuns[name] = at
+
+	if d.options.StatePath == "" {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(d.state, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a crash never leaves a truncated state file.
+	tmp, err := os.CreateTemp(filepath.Dir(d.options.StatePath), ".marmalade-state-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp.Name(), d.options.StatePath)
+}
@@ -0,0 +1,327 @@
+package marmalade
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+)
+
+type fakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []fakeWaiter
+}
+
+type fakeWaiter struct {
+	at time.Time
+	ch chan time.Time
+}
+
+func newFakeClock(now time.Time) *fakeClock {
+	return &fakeClock{now: now}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, fakeWaiter{at: c.now.Add(d), ch: ch})
+	return ch
+}
+
+// Advance moves the clock forward, firing any timers that are due.
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.now = c.now.Add(d)
+	waiting := c.waiters[:0]
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			waiting = append(waiting, w)
+		} else {
+			w.ch <- c.now
+		}
+	}
+	c.waiters = waiting
+}
+
+// WaitForTimers blocks until n timers are waiting on the clock.
+func (c *fakeClock) WaitForTimers(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		count := len(c.waiters)
+		c.mu.Unlock()
+		if count >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("timed out waiting for %d timers", n)
+}
+
+// startDaemon runs a daemon until the test ends. The returned channel is closed once Run returns.
+func startDaemon(t *testing.T, jobs []DaemonJob, options DaemonOptions) (context.CancelFunc, <-chan struct{}) {
+	daemon, err := NewDaemon(jobs, options)
+	assert.NoErr(t, err)
+
+	return runDaemon(t, daemon)
+}
+
+func runDaemon(t *testing.T, daemon *Daemon) (context.CancelFunc, <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	var runErr error
+	go func() {
+		runErr = daemon.Run(ctx)
+		close(done)
+	}()
+
+	t.Cleanup(func() {
+		cancel()
+		<-done
+		assert.NoErr(t, runErr)
+	})
+
+	return cancel, done
+}
+
+func receive[T any](t *testing.T, ch <-chan T) T {
+	t.Helper()
+	select {
+	case v := <-ch:
+		return v
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out")
+		panic("unreachable")
+	}
+}
+
+func mustParseCron(t *testing.T, expression string) CronSchedule {
+	schedule, err := ParseCron(expression)
+	assert.NoErr(t, err)
+	return schedule
+}
+
+func TestDaemonRunsOnSchedule(t *testing.T) {
+	clock := newFakeClock(time.Date(2025, time.March, 5, 2, 0, 0, 0, time.UTC))
+	runs := make(chan time.Time, 10)
+
+	startDaemon(t, []DaemonJob{{
+		Name:     "db",
+		Schedule: mustParseCron(t, "0 3 * * *"),
+		Run: func(ctx context.Context, at time.Time) error {
+			runs <- at
+			return nil
+		},
+	}}, DaemonOptions{Clock: clock})
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(59 * time.Minute)
+	assert.Equal(t, 0, len(runs))
+
+	clock.Advance(time.Minute)
+	assert.Equal(t, time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC), receive(t, runs))
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(24 * time.Hour)
+	assert.Equal(t, time.Date(2025, time.March, 6, 3, 0, 0, 0, time.UTC), receive(t, runs))
+}
+
+func TestDaemonAddsJitter(t *testing.T) {
+	clock := newFakeClock(time.Date(2025, time.March, 5, 2, 0, 0, 0, time.UTC))
+	runs := make(chan time.Time, 10)
+
+	daemon, err := NewDaemon([]DaemonJob{{
+		Name:     "db",
+		Schedule: mustParseCron(t, "0 3 * * *"),
+		Run: func(ctx context.Context, at time.Time) error {
+			runs <- at
+			return nil
+		},
+	}}, DaemonOptions{Clock: clock, Jitter: 10 * time.Minute})
+	assert.NoErr(t, err)
+	daemon.jitter = func(n int64) int64 {
+		assert.Equal(t, int64(10*time.Minute), n)
+		return int64(7 * time.Minute)
+	}
+
+	runDaemon(t, daemon)
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Hour)
+	assert.Equal(t, 0, len(runs))
+
+	clock.Advance(7 * time.Minute)
+	assert.Equal(t, time.Date(2025, time.March, 5, 3, 7, 0, 0, time.UTC), receive(t, runs))
+}
+
+func TestDaemonCatchesUpMissedRuns(t *testing.T) {
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	state := daemonState{LastRuns: map[string]time.Time{
+		"db":     time.Date(2025, time.March, 2, 3, 0, 0, 0, time.UTC),
+		"photos": time.Date(2025, time.March, 5, 1, 0, 0, 0, time.UTC),
+	}}
+	data, err := json.Marshal(state)
+	assert.NoErr(t, err)
+	err = os.WriteFile(statePath, data, 0600)
+	assert.NoErr(t, err)
+
+	now := time.Date(2025, time.March, 5, 2, 0, 0, 0, time.UTC)
+	clock := newFakeClock(now)
+	runs := make(chan string, 10)
+
+	run := func(name string) func(context.Context, time.Time) error {
+		return func(ctx context.Context, at time.Time) error {
+			runs <- name
+			return nil
+		}
+	}
+
+	startDaemon(t, []DaemonJob{
+		{Name: "db", Schedule: mustParseCron(t, "0 3 * * *"), Run: run("db")},
+		{Name: "photos", Schedule: mustParseCron(t, "0 1 * * *"), Run: run("photos")},
+	}, DaemonOptions{Clock: clock, StatePath: statePath})
+
+	// several missed runs of db are caught up with a single run, photos has not missed any
+	assert.Equal(t, "db", receive(t, runs))
+	clock.WaitForTimers(t, 2)
+	assert.Equal(t, 0, len(runs))
+
+	// the catch up run is recorded
+	data, err = os.ReadFile(statePath)
+	assert.NoErr(t, err)
+	err = json.Unmarshal(data, &state)
+	assert.NoErr(t, err)
+	assert.Equal(t, now, state.LastRuns["db"])
+}
+
+func TestDaemonNeverOverlapsRuns(t *testing.T) {
+	clock := newFakeClock(time.Date(2025, time.March, 5, 2, 59, 0, 0, time.UTC))
+	started := make(chan time.Time, 10)
+	release := make(chan struct{})
+
+	startDaemon(t, []DaemonJob{{
+		Name:     "db",
+		Schedule: mustParseCron(t, "* * * * *"),
+		Run: func(ctx context.Context, at time.Time) error {
+			started <- at
+			<-release
+			return nil
+		},
+	}}, DaemonOptions{Clock: clock})
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	receive(t, started)
+
+	// the run is still going, later slots do not start another
+	clock.Advance(5 * time.Minute)
+	time.Sleep(10 * time.Millisecond)
+	assert.Equal(t, 0, len(started))
+
+	// once finished, the missed slots are caught up with one run
+	release <- struct{}{}
+	assert.Equal(t, time.Date(2025, time.March, 5, 3, 5, 0, 0, time.UTC), receive(t, started))
+	close(release)
+}
+
+func TestDaemonFinishesInFlightRunOnShutdown(t *testing.T) {
+	clock := newFakeClock(time.Date(2025, time.March, 5, 2, 59, 0, 0, time.UTC))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := make(chan error, 1)
+
+	cancel, done := startDaemon(t, []DaemonJob{{
+		Name:     "db",
+		Schedule: mustParseCron(t, "0 3 * * *"),
+		Run: func(ctx context.Context, at time.Time) error {
+			close(started)
+			select {
+			case <-release:
+				finished <- nil
+			case <-ctx.Done():
+				finished <- ctx.Err()
+			}
+			return nil
+		},
+	}}, DaemonOptions{Clock: clock, ShutdownTimeout: time.Minute})
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	receive(t, started)
+
+	cancel()
+	clock.WaitForTimers(t, 1)
+	select {
+	case <-done:
+		t.Fatal("daemon stopped before the run finished")
+	default:
+	}
+
+	close(release)
+	assert.NoErr(t, receive(t, finished))
+	receive(t, done)
+}
+
+func TestDaemonAbortsInFlightRunAfterTimeout(t *testing.T) {
+	clock := newFakeClock(time.Date(2025, time.March, 5, 2, 59, 0, 0, time.UTC))
+	started := make(chan struct{})
+	finished := make(chan error, 1)
+
+	cancel, done := startDaemon(t, []DaemonJob{{
+		Name:     "db",
+		Schedule: mustParseCron(t, "0 3 * * *"),
+		Run: func(ctx context.Context, at time.Time) error {
+			close(started)
+			<-ctx.Done()
+			finished <- ctx.Err()
+			return ctx.Err()
+		},
+	}}, DaemonOptions{Clock: clock, ShutdownTimeout: time.Minute})
+
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Minute)
+	receive(t, started)
+
+	cancel()
+	clock.WaitForTimers(t, 1)
+	clock.Advance(time.Minute)
+
+	assert.ErrContains(t, receive(t, finished), "context canceled")
+	receive(t, done)
+}
+
+func TestNewDaemonErrors(t *testing.T) {
+	_, err := NewDaemon(nil, DaemonOptions{})
+	assert.ErrContains(t, err, "no jobs to run")
+
+	job := DaemonJob{Name: "db"}
+	_, err = NewDaemon([]DaemonJob{job, job}, DaemonOptions{})
+	assert.ErrContains(t, err, "duplicate job: db")
+
+	statePath := filepath.Join(t.TempDir(), "state.json")
+	err = os.WriteFile(statePath, []byte("{"), 0600)
+	assert.NoErr(t, err)
+	_, err = NewDaemon([]DaemonJob{job}, DaemonOptions{StatePath: statePath})
+	assert.ErrContains(t, err, "parse daemon state")
+}
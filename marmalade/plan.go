@@ -0,0 +1,460 @@
+package marmalade
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+// Plan describes every change a backup run will make to the bucket. Building a plan only reads
+// from the bucket, so it can be inspected before being executed.
+type Plan struct {
+	At         time.Time         `json:"at"`
+	Upload     *PlannedUpload    `json:"upload,omitempty"`
+	SkipReason string            `json:"skipReason,omitempty"`
+	Copies     []PlannedCopy     `json:"copies"`
+	Holds      []PlannedHold     `json:"holds"`
+	Locks      []PlannedLock     `json:"locks"`
+	Deletions  []PlannedDeletion `json:"deletions"`
+
+	// Held are versions that are no longer retained, but cannot be deleted until their legal hold
+	// is removed by hand.
+	Held []PlannedDeletion `json:"held"`
+
+	options BackupOptions
+}
+
+type PlannedUpload struct {
+	Key          string            `json:"key"`
+	HashKey      string            `json:"hashKey"`
+	Period       string            `json:"period"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Retention    *PlannedRetention `json:"retention,omitempty"`
+
+	// Supersedes are the versions of Key that the upload replaces, recorded in its metadata so
+	// they can be deleted on a later run if they are still locked.
+	Supersedes []string `json:"supersedes,omitempty"`
+}
+
+// PlannedCopy moves a retained backup into the storage class of its period by copying it over
+// itself. The version that was copied is superseded and deleted.
+type PlannedCopy struct {
+	Key          string            `json:"key"`
+	VersionID    string            `json:"versionId"`
+	Period       string            `json:"period"`
+	StorageClass string            `json:"storageClass"`
+	Retention    *PlannedRetention `json:"retention,omitempty"`
+
+	// Supersedes are the versions of Key that the copy replaces, recorded in its metadata like
+	// those of an upload. They are the copied version and any left over from earlier copies.
+	Supersedes []string `json:"supersedes"`
+
+	// metadata is the user metadata of the copied version, which the copy keeps.
+	metadata map[string]string
+}
+
+// supersedesMetadata lists the versions a backup supersedes, separated by commas. Only versions
+// listed in the metadata of the latest version are ever deleted as superseded, so versions left
+// by anything other than marmalade are never touched.
+const supersedesMetadata = "marmalade-supersedes"
+
+// withSupersedes returns metadata with versionIDs recorded as superseded.
+func withSupersedes(metadata map[string]string, versionIDs []string) map[string]string {
+	if len(versionIDs) == 0 {
+		return metadata
+	}
+	metadata = maps.Clone(metadata)
+	if metadata == nil {
+		metadata = map[string]string{}
+	}
+	metadata[supersedesMetadata] = strings.Join(versionIDs, ",")
+	return metadata
+}
+
+// PlannedHold places a legal hold on a retained backup or hash file.
+type PlannedHold struct {
+	Key    string `json:"key"`
+	Period string `json:"period"`
+}
+
+type PlannedLock struct {
+	Key       string           `json:"key"`
+	Period    string           `json:"period"`
+	Retention PlannedRetention `json:"retention"`
+}
+
+type PlannedRetention struct {
+	Mode  string    `json:"mode"`
+	Until time.Time `json:"until"`
+}
+
+type PlannedDeletion struct {
+	Key       string `json:"key"`
+	VersionID string `json:"versionId"`
+
+	// Superseded is set for old versions of retained backups that were moved to another storage
+	// class, or uploaded again because their hash file was missing. They may still be locked, in
+	// which case they are deleted on a later run, as they are recorded in the metadata of the
+	// version that superseded them.
+	Superseded bool `json:"superseded,omitempty"`
+}
+
+// PlanBackup decides what a backup of fileName taken at the given time will do. No changes are
+// made to the bucket.
+func PlanBackup(client *s3.Client, schedule RetentionSchedule, at time.Time, fileName string, options BackupOptions) (*Plan, error) {
+	return PlanBackupContext(context.Background(), client, schedule, at, fileName, options)
+}
+
+func PlanBackupContext(ctx context.Context, client *s3.Client, schedule RetentionSchedule, at time.Time, fileName string, options BackupOptions) (*Plan, error) {
+	if err := ValidatePrefix(options.Prefix); err != nil {
+		return nil, err
+	}
+
+	pathParts := strings.Split(path.Base(fileName), ".")
+	backupFileName := fmt.Sprintf("%s.%s", at.Format(schedule.backupTimeFormat()), strings.Join(pathParts[1:], "."))
+
+	// Get all objectVersions out of the bucket and check retention.
+	objectVersions := []s3.ListedVersion{}
+	for object, err := range client.ListAllObjectVersionsContext(ctx, options.Prefix) {
+		if err != nil {
+			return nil, fmt.Errorf("list object versions: %w", err)
+		}
+		if strings.Contains(strings.TrimPrefix(object.Key, options.Prefix), "/") {
+			continue
+		}
+		objectVersions = append(objectVersions, object)
+	}
+
+	hashes := map[string]bool{}
+	oldVersions := map[string][]string{}
+	for _, object := range objectVersions {
+		if object.IsLatest && !object.DeleteMarker && strings.HasSuffix(object.Key, ".sha256") {
+			hashes[strings.TrimSuffix(object.Key, ".sha256")] = true
+		}
+		if !object.IsLatest && !object.DeleteMarker {
+			oldVersions[object.Key] = append(oldVersions[object.Key], object.VersionId)
+		}
+	}
+
+	// latestMetadata returns the user metadata of the latest version of key.
+	metadataCache := map[string]map[string]string{}
+	latestMetadata := func(key string) (map[string]string, error) {
+		if metadata, ok := metadataCache[key]; ok {
+			return metadata, nil
+		}
+		head, err := client.HeadObjectContext(ctx, key)
+		if err != nil {
+			return nil, fmt.Errorf("head %s: %w", key, err)
+		}
+		metadataCache[key] = head.Metadata
+		return head.Metadata, nil
+	}
+
+	// superseded returns the old versions of key recorded in the metadata of its latest version.
+	superseded := func(key string) ([]string, error) {
+		versionIDs := []string{}
+		if len(oldVersions[key]) == 0 {
+			return versionIDs, nil
+		}
+		metadata, err := latestMetadata(key)
+		if err != nil {
+			return nil, err
+		}
+		for _, versionID := range strings.Split(metadata[supersedesMetadata], ",") {
+			if slices.Contains(oldVersions[key], versionID) {
+				versionIDs = append(versionIDs, versionID)
+			}
+		}
+		return versionIDs, nil
+	}
+
+	// A backup without its hash file was cut short between its two uploads and cannot be
+	// restored. It does not count as uploaded, so it is uploaded again or deleted.
+	backups := []string{}
+	incomplete := map[string]s3.ListedVersion{}
+	for _, object := range objectVersions {
+		if !object.IsLatest || object.DeleteMarker { // Only consider latest version of files
+			continue
+		}
+
+		// Remove sha256 hash files
+		if strings.HasSuffix(object.Key, ".sha256") {
+			continue
+		}
+
+		file := strings.TrimPrefix(object.Key, options.Prefix)
+		if hashes[object.Key] {
+			backups = append(backups, file)
+		} else {
+			incomplete[file] = object
+		}
+	}
+
+	oldRetained := calculateRetention(backups, schedule)
+	retained := calculateRetention(append(slices.Clone(backups), backupFileName), schedule)
+
+	plan := &Plan{
+		At:        at,
+		Copies:    []PlannedCopy{},
+		Holds:     []PlannedHold{},
+		Locks:     []PlannedLock{},
+		Deletions: []PlannedDeletion{},
+		Held:      []PlannedDeletion{},
+		options:   options,
+	}
+
+	periods := []struct {
+		name         string
+		lock         lockSchedule
+		storageClass string
+		retained     []string
+		old          []string
+	}{
+		{"hourly", schedule.hourlyLock, schedule.hourlyStorageClass, retained.hourly, oldRetained.hourly},
+		{"daily", schedule.dailyLock, schedule.dailyStorageClass, retained.daily, oldRetained.daily},
+		{"weekly", schedule.weeklyLock, schedule.weeklyStorageClass, retained.weekly, oldRetained.weekly},
+		{"monthly", schedule.monthlyLock, schedule.monthlyStorageClass, retained.monthly, oldRetained.monthly},
+		{"yearly", schedule.yearlyLock, schedule.yearlyStorageClass, retained.yearly, oldRetained.yearly},
+	}
+
+	// Upload file if it will be retained AND it has not been uploaded already.
+	if slices.Contains(backups, backupFileName) {
+		plan.SkipReason = fmt.Sprintf("%s has already been uploaded", options.Prefix+backupFileName)
+	} else if !slices.Contains(retained.All(), backupFileName) {
+		plan.SkipReason = fmt.Sprintf("%s will not be retained", options.Prefix+backupFileName)
+	} else {
+		key := options.Prefix + backupFileName
+		plan.Upload = &PlannedUpload{Key: key, HashKey: key + ".sha256"}
+		if object, ok := incomplete[backupFileName]; ok {
+			plan.Upload.Supersedes = []string{object.VersionId}
+		}
+
+		for _, period := range periods {
+			if !slices.Contains(period.retained, backupFileName) {
+				continue
+			}
+
+			plan.Upload.Period = period.name
+			plan.Upload.StorageClass = period.storageClass
+			if period.lock.lockHours > 0 {
+				retention := period.lock.retention(at)
+				plan.Upload.Retention = &retention
+			}
+		}
+	}
+
+	// Move backups into the storage class of the period that retains them, which is the shortest
+	// period that keeps them, so a backup is only moved on once it ages out of that period. Only
+	// the latest version is moved, hash files stay in the default class.
+	for _, object := range objectVersions {
+		file := strings.TrimPrefix(object.Key, options.Prefix)
+		if !object.IsLatest || object.DeleteMarker || strings.HasSuffix(file, ".sha256") || !hashes[object.Key] {
+			continue
+		}
+
+		move := PlannedCopy{Key: object.Key, VersionID: object.VersionId}
+		for _, period := range periods {
+			if !slices.Contains(period.retained, file) {
+				continue
+			}
+
+			move.Period = period.name
+			move.StorageClass = period.storageClass
+			if period.lock.lockHours > 0 {
+				retention := period.lock.retention(at)
+				move.Retention = &retention
+			}
+		}
+
+		currentClass := object.StorageClass
+		if currentClass == "" {
+			currentClass = "STANDARD"
+		}
+
+		// Archived objects cannot be copied until they are restored, and objects over the copy
+		// limit would need a multipart copy, so both are left in place.
+		if move.StorageClass == "" || move.StorageClass == currentClass ||
+			s3.IsArchivedStorageClass(currentClass) || object.Size > s3.MaxCopyObjectSize {
+			continue
+		}
+
+		// A held version cannot be deleted once it is copied, so it would be stored twice.
+		if schedule.hasLegalHolds() {
+			held, err := client.GetObjectLegalHoldContext(ctx, object.Key, object.VersionId)
+			if err != nil {
+				return nil, fmt.Errorf("get legal hold of %s::%s: %w", object.Key, object.VersionId, err)
+			}
+			if held {
+				continue
+			}
+		}
+
+		// The copy replaces the metadata of the copied version, which is the latest, so the
+		// versions it supersedes are carried over along with the rest of it.
+		metadata, err := latestMetadata(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		versionIDs, err := superseded(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		move.Supersedes = append(versionIDs, object.VersionId)
+		move.metadata = metadata
+
+		plan.Copies = append(plan.Copies, move)
+	}
+
+	// Place legal holds. They are placed on every run, so a hold that failed or was removed by
+	// mistake is placed again while the backup is retained.
+	for _, period := range periods {
+		if !period.lock.legalHold {
+			continue
+		}
+
+		for _, file := range period.retained {
+			key := options.Prefix + file
+			plan.Holds = append(plan.Holds,
+				PlannedHold{Key: key, Period: period.name},
+				PlannedHold{Key: key + ".sha256", Period: period.name},
+			)
+		}
+	}
+
+	// Update object lock retention.
+	for _, period := range periods {
+		if period.lock.lockHours <= 0 {
+			continue
+		}
+
+		retention := period.lock.retention(at)
+
+		for _, file := range period.retained {
+			if file == backupFileName {
+				continue
+			}
+
+			if period.lock.lockType == lockTypeRolling || !slices.Contains(period.old, file) {
+				key := options.Prefix + file
+				plan.Locks = append(plan.Locks,
+					PlannedLock{Key: key, Period: period.name, Retention: retention},
+					PlannedLock{Key: key + ".sha256", Period: period.name, Retention: retention},
+				)
+			}
+		}
+	}
+
+	// Delete non-retained files.
+	allRetained := retained.All()
+
+	for _, object := range objectVersions {
+		key := strings.TrimSuffix(strings.TrimPrefix(object.Key, options.Prefix), ".sha256") // remove hash suffix if it exists
+
+		if !slices.Contains(allRetained, key) {
+			plan.Deletions = append(plan.Deletions, PlannedDeletion{Key: object.Key, VersionID: object.VersionId})
+			continue
+		}
+		if object.IsLatest || object.DeleteMarker {
+			continue
+		}
+
+		// Left behind by a copy or upload on an earlier run, because it was still locked.
+		versionIDs, err := superseded(object.Key)
+		if err != nil {
+			return nil, err
+		}
+		if slices.Contains(versionIDs, object.VersionId) {
+			plan.Deletions = append(plan.Deletions, PlannedDeletion{Key: object.Key, VersionID: object.VersionId, Superseded: true})
+		}
+	}
+	for _, move := range plan.Copies {
+		plan.Deletions = append(plan.Deletions, PlannedDeletion{Key: move.Key, VersionID: move.VersionID, Superseded: true})
+	}
+	if object, ok := incomplete[backupFileName]; ok && plan.Upload != nil {
+		plan.Deletions = append(plan.Deletions, PlannedDeletion{Key: object.Key, VersionID: object.VersionId, Superseded: true})
+	}
+
+	// Held versions would fail to delete on every run, so they are left out. Legal holds are only
+	// looked up for schedules that place them.
+	if schedule.hasLegalHolds() {
+		deletions := []PlannedDeletion{}
+		for _, deletion := range plan.Deletions {
+			held, err := client.GetObjectLegalHoldContext(ctx, deletion.Key, deletion.VersionID)
+			if err != nil {
+				return nil, fmt.Errorf("get legal hold of %s::%s: %w", deletion.Key, deletion.VersionID, err)
+			}
+			if held {
+				plan.Held = append(plan.Held, deletion)
+			} else {
+				deletions = append(deletions, deletion)
+			}
+		}
+		plan.Deletions = deletions
+	}
+
+	return plan, nil
+}
+
+func (l lockSchedule) retention(at time.Time) PlannedRetention {
+	mode := l.mode
+	if mode == "" {
+		mode = s3.LockModeCompliance
+	}
+	return PlannedRetention{
+		Mode:  mode,
+		Until: at.Add(time.Hour * time.Duration(l.lockHours)),
+	}
+}
+
+func (p *Plan) String() string {
+	var b strings.Builder
+
+	if p.Upload != nil {
+		fmt.Fprintf(&b, "upload %s and %s (%s", p.Upload.Key, p.Upload.HashKey, p.Upload.Period)
+		if p.Upload.StorageClass != "" {
+			fmt.Fprintf(&b, ", %s", p.Upload.StorageClass)
+		}
+		if p.Upload.Retention != nil {
+			fmt.Fprintf(&b, ", %s lock until %s", p.Upload.Retention.Mode, p.Upload.Retention.Until.Format(time.RFC3339))
+		}
+		b.WriteString(")\n")
+	} else {
+		fmt.Fprintf(&b, "skip upload, %s\n", p.SkipReason)
+	}
+
+	for _, move := range p.Copies {
+		fmt.Fprintf(&b, "move %s::%s to %s (%s", move.Key, move.VersionID, move.StorageClass, move.Period)
+		if move.Retention != nil {
+			fmt.Fprintf(&b, ", %s lock until %s", move.Retention.Mode, move.Retention.Until.Format(time.RFC3339))
+		}
+		b.WriteString(")\n")
+	}
+
+	for _, hold := range p.Holds {
+		fmt.Fprintf(&b, "place legal hold on %s (%s)\n", hold.Key, hold.Period)
+	}
+
+	for _, lock := range p.Locks {
+		fmt.Fprintf(&b, "extend lock for %s (%s, %s lock until %s)\n", lock.Key, lock.Period, lock.Retention.Mode, lock.Retention.Until.Format(time.RFC3339))
+	}
+
+	for _, deletion := range p.Deletions {
+		if deletion.Superseded {
+			fmt.Fprintf(&b, "delete superseded %s::%s\n", deletion.Key, deletion.VersionID)
+		} else {
+			fmt.Fprintf(&b, "delete %s::%s\n", deletion.Key, deletion.VersionID)
+		}
+	}
+
+	for _, held := range p.Held {
+		fmt.Fprintf(&b, "keep %s::%s, under legal hold\n", held.Key, held.VersionID)
+	}
+
+	return b.String()
+}
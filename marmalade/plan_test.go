@@ -0,0 +1,130 @@
+package marmalade
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+)
+
+func TestPlanDoesNotChangeBucket(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 2},
+		monthly: 2, monthlyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 3},
+	}
+	client, fs3, file := setupTest(t)
+
+	// backup March 5 2025
+	mar5 := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar5)
+	_, err := Backup(client, schedule, mar5, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// backup April 6 2025
+	apr6 := time.Date(2025, time.April, 6, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr6)
+	_, err = Backup(client, schedule, apr6, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	err = client.PutObject("randomfile.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// only allow reads while planning
+	fs3.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.Method != http.MethodGet {
+			t.Errorf("unexpected %s request while planning", r.Method)
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		return false
+	})
+
+	// plan April 7 2025
+	apr7 := time.Date(2025, time.April, 7, 3, 0, 0, 0, time.UTC)
+	plan, err := PlanBackup(client, schedule, apr7, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+
+	assert.Equal(t, "2025-04-07.txt", plan.Upload.Key)
+	assert.Equal(t, "2025-04-07.txt.sha256", plan.Upload.HashKey)
+	assert.Equal(t, "daily", plan.Upload.Period)
+	assert.Equal(t, PlannedRetention{Mode: "COMPLIANCE", Until: apr7.Add(time.Hour * 2)}, *plan.Upload.Retention)
+
+	assert.Equal(t, 2, len(plan.Locks))
+	assert.Equal(t, PlannedLock{Key: "2025-03-05.txt", Period: "monthly", Retention: PlannedRetention{Mode: "COMPLIANCE", Until: apr7.Add(time.Hour * 3)}}, plan.Locks[0])
+	assert.Equal(t, PlannedLock{Key: "2025-03-05.txt.sha256", Period: "monthly", Retention: PlannedRetention{Mode: "COMPLIANCE", Until: apr7.Add(time.Hour * 3)}}, plan.Locks[1])
+
+	// April 6 is replaced by April 7 as the newest backup of the month
+	assert.Equal(t, 3, len(plan.Deletions))
+	assert.Equal(t, PlannedDeletion{Key: "2025-04-06.txt", VersionID: "v4"}, plan.Deletions[0])
+	assert.Equal(t, PlannedDeletion{Key: "2025-04-06.txt.sha256", VersionID: "v3"}, plan.Deletions[1])
+	assert.Equal(t, PlannedDeletion{Key: "randomfile.txt", VersionID: "v5"}, plan.Deletions[2])
+
+	// nothing changed
+	assert.Equal(t, 0, len(fs3.GetVersions("2025-04-07.txt")))
+	assert.HasOneVersion(t, fs3.GetVersions("2025-03-05.txt"), apr6.Add(time.Hour*3))
+	assert.Equal(t, 1, len(fs3.GetVersions("2025-04-06.txt")))
+	assert.Equal(t, 1, len(fs3.GetVersions("randomfile.txt")))
+
+	// plan text describes every change
+	assert.Equal(t, `upload 2025-04-07.txt and 2025-04-07.txt.sha256 (daily, COMPLIANCE lock until 2025-04-07T05:00:00Z)
+extend lock for 2025-03-05.txt (monthly, COMPLIANCE lock until 2025-04-07T06:00:00Z)
+extend lock for 2025-03-05.txt.sha256 (monthly, COMPLIANCE lock until 2025-04-07T06:00:00Z)
+delete 2025-04-06.txt::v4
+delete 2025-04-06.txt.sha256::v3
+delete randomfile.txt::v5
+`, plan.String())
+}
+
+func TestPlanSkipsUpload(t *testing.T) {
+	client, _, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	plan, err := PlanBackup(client, RetentionSchedule{}, now, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, nil, plan.Upload)
+	assert.Equal(t, "2025-03-05.txt will not be retained", plan.SkipReason)
+
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	plan, err = PlanBackup(client, schedule, now, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+	assert.Equal(t, nil, plan.Upload)
+	assert.Equal(t, "2025-03-05.txt has already been uploaded", plan.SkipReason)
+	assert.Equal(t, "skip upload, 2025-03-05.txt has already been uploaded\n", plan.String())
+}
+
+func TestPlanMovesPromotedBackups(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyStorageClass: "STANDARD",
+		monthly: 2, monthlyLock: lockSchedule{lockType: lockTypeSimple, lockHours: 5}, monthlyStorageClass: "GLACIER_IR",
+	}
+	client, fs3, file := setupTest(t)
+
+	mar31 := time.Date(2025, time.March, 31, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar31)
+	_, err := Backup(client, schedule, mar31, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	apr1 := time.Date(2025, time.April, 1, 3, 0, 0, 0, time.UTC)
+	plan, err := PlanBackup(client, schedule, apr1, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+
+	assert.Equal(t, "STANDARD", plan.Upload.StorageClass)
+	assert.Equal(t, 1, len(plan.Copies))
+	assert.Equal(t, "2025-03-31.txt", plan.Copies[0].Key)
+	assert.Equal(t, "v2", plan.Copies[0].VersionID)
+	assert.Equal(t, "monthly", plan.Copies[0].Period)
+	assert.Equal(t, "GLACIER_IR", plan.Copies[0].StorageClass)
+	assert.Equal(t, "v2", strings.Join(plan.Copies[0].Supersedes, ","))
+	assert.Equal(t, PlannedRetention{Mode: "COMPLIANCE", Until: apr1.Add(time.Hour * 5)}, *plan.Copies[0].Retention)
+	assert.Equal(t, `upload 2025-04-01.txt and 2025-04-01.txt.sha256 (daily, STANDARD)
+move 2025-03-31.txt::v2 to GLACIER_IR (monthly, COMPLIANCE lock until 2025-04-01T08:00:00Z)
+extend lock for 2025-03-31.txt (monthly, COMPLIANCE lock until 2025-04-01T08:00:00Z)
+extend lock for 2025-03-31.txt.sha256 (monthly, COMPLIANCE lock until 2025-04-01T08:00:00Z)
+delete superseded 2025-03-31.txt::v2
+`, plan.String())
+}
@@ -0,0 +1,94 @@
+package marmalade
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+// Restore downloads the backup stored at key and passes its contents to restore. Once restore
+// returns, the downloaded data is checked against the backup's sha256 file, and against the
+// checksum S3 stored when it was uploaded. Any data written by restore should be discarded if an
+// error is returned. Backups in archive storage classes must be restored with Thaw first.
+func Restore(client *s3.Client, key string, restore func(io.Reader) error) error {
+	return RestoreContext(context.Background(), client, key, restore)
+}
+
+func RestoreContext(ctx context.Context, client *s3.Client, key string, restore func(io.Reader) error) error {
+	expectedSum, err := getSHA256Sum(ctx, client, key+".sha256")
+	if err != nil {
+		return err
+	}
+
+	slog.Info(fmt.Sprintf("Downloading %s", key))
+
+	object, err := client.GetObjectContext(ctx, key)
+	if err != nil {
+		if s3.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+			return fmt.Errorf("backup %s not found: %w", key, err)
+		}
+		if s3.IsErrorCode(err, s3.ErrCodeInvalidObjectState) {
+			return fmt.Errorf("backup %s is archived and must be thawed first: %w", key, err)
+		}
+		return fmt.Errorf("get object: %w", err)
+	}
+	defer func() { _ = object.Body.Close() }()
+
+	hash := sha256.New()
+	reader := io.TeeReader(object.Body, hash)
+
+	if err := restore(reader); err != nil {
+		return err
+	}
+
+	// Make sure the entire object has been hashed, even if restore did not consume it all.
+	if _, err := io.Copy(io.Discard, reader); err != nil {
+		return fmt.Errorf("read object: %w", err)
+	}
+
+	actualSum := hex.EncodeToString(hash.Sum(nil))
+	if actualSum != expectedSum {
+		return fmt.Errorf("sha256 mismatch for %s: expected %s, got %s", key, expectedSum, actualSum)
+	}
+
+	return nil
+}
+
+// Verify downloads the backup stored at key and checks it the same way Restore does, against the
+// backup's sha256 file and the checksum S3 stored, without decrypting or keeping it. Backups in
+// archive storage classes must be restored with Thaw first.
+func Verify(client *s3.Client, key string) error {
+	return VerifyContext(context.Background(), client, key)
+}
+
+func VerifyContext(ctx context.Context, client *s3.Client, key string) error {
+	return RestoreContext(ctx, client, key, func(io.Reader) error { return nil })
+}
+
+func getSHA256Sum(ctx context.Context, client *s3.Client, key string) (string, error) {
+	object, err := client.GetObjectContext(ctx, key)
+	if err != nil {
+		if s3.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+			return "", fmt.Errorf("backup hash %s not found: %w", key, err)
+		}
+		if s3.IsErrorCode(err, s3.ErrCodeInvalidObjectState) {
+			return "", fmt.Errorf("backup hash %s is archived and must be thawed first: %w", key, err)
+		}
+		return "", fmt.Errorf("get object hash: %w", err)
+	}
+	defer func() { _ = object.Body.Close() }()
+
+	// A hex encoded sha256 is 64 bytes, anything much larger is not a hash file.
+	data, err := io.ReadAll(io.LimitReader(object.Body, 1024))
+	if err != nil {
+		return "", fmt.Errorf("read object hash: %w", err)
+	}
+
+	return strings.TrimSpace(string(data)), nil
+}
@@ -0,0 +1,97 @@
+package marmalade
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestCanRestore(t *testing.T) {
+	client, _, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	err := os.WriteFile(file, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	var restored bytes.Buffer
+	err = Restore(client, "2025-03-05.txt", func(r io.Reader) error {
+		_, err := io.Copy(&restored, r)
+		return err
+	})
+	assert.NoErr(t, err)
+	assert.Equal(t, "abc", restored.String())
+}
+
+func TestRestoreChecksHash(t *testing.T) {
+	client, _, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	err := os.WriteFile(file, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// corrupt the backup
+	err = client.PutObject("2025-03-05.txt", bytes.NewReader([]byte("abd")), 3, nil)
+	assert.NoErr(t, err)
+
+	err = Restore(client, "2025-03-05.txt", func(r io.Reader) error { return nil })
+	assert.ErrContains(t, err, "sha256 mismatch")
+}
+
+func TestRestoreChecksStoredChecksum(t *testing.T) {
+	client, sv, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	err := os.WriteFile(file, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// the data rots in storage, so it no longer matches the checksum S3 has for it
+	sv.GetVersions("2025-03-05.txt")[0].Content = []byte("abd")
+
+	err = Restore(client, "2025-03-05.txt", func(r io.Reader) error { return nil })
+	assert.ErrIs(t, err, s3.ErrChecksumMismatch)
+}
+
+func TestRestoreMissingBackup(t *testing.T) {
+	client, _, _ := setupTest(t)
+
+	err := Restore(client, "2025-03-05.txt", func(r io.Reader) error { return nil })
+	assert.ErrContains(t, err, "backup hash 2025-03-05.txt.sha256 not found")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchKey))
+}
+
+func TestVerify(t *testing.T) {
+	client, sv, _ := setupTestWithConfig(t, s3.Config{MultipartPartSize: 4, AllowSmallParts: true})
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	plan, err := PlanBackup(client, schedule, now, "data.txt", BackupOptions{})
+	assert.NoErr(t, err)
+	_, err = plan.ExecuteStream(client, bytes.NewReader([]byte("abcdefghij")))
+	assert.NoErr(t, err)
+
+	err = Verify(client, "2025-03-05.txt")
+	assert.NoErr(t, err)
+
+	// the multipart upload rots in its second part
+	sv.GetVersions("2025-03-05.txt")[0].Content = []byte("abcdefgxij")
+
+	err = Verify(client, "2025-03-05.txt")
+	assert.ErrIs(t, err, s3.ErrChecksumMismatch)
+	assert.ErrContains(t, err, "part 2 of 2025-03-05.txt")
+
+	err = Verify(client, "2025-03-06.txt")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchKey))
+}
@@ -0,0 +1,113 @@
+package marmalade
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ErrPartialFailure is returned when a backup ran to completion but some objects could not be
+// copied, held, locked or deleted. The failures are listed in BackupResult.Failures.
+var ErrPartialFailure = errors.New("backup partially failed")
+
+// BackupResult reports what a backup run changed in the bucket. It is returned alongside any
+// error and then covers the changes made before the run stopped.
+type BackupResult struct {
+	At         time.Time         `json:"at"`
+	Upload     *UploadResult     `json:"upload,omitempty"`
+	SkipReason string            `json:"skipReason,omitempty"`
+	Copies     []PlannedCopy     `json:"copies"`
+	Holds      []PlannedHold     `json:"holds"`
+	Locks      []PlannedLock     `json:"locks"`
+	Deletions  []PlannedDeletion `json:"deletions"`
+	Failures   []ObjectFailure   `json:"failures"`
+}
+
+type UploadResult struct {
+	Key          string            `json:"key"`
+	HashKey      string            `json:"hashKey"`
+	Period       string            `json:"period"`
+	StorageClass string            `json:"storageClass,omitempty"`
+	Retention    *PlannedRetention `json:"retention,omitempty"`
+	Size         int64             `json:"size"`
+	SHA256       string            `json:"sha256"`
+}
+
+// ObjectFailure is a copy, hold, lock or delete of a single object that did not succeed.
+type ObjectFailure struct {
+	Operation string `json:"operation"`
+	Key       string `json:"key"`
+	VersionID string `json:"versionId,omitempty"`
+	Message   string `json:"message"`
+}
+
+const (
+	operationCopy   = "copy"
+	operationHold   = "hold"
+	operationLock   = "lock"
+	operationDelete = "delete"
+)
+
+func newBackupResult(p *Plan) *BackupResult {
+	return &BackupResult{
+		At:         p.At,
+		SkipReason: p.SkipReason,
+		Copies:     []PlannedCopy{},
+		Holds:      []PlannedHold{},
+		Locks:      []PlannedLock{},
+		Deletions:  []PlannedDeletion{},
+		Failures:   []ObjectFailure{},
+	}
+}
+
+func (r *BackupResult) String() string {
+	var b strings.Builder
+
+	if r.Upload != nil {
+		fmt.Fprintf(&b, "uploaded %s (%d bytes, sha256 %s) and %s (%s", r.Upload.Key, r.Upload.Size, r.Upload.SHA256, r.Upload.HashKey, r.Upload.Period)
+		if r.Upload.StorageClass != "" {
+			fmt.Fprintf(&b, ", %s", r.Upload.StorageClass)
+		}
+		if r.Upload.Retention != nil {
+			fmt.Fprintf(&b, ", %s lock until %s", r.Upload.Retention.Mode, r.Upload.Retention.Until.Format(time.RFC3339))
+		}
+		b.WriteString(")\n")
+	} else if r.SkipReason != "" {
+		fmt.Fprintf(&b, "skipped upload, %s\n", r.SkipReason)
+	}
+
+	for _, move := range r.Copies {
+		fmt.Fprintf(&b, "moved %s::%s to %s (%s", move.Key, move.VersionID, move.StorageClass, move.Period)
+		if move.Retention != nil {
+			fmt.Fprintf(&b, ", %s lock until %s", move.Retention.Mode, move.Retention.Until.Format(time.RFC3339))
+		}
+		b.WriteString(")\n")
+	}
+
+	for _, hold := range r.Holds {
+		fmt.Fprintf(&b, "placed legal hold on %s (%s)\n", hold.Key, hold.Period)
+	}
+
+	for _, lock := range r.Locks {
+		fmt.Fprintf(&b, "extended lock for %s (%s, %s lock until %s)\n", lock.Key, lock.Period, lock.Retention.Mode, lock.Retention.Until.Format(time.RFC3339))
+	}
+
+	for _, deletion := range r.Deletions {
+		if deletion.Superseded {
+			fmt.Fprintf(&b, "deleted superseded %s::%s\n", deletion.Key, deletion.VersionID)
+		} else {
+			fmt.Fprintf(&b, "deleted %s::%s\n", deletion.Key, deletion.VersionID)
+		}
+	}
+
+	for _, failure := range r.Failures {
+		name := failure.Key
+		if failure.VersionID != "" {
+			name += "::" + failure.VersionID
+		}
+		fmt.Fprintf(&b, "failed to %s %s: %s\n", failure.Operation, name, failure.Message)
+	}
+
+	return b.String()
+}
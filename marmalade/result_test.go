@@ -0,0 +1,149 @@
+package marmalade
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestBackupResult(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 2},
+		monthly: 2, monthlyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 3},
+	}
+	client, fs3, file := setupTest(t)
+
+	err := os.WriteFile(file, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+	hash := sha256.Sum256([]byte("abc"))
+
+	mar5 := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar5)
+	result, err := Backup(client, schedule, mar5, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	assert.Equal(t, mar5, result.At)
+	assert.Equal(t, UploadResult{
+		Key:       "2025-03-05.txt",
+		HashKey:   "2025-03-05.txt.sha256",
+		Period:    "daily",
+		Retention: &PlannedRetention{Mode: "COMPLIANCE", Until: mar5.Add(time.Hour * 2)},
+		Size:      3,
+		SHA256:    hex.EncodeToString(hash[:]),
+	}, *result.Upload)
+
+	err = client.PutObject("randomfile.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// a month later the lock of March 5 is extended and the unknown file is deleted
+	apr6 := time.Date(2025, time.April, 6, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr6)
+	result, err = Backup(client, schedule, apr6, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	assert.Equal(t, "2025-04-06.txt", result.Upload.Key)
+	assert.Equal(t, 2, len(result.Locks))
+	assert.Equal(t, PlannedLock{Key: "2025-03-05.txt", Period: "monthly", Retention: PlannedRetention{Mode: "COMPLIANCE", Until: apr6.Add(time.Hour * 3)}}, result.Locks[0])
+	assert.Equal(t, 1, len(result.Deletions))
+	assert.Equal(t, "randomfile.txt", result.Deletions[0].Key)
+	assert.Equal(t, 0, len(result.Failures))
+
+	assert.Equal(t, strings.Join([]string{
+		"uploaded 2025-04-06.txt (3 bytes, sha256 " + hex.EncodeToString(hash[:]) + ") and 2025-04-06.txt.sha256 (daily, COMPLIANCE lock until 2025-04-06T05:00:00Z)",
+		"extended lock for 2025-03-05.txt (monthly, COMPLIANCE lock until 2025-04-06T06:00:00Z)",
+		"extended lock for 2025-03-05.txt.sha256 (monthly, COMPLIANCE lock until 2025-04-06T06:00:00Z)",
+		"deleted randomfile.txt::" + result.Deletions[0].VersionID,
+		"",
+	}, "\n"), result.String())
+
+	// running again skips the upload
+	result, err = Backup(client, schedule, apr6, file, BackupOptions{})
+	assert.NoErr(t, err)
+	assert.True(t, result.Upload == nil)
+	assert.Equal(t, "2025-04-06.txt has already been uploaded", result.SkipReason)
+}
+
+func TestBackupResultReportsFailures(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 2},
+		monthly: 2, monthlyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 3},
+	}
+	client, fs3, file := setupTest(t)
+
+	mar5 := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar5)
+	_, err := Backup(client, schedule, mar5, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// a locked unknown file cannot be deleted
+	err = client.PutObject("locked.txt", bytes.NewReader([]byte("abc")), 3, &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: mar5.AddDate(1, 0, 0)})
+	assert.NoErr(t, err)
+
+	// the lock of one file cannot be extended
+	fs3.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.URL.Query().Has("retention") && strings.HasSuffix(r.URL.Path, "/2025-03-05.txt") {
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		return false
+	})
+
+	apr6 := time.Date(2025, time.April, 6, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr6)
+	result, err := Backup(client, schedule, apr6, file, BackupOptions{})
+	assert.True(t, errors.Is(err, ErrPartialFailure))
+	assert.ErrContains(t, err, "2 objects could not be updated")
+
+	// everything else still happened
+	assert.Equal(t, "2025-04-06.txt", result.Upload.Key)
+	assert.Equal(t, 1, len(result.Locks))
+	assert.Equal(t, "2025-03-05.txt.sha256", result.Locks[0].Key)
+
+	assert.Equal(t, 2, len(result.Failures))
+	assert.Equal(t, operationLock, result.Failures[0].Operation)
+	assert.Equal(t, "2025-03-05.txt", result.Failures[0].Key)
+	assert.Equal(t, ObjectFailure{Operation: operationDelete, Key: "locked.txt", VersionID: result.Failures[1].VersionID, Message: "Object is locked"}, result.Failures[1])
+	assert.Equal(t, 0, len(result.Deletions))
+	assert.True(t, strings.Contains(result.String(), "failed to delete locked.txt::"))
+}
+
+func TestBackupStopsWhenLockIsDenied(t *testing.T) {
+	schedule := RetentionSchedule{
+		daily: 1, dailyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 2},
+		monthly: 2, monthlyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 3},
+	}
+	client, fs3, file := setupTest(t)
+
+	mar5 := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(mar5)
+	_, err := Backup(client, schedule, mar5, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// access denied applies to every object, so the run stops at the first one
+	fs3.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.URL.Query().Has("retention") {
+			fakes3.WriteError(w, http.StatusForbidden, s3.ErrCodeAccessDenied, "Access Denied")
+			return true
+		}
+		return false
+	})
+
+	apr6 := time.Date(2025, time.April, 6, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(apr6)
+	result, err := Backup(client, schedule, apr6, file, BackupOptions{})
+	assert.ErrContains(t, err, "set retention 2025-03-05.txt")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeAccessDenied))
+	assert.True(t, !errors.Is(err, ErrPartialFailure))
+	assert.Equal(t, 0, len(result.Locks))
+	assert.Equal(t, 0, len(result.Failures))
+}
@@ -1,30 +1,51 @@
 package marmalade
 
 import (
+	"fmt"
 	"slices"
 	"strings"
 	"time"
 )
 
+const (
+	dailyTimeFormat  = "2006-01-02"
+	hourlyTimeFormat = "2006-01-02T15"
+)
+
 type retainedFiles struct {
 	yearly  []string
 	monthly []string
+	weekly  []string
 	daily   []string
+	hourly  []string
 }
 
 func (r retainedFiles) All() []string {
-	all := append(append(r.yearly, r.monthly...), r.daily...)
+	all := slices.Concat(r.yearly, r.monthly, r.weekly, r.daily, r.hourly)
 	slices.Sort(all)
 	slices.Reverse(all)
 	return all
 }
 
+// parseBackupTime reads the time at the start of a backup file name, which is either a date or a
+// date and hour.
+func parseBackupTime(file string) (time.Time, error) {
+	name, _, _ := strings.Cut(file, ".")
+
+	if t, err := time.Parse(hourlyTimeFormat, name); err == nil {
+		return t, nil
+	}
+	return time.Parse(dailyTimeFormat, name)
+}
+
 func calculateRetention(files []string, schedule RetentionSchedule) retainedFiles {
 	sortedFiles := make([]string, len(files))
 	copy(sortedFiles, files)
 
 	type fileBuckets struct {
+		hourly  []string
 		daily   []string
+		weekly  []string
 		monthly []string
 		yearly  []string
 
@@ -46,9 +67,7 @@ func calculateRetention(files []string, schedule RetentionSchedule) retainedFile
 	}
 
 	for _, file := range sortedFiles {
-		splitFile := strings.Split(file, ".")
-		date := splitFile[0]
-		time, err := time.Parse("2006-01-02", date)
+		time, err := parseBackupTime(file)
 
 		if err != nil {
 			// Discard any files with unknown date
@@ -56,12 +75,25 @@ func calculateRetention(files []string, schedule RetentionSchedule) retainedFile
 		}
 
 		// put file into its buckets
-		key := time.Format("2006-01-02")
+		key := time.Format("2006-01-02T15")
+		if !slices.Contains(buckets.hourly, key) {
+			buckets.hourly = append(buckets.hourly, key)
+			buckets.files[key] = file
+		}
+
+		key = time.Format("2006-01-02")
 		if !slices.Contains(buckets.daily, key) {
 			buckets.daily = append(buckets.daily, key)
 			buckets.files[key] = file
 		}
 
+		year, week := time.ISOWeek()
+		key = fmt.Sprintf("%04d-W%02d", year, week)
+		if !slices.Contains(buckets.weekly, key) {
+			buckets.weekly = append(buckets.weekly, key)
+			buckets.files[key] = file
+		}
+
 		key = time.Format("2006-01")
 		if !slices.Contains(buckets.monthly, key) {
 			buckets.monthly = append(buckets.monthly, key)
@@ -76,37 +108,32 @@ func calculateRetention(files []string, schedule RetentionSchedule) retainedFile
 	}
 
 	// Sort every bucket descending so that we can easily retain newest N files.
-	slices.Sort(buckets.daily)
-	slices.Reverse(buckets.daily)
-	slices.Sort(buckets.monthly)
-	slices.Reverse(buckets.monthly)
-	slices.Sort(buckets.yearly)
-	slices.Reverse(buckets.yearly)
-
-	// Retain newest N files, as specified by the schedule.
-	toRetain := map[string]struct{}{}
-	retained := retainedFiles{}
-	for i := 0; i < min(schedule.daily, len(buckets.daily)); i++ {
-		file := buckets.files[buckets.daily[i]]
-		if _, ok := toRetain[file]; !ok {
-			toRetain[file] = struct{}{}
-			retained.daily = append(retained.daily, file)
-		}
-	}
-	for i := 0; i < min(schedule.monthly, len(buckets.monthly)); i++ {
-		file := buckets.files[buckets.monthly[i]]
-		if _, ok := toRetain[file]; !ok {
-			toRetain[file] = struct{}{}
-			retained.monthly = append(retained.monthly, file)
-		}
+	for _, bucket := range [][]string{buckets.hourly, buckets.daily, buckets.weekly, buckets.monthly, buckets.yearly} {
+		slices.Sort(bucket)
+		slices.Reverse(bucket)
 	}
-	for i := 0; i < min(schedule.yearly, len(buckets.yearly)); i++ {
-		file := buckets.files[buckets.yearly[i]]
-		if _, ok := toRetain[file]; !ok {
-			toRetain[file] = struct{}{}
-			retained.yearly = append(retained.yearly, file)
+
+	// Retain newest N files, as specified by the schedule. Each file is only retained by the
+	// shortest period that keeps it.
+	toRetain := map[string]struct{}{}
+	retain := func(count int, bucket []string) []string {
+		var retained []string
+		for i := 0; i < min(count, len(bucket)); i++ {
+			file := buckets.files[bucket[i]]
+			if _, ok := toRetain[file]; !ok {
+				toRetain[file] = struct{}{}
+				retained = append(retained, file)
+			}
 		}
+		return retained
 	}
 
+	retained := retainedFiles{}
+	retained.hourly = retain(schedule.hourly, buckets.hourly)
+	retained.daily = retain(schedule.daily, buckets.daily)
+	retained.weekly = retain(schedule.weekly, buckets.weekly)
+	retained.monthly = retain(schedule.monthly, buckets.monthly)
+	retained.yearly = retain(schedule.yearly, buckets.yearly)
+
 	return retained
 }
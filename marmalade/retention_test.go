@@ -105,6 +105,36 @@ func TestRetention(t *testing.T) {
 				},
 			},
 		},
+
+		{
+			RetentionSchedule{
+				hourly: 2,
+				daily:  2,
+				weekly: 2,
+			},
+			[]string{
+				"2025-03-20T15",
+				"2025-03-20T14",
+				"2025-03-20T13",
+				"2025-03-19T23",
+				"2025-03-19T01",
+				"2025-03-18",
+				"2025-03-16",
+				"2025-03-15",
+			},
+			retainedFiles{
+				hourly: []string{
+					"2025-03-20T15",
+					"2025-03-20T14",
+				},
+				daily: []string{
+					"2025-03-19T23",
+				},
+				weekly: []string{
+					"2025-03-16",
+				},
+			},
+		},
 	}
 
 	for i, tc := range testCases {
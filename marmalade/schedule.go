@@ -9,11 +9,23 @@ import (
 type RetentionSchedule struct {
 	yearly  int
 	monthly int
+	weekly  int
 	daily   int
+	hourly  int
 
 	yearlyLock  lockSchedule
 	monthlyLock lockSchedule
+	weeklyLock  lockSchedule
 	dailyLock   lockSchedule
+	hourlyLock  lockSchedule
+
+	// Storage classes backups are kept in while their longest retention is the period, empty for
+	// the default class of the bucket.
+	yearlyStorageClass  string
+	monthlyStorageClass string
+	weeklyStorageClass  string
+	dailyStorageClass   string
+	hourlyStorageClass  string
 
 	inverted bool
 }
@@ -28,10 +40,17 @@ const (
 type lockSchedule struct {
 	lockType  lockType
 	lockHours int
+
+	// mode is the object lock mode, COMPLIANCE if it is empty.
+	mode string
+
+	// legalHold places a legal hold on retained backups, which keeps them until it is removed by
+	// hand whatever their retention.
+	legalHold bool
 }
 
 func ParseSchedule(scheduleString string) (RetentionSchedule, error) {
-	// "- 7d 12m/2160h 7y/2160h%"
+	// "- 24h 7d/48h,governance 5w 12m/2160h:GLACIER_IR 7y/2160h%,hold:DEEP_ARCHIVE"
 	scheduleString = strings.TrimSpace(scheduleString)
 
 	inverted := false
@@ -50,10 +69,32 @@ func ParseSchedule(scheduleString string) (RetentionSchedule, error) {
 
 	parsedUnits := []string{}
 	for _, period := range periods {
+		toParse, storageClass, hasStorageClass := strings.Cut(period, ":")
+		if hasStorageClass && !isStorageClass(storageClass) {
+			return schedule, fmt.Errorf("period %s has invalid storage class: %s", period, storageClass)
+		}
+
+		toParse, options, _ := strings.Cut(toParse, ",")
+		lock := lockSchedule{}
+		if options != "" {
+			for _, option := range strings.Split(options, ",") {
+				switch option {
+				case "governance", "compliance":
+					if lock.mode != "" {
+						return schedule, fmt.Errorf("period %s has more than one lock mode", period)
+					}
+					lock.mode = strings.ToUpper(option)
+				case "hold":
+					lock.legalHold = true
+				default:
+					return schedule, fmt.Errorf("period %s has unknown option: %s", period, option)
+				}
+			}
+		}
+
 		lockType := lockTypeSimple
-		toParse := period
-		if strings.HasSuffix(period, "%") {
-			toParse = strings.TrimSuffix(period, "%")
+		if strings.HasSuffix(toParse, "%") {
+			toParse = strings.TrimSuffix(toParse, "%")
 			lockType = lockTypeRolling
 		}
 
@@ -69,21 +110,38 @@ func ParseSchedule(scheduleString string) (RetentionSchedule, error) {
 			}
 		}
 
+		if lock.mode != "" && hours == 0 {
+			return schedule, fmt.Errorf("period %s has a lock mode but no lock time", period)
+		}
+		lock.lockType = lockType
+		lock.lockHours = hours
+
 		if slices.Contains(parsedUnits, unit) {
 			return schedule, fmt.Errorf("period %s duplicates unit %s", period, unit)
 		}
 
 		parsedUnits = append(parsedUnits, unit)
 
-		if unit == "d" {
+		if unit == "h" {
+			schedule.hourly = value
+			schedule.hourlyLock = lock
+			schedule.hourlyStorageClass = storageClass
+		} else if unit == "d" {
 			schedule.daily = value
-			schedule.dailyLock = lockSchedule{lockType, hours}
+			schedule.dailyLock = lock
+			schedule.dailyStorageClass = storageClass
+		} else if unit == "w" {
+			schedule.weekly = value
+			schedule.weeklyLock = lock
+			schedule.weeklyStorageClass = storageClass
 		} else if unit == "m" {
 			schedule.monthly = value
-			schedule.monthlyLock = lockSchedule{lockType, hours}
+			schedule.monthlyLock = lock
+			schedule.monthlyStorageClass = storageClass
 		} else if unit == "y" {
 			schedule.yearly = value
-			schedule.yearlyLock = lockSchedule{lockType, hours}
+			schedule.yearlyLock = lock
+			schedule.yearlyStorageClass = storageClass
 		} else {
 			return schedule, fmt.Errorf("unrecognized unit: %s", unit)
 		}
@@ -91,3 +149,31 @@ func ParseSchedule(scheduleString string) (RetentionSchedule, error) {
 
 	return schedule, nil
 }
+
+// isStorageClass reports whether s looks like an S3 storage class such as GLACIER_IR.
+func isStorageClass(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if (r < 'A' || r > 'Z') && (r < '0' || r > '9') && r != '_' {
+			return false
+		}
+	}
+	return true
+}
+
+// hasLegalHolds reports whether any period places legal holds on its backups.
+func (s RetentionSchedule) hasLegalHolds() bool {
+	return s.yearlyLock.legalHold || s.monthlyLock.legalHold || s.weeklyLock.legalHold ||
+		s.dailyLock.legalHold || s.hourlyLock.legalHold
+}
+
+// backupTimeFormat is the layout of the time at the start of backup file names. Hourly schedules
+// need the hour in the name so that several backups a day can be kept.
+func (s RetentionSchedule) backupTimeFormat() string {
+	if s.hourly > 0 {
+		return hourlyTimeFormat
+	}
+	return dailyTimeFormat
+}
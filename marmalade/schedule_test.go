@@ -22,6 +22,22 @@ func TestParseSchedule(t *testing.T) {
 				yearly:  8,
 			},
 		},
+		{
+			input: "24h 7d 5w 12m",
+			expected: RetentionSchedule{
+				hourly:  24,
+				daily:   7,
+				weekly:  5,
+				monthly: 12,
+			},
+		},
+		{
+			input: "5w/336h%",
+			expected: RetentionSchedule{
+				weekly:     5,
+				weeklyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 336},
+			},
+		},
 		{
 			input: "12m",
 			expected: RetentionSchedule{
@@ -39,9 +55,54 @@ func TestParseSchedule(t *testing.T) {
 			input: "12m/216h%",
 			expected: RetentionSchedule{
 				monthly:     12,
-				monthlyLock: lockSchedule{lockTypeRolling, 216},
+				monthlyLock: lockSchedule{lockType: lockTypeRolling, lockHours: 216},
+			},
+		},
+		{
+			input: "7d:STANDARD 12m/2160h:GLACIER_IR 7y/61320h%:DEEP_ARCHIVE",
+			expected: RetentionSchedule{
+				daily:               7,
+				dailyStorageClass:   "STANDARD",
+				monthly:             12,
+				monthlyLock:         lockSchedule{lockType: lockTypeSimple, lockHours: 2160},
+				monthlyStorageClass: "GLACIER_IR",
+				yearly:              7,
+				yearlyLock:          lockSchedule{lockType: lockTypeRolling, lockHours: 61320},
+				yearlyStorageClass:  "DEEP_ARCHIVE",
 			},
 		},
+		{
+			input: "7d/48h,governance 12m/2160h%,compliance 7y,hold:DEEP_ARCHIVE",
+			expected: RetentionSchedule{
+				daily:              7,
+				dailyLock:          lockSchedule{lockType: lockTypeSimple, lockHours: 48, mode: "GOVERNANCE"},
+				monthly:            12,
+				monthlyLock:        lockSchedule{lockType: lockTypeRolling, lockHours: 2160, mode: "COMPLIANCE"},
+				yearly:             7,
+				yearlyLock:         lockSchedule{legalHold: true},
+				yearlyStorageClass: "DEEP_ARCHIVE",
+			},
+		},
+		{
+			input: "7d,governance",
+			error: "period 7d,governance has a lock mode but no lock time",
+		},
+		{
+			input: "7d/48h,governance,compliance",
+			error: "period 7d/48h,governance,compliance has more than one lock mode",
+		},
+		{
+			input: "7d/48h,forever",
+			error: "period 7d/48h,forever has unknown option: forever",
+		},
+		{
+			input: "7d:",
+			error: "period 7d: has invalid storage class: ",
+		},
+		{
+			input: "7d:glacier",
+			error: "period 7d:glacier has invalid storage class: glacier",
+		},
 		{
 			input: "7x",
 			error: "unrecognized unit: x",
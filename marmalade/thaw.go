@@ -0,0 +1,130 @@
+package marmalade
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+const (
+	DefaultThawDays            = 1
+	DefaultThawPollInterval    = time.Minute
+	DefaultThawMaxPollInterval = 30 * time.Minute
+)
+
+// ErrThawing is returned by Thaw with NoWait set when a backup has to be restored from an archive
+// storage class before it can be downloaded.
+var ErrThawing = errors.New("backup is being restored from archive")
+
+type ThawOptions struct {
+	// Days is how long restored copies of archived objects are kept. Defaults to DefaultThawDays.
+	Days int
+
+	// Tier is the retrieval tier of the restore. Defaults to s3.RestoreTierStandard.
+	Tier string
+
+	// NoWait returns ErrThawing once restores are started, instead of waiting for them to finish.
+	NoWait bool
+
+	// PollInterval is the first wait before checking whether restores have finished. It doubles
+	// with each check, up to MaxPollInterval. Defaults to DefaultThawPollInterval and
+	// DefaultThawMaxPollInterval.
+	PollInterval    time.Duration
+	MaxPollInterval time.Duration
+
+	// Clock defaults to the system clock.
+	Clock Clock
+}
+
+func (o ThawOptions) withDefaults() ThawOptions {
+	if o.Days <= 0 {
+		o.Days = DefaultThawDays
+	}
+	if o.Tier == "" {
+		o.Tier = s3.RestoreTierStandard
+	}
+	if o.PollInterval <= 0 {
+		o.PollInterval = DefaultThawPollInterval
+	}
+	if o.MaxPollInterval <= 0 {
+		o.MaxPollInterval = DefaultThawMaxPollInterval
+	}
+	if o.Clock == nil {
+		o.Clock = systemClock{}
+	}
+	return o
+}
+
+// Thaw makes sure the backup stored at key and its sha256 file can be downloaded, restoring them
+// first if they are in an archive storage class such as GLACIER or DEEP_ARCHIVE. Restores take
+// hours, which Thaw waits for unless NoWait is set. Backups that are not archived need nothing.
+func Thaw(client *s3.Client, key string, options ThawOptions) error {
+	return ThawContext(context.Background(), client, key, options)
+}
+
+func ThawContext(ctx context.Context, client *s3.Client, key string, options ThawOptions) error {
+	options = options.withDefaults()
+
+	pending, err := startThaws(ctx, client, []string{key, key + ".sha256"}, options)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 && options.NoWait {
+		return fmt.Errorf("%w: %s", ErrThawing, strings.Join(pending, ", "))
+	}
+
+	delay := options.PollInterval
+	for len(pending) > 0 {
+		slog.Info(fmt.Sprintf("waiting for %s to be restored from archive", strings.Join(pending, ", ")), "delay", delay)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-options.Clock.After(delay):
+		}
+		delay = min(delay*2, options.MaxPollInterval)
+
+		// A restored copy that expired while waiting is restored again.
+		pending, err = startThaws(ctx, client, pending, options)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// startThaws starts restoring the keys that are archived and have no restored copy, and returns
+// the keys that cannot be downloaded yet.
+func startThaws(ctx context.Context, client *s3.Client, keys []string, options ThawOptions) ([]string, error) {
+	pending := []string{}
+	for _, key := range keys {
+		head, err := client.HeadObjectContext(ctx, key)
+		if err != nil {
+			if s3.IsErrorCode(err, s3.ErrCodeNoSuchKey) {
+				return nil, fmt.Errorf("%s not found: %w", key, err)
+			}
+			return nil, fmt.Errorf("head object: %w", err)
+		}
+		if !head.NeedsRestore() {
+			continue
+		}
+		pending = append(pending, key)
+
+		if head.Restore != nil {
+			continue // already in progress
+		}
+
+		slog.Info(fmt.Sprintf("Restoring %s from %s", key, head.StorageClass), "tier", options.Tier, "days", options.Days)
+		err = client.RestoreObjectContext(ctx, key, options.Days, options.Tier)
+		if err != nil && !s3.IsErrorCode(err, s3.ErrCodeRestoreAlreadyInProgress) {
+			return nil, fmt.Errorf("restore object %s: %w", key, err)
+		}
+	}
+	return pending, nil
+}
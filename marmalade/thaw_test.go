@@ -0,0 +1,96 @@
+package marmalade
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestThawWaitsForArchivedBackup(t *testing.T) {
+	client, fs3, file := setupTestWithConfig(t, s3.Config{StorageClass: "DEEP_ARCHIVE"})
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(now)
+
+	err := os.WriteFile(file, []byte("abc"), 0600)
+	assert.NoErr(t, err)
+	_, err = Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	// archived backups cannot be downloaded straight away
+	err = Restore(client, "2025-03-05.txt", func(r io.Reader) error { return nil })
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeInvalidObjectState))
+	assert.ErrContains(t, err, "must be thawed first")
+
+	clock := newFakeClock(now)
+	done := make(chan error, 1)
+	go func() {
+		done <- Thaw(client, "2025-03-05.txt", ThawOptions{Days: 2, Tier: s3.RestoreTierBulk, Clock: clock})
+	}()
+
+	// both the backup and its hash are restored
+	clock.WaitForTimers(t, 1)
+	assert.Equal(t, "Bulk", fs3.GetVersions("2025-03-05.txt")[0].Restored.Tier)
+	assert.NotZero(t, fs3.GetVersions("2025-03-05.txt.sha256")[0].Restored)
+
+	// checks back off while the restore is still going
+	clock.Advance(DefaultThawPollInterval)
+	clock.WaitForTimers(t, 1)
+	fs3.SetNow(now.Add(4 * time.Hour))
+	clock.Advance(DefaultThawPollInterval)
+	select {
+	case err := <-done:
+		t.Fatalf("thaw finished before its second check: %v", err)
+	default:
+	}
+	clock.Advance(DefaultThawPollInterval)
+	assert.NoErr(t, <-done)
+
+	var restored bytes.Buffer
+	err = Restore(client, "2025-03-05.txt", func(r io.Reader) error {
+		_, err := io.Copy(&restored, r)
+		return err
+	})
+	assert.NoErr(t, err)
+	assert.Equal(t, "abc", restored.String())
+}
+
+func TestThawNoWait(t *testing.T) {
+	client, fs3, file := setupTestWithConfig(t, s3.Config{StorageClass: "GLACIER"})
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	fs3.SetNow(now)
+
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	err = Thaw(client, "2025-03-05.txt", ThawOptions{NoWait: true})
+	assert.ErrIs(t, err, ErrThawing)
+	assert.ErrContains(t, err, "2025-03-05.txt, 2025-03-05.txt.sha256")
+
+	// running again while the restore is going does not start another one
+	err = Thaw(client, "2025-03-05.txt", ThawOptions{NoWait: true})
+	assert.ErrIs(t, err, ErrThawing)
+
+	fs3.SetNow(now.Add(4 * time.Hour))
+	err = Thaw(client, "2025-03-05.txt", ThawOptions{NoWait: true})
+	assert.NoErr(t, err)
+}
+
+func TestThawSkipsBackupsThatAreNotArchived(t *testing.T) {
+	client, _, file := setupTest(t)
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+
+	_, err := Backup(client, schedule, now, file, BackupOptions{})
+	assert.NoErr(t, err)
+
+	err = ThawContext(context.Background(), client, "2025-03-05.txt", ThawOptions{})
+	assert.NoErr(t, err)
+
+	err = Thaw(client, "2025-03-06.txt", ThawOptions{})
+	assert.ErrContains(t, err, "2025-03-06.txt not found")
+}
@@ -0,0 +1,121 @@
+package s3
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// ErrChecksumMismatch is returned when data does not match the SHA-256 checksum S3 has for it.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+func checksumSHA256(data []byte) string {
+	sum := sha256.Sum256(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// compositeChecksum is the checksum S3 gives a multipart upload, the SHA-256 of the checksums of
+// its parts followed by the number of parts.
+func compositeChecksum(parts []CompletedPart) (string, error) {
+	hash := sha256.New()
+	for _, part := range parts {
+		sum, err := base64.StdEncoding.DecodeString(part.ChecksumSHA256)
+		if err != nil {
+			return "", fmt.Errorf("decode checksum of part %d: %w", part.PartNumber, err)
+		}
+		hash.Write(sum)
+	}
+	return fmt.Sprintf("%s-%d", base64.StdEncoding.EncodeToString(hash.Sum(nil)), len(parts)), nil
+}
+
+// checksumReader verifies the body of an object against its full object checksum once it has all
+// been read.
+type checksumReader struct {
+	io.ReadCloser
+	key      string
+	checksum string
+	hash     hash.Hash
+}
+
+// newChecksumReader verifies body against checksum. Composite checksums are verified part by
+// part, which needs the parts of the object, and are not verified without them.
+func newChecksumReader(body io.ReadCloser, key string, checksum string, parts []ObjectPart) io.ReadCloser {
+	if checksum == "" {
+		return body
+	}
+	if strings.Contains(checksum, "-") {
+		if len(parts) == 0 {
+			return body
+		}
+		return &compositeChecksumReader{ReadCloser: body, key: key, parts: parts, hash: sha256.New()}
+	}
+	return &checksumReader{ReadCloser: body, key: key, checksum: checksum, hash: sha256.New()}
+}
+
+func (r *checksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.hash.Write(p[:n])
+
+	if errors.Is(err, io.EOF) {
+		if actual := base64.StdEncoding.EncodeToString(r.hash.Sum(nil)); actual != r.checksum {
+			return n, fmt.Errorf("%w: %s has sha256 %s, expected %s", ErrChecksumMismatch, r.key, actual, r.checksum)
+		}
+	}
+	return n, err
+}
+
+// partsChecksum is the composite checksum of an object made of parts.
+func partsChecksum(parts []ObjectPart) (string, error) {
+	completed := make([]CompletedPart, len(parts))
+	for i, part := range parts {
+		completed[i] = CompletedPart{PartNumber: part.PartNumber, ChecksumSHA256: part.ChecksumSHA256}
+	}
+	return compositeChecksum(completed)
+}
+
+// compositeChecksumReader verifies the body of a multipart upload against the checksum of each of
+// its parts as they are read. The parts must already be known to make up the composite checksum.
+type compositeChecksumReader struct {
+	io.ReadCloser
+	key   string
+	parts []ObjectPart
+	// part is the index of the part being read, of which read bytes have been hashed.
+	part int
+	read int64
+	hash hash.Hash
+}
+
+func (r *compositeChecksumReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+
+	data := p[:n]
+	for len(data) > 0 {
+		if r.part >= len(r.parts) {
+			return n, fmt.Errorf("%w: %s is longer than its %d parts", ErrChecksumMismatch, r.key, len(r.parts))
+		}
+
+		part := r.parts[r.part]
+		chunk := data[:min(int64(len(data)), part.Size-r.read)]
+		r.hash.Write(chunk)
+		r.read += int64(len(chunk))
+		data = data[len(chunk):]
+
+		if r.read == part.Size {
+			if actual := base64.StdEncoding.EncodeToString(r.hash.Sum(nil)); actual != part.ChecksumSHA256 {
+				return n, fmt.Errorf("%w: part %d of %s has sha256 %s, expected %s", ErrChecksumMismatch, part.PartNumber, r.key, actual, part.ChecksumSHA256)
+			}
+			r.part++
+			r.read = 0
+			r.hash.Reset()
+		}
+	}
+
+	if errors.Is(err, io.EOF) && r.part < len(r.parts) {
+		return n, fmt.Errorf("%w: %s ended in part %d of %d", ErrChecksumMismatch, r.key, r.part+1, len(r.parts))
+	}
+	return n, err
+}
@@ -0,0 +1,155 @@
+package s3_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestUploadChecksumsAreValidated(t *testing.T) {
+	sv := setupSignedServer(t, "")
+	sv.SetMinPartSize(0)
+
+	// the data is corrupted on the way to the server
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.Method == http.MethodPut {
+			r.Body = io.NopCloser(strings.NewReader("abd"))
+		}
+		return false
+	})
+
+	client := s3.NewClient(s3.Config{
+		URL:               sv.GetEndpoint(),
+		Region:            "my-region",
+		KeyID:             "keyid",
+		KeySecret:         "shh",
+		Bucket:            "my-bucket",
+		Insecure:          true,
+		MultipartPartSize: 3,
+		AllowSmallParts:   true,
+	})
+
+	err := client.PutObject("small.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.True(t, s3.IsErrorCode(err, "BadDigest"))
+	assert.Equal(t, 0, len(sv.GetVersions("small.txt")))
+
+	err = client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdef")), 6, nil)
+	assert.ErrContains(t, err, "upload part")
+	assert.True(t, s3.IsErrorCode(err, "BadDigest"))
+	assert.Equal(t, 0, sv.GetMultipartUploadCount())
+}
+
+func TestMultipartChecksumIsVerified(t *testing.T) {
+	sv := setupSignedServer(t, "")
+	sv.SetMinPartSize(0)
+
+	client := s3.NewClient(s3.Config{
+		URL:               sv.GetEndpoint(),
+		Region:            "my-region",
+		KeyID:             "keyid",
+		KeySecret:         "shh",
+		Bucket:            "my-bucket",
+		Insecure:          true,
+		MultipartPartSize: 3,
+		AllowSmallParts:   true,
+	})
+
+	err := client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdef")), 6, nil)
+	assert.NoErr(t, err)
+
+	// the server assembled something other than what was sent
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.Method == http.MethodPost && r.URL.Query().Has("uploadId") {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`<CompleteMultipartUploadResult><ChecksumSHA256>bm90IHRoZSBjaGVja3N1bQ==-2</ChecksumSHA256></CompleteMultipartUploadResult>`))
+			return true
+		}
+		return false
+	})
+
+	err = client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdef")), 6, nil)
+	assert.ErrIs(t, err, s3.ErrChecksumMismatch)
+}
+
+func TestDownloadChecksumIsVerified(t *testing.T) {
+	sv := setupSignedServer(t, "")
+	sv.SetMinPartSize(0)
+
+	client := s3.NewClient(s3.Config{
+		URL:               sv.GetEndpoint(),
+		Region:            "my-region",
+		KeyID:             "keyid",
+		KeySecret:         "shh",
+		Bucket:            "my-bucket",
+		Insecure:          true,
+		MultipartPartSize: 3,
+		AllowSmallParts:   true,
+	})
+
+	err := client.PutObject("small.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	err = client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdef")), 6, nil)
+	assert.NoErr(t, err)
+
+	object, err := client.GetObject("small.txt")
+	assert.NoErr(t, err)
+	data, err := io.ReadAll(object.Body)
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+	assert.Equal(t, "abc", string(data))
+	assert.Equal(t, "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=", object.ChecksumSHA256)
+
+	// multipart uploads have a composite checksum, which is checked part by part
+	object, err = client.GetObject("big.txt")
+	assert.NoErr(t, err)
+	data, err = io.ReadAll(object.Body)
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+	assert.Equal(t, "abcdef", string(data))
+	assert.True(t, strings.HasSuffix(object.ChecksumSHA256, "-2"))
+
+	parts, err := client.GetObjectParts("big.txt", "")
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(parts))
+	assert.Equal(t, s3.ObjectPart{PartNumber: 2, Size: 3, ChecksumSHA256: "y4N5rCCYqhZQKeOTilHaC87PwAj9Z5X0AReGR/lsWzQ="}, parts[1])
+
+	// the parts are listed a page at a time
+	sv.SetMaxKeys(1)
+	parts, err = client.GetObjectParts("big.txt", "")
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(parts))
+	sv.SetMaxKeys(0)
+
+	// the stored data rots
+	sv.GetVersions("small.txt")[0].Content = []byte("abd")
+
+	object, err = client.GetObject("small.txt")
+	assert.NoErr(t, err)
+	_, err = io.ReadAll(object.Body)
+	_ = object.Body.Close()
+	assert.ErrIs(t, err, s3.ErrChecksumMismatch)
+	assert.ErrContains(t, err, "small.txt has sha256")
+
+	sv.GetVersions("big.txt")[0].Content = []byte("abcdeg")
+
+	object, err = client.GetObject("big.txt")
+	assert.NoErr(t, err)
+	_, err = io.ReadAll(object.Body)
+	_ = object.Body.Close()
+	assert.ErrIs(t, err, s3.ErrChecksumMismatch)
+	assert.ErrContains(t, err, "part 2 of big.txt has sha256")
+
+	// as does an object that is cut short
+	sv.GetVersions("big.txt")[0].Content = []byte("abcd")
+
+	object, err = client.GetObject("big.txt")
+	assert.NoErr(t, err)
+	_, err = io.ReadAll(object.Body)
+	_ = object.Body.Close()
+	assert.ErrIs(t, err, s3.ErrChecksumMismatch)
+}
@@ -1,35 +1,134 @@
 package s3
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 )
 
+// Client talks to a single bucket. Every operation has a Context variant, such as
+// PutObjectContext, whose context is used for its requests and stops any retries once done. The
+// plain methods use context.Background.
 type Client struct {
-	endpoint     string
+	endpoint     endpoint
 	region       string
-	accessKey    string
-	secretKey    string
+	credentials  *credentialsCache
 	bucketName   string
 	storageClass string
+	encryption   Encryption
+	// metadata is sent as x-amz-meta-* headers with objects the client uploads.
+	metadata map[string]string
 
-	insecure bool
+	// bypassGovernance lets deletes and retention changes override GOVERNANCE mode locks.
+	bypassGovernance bool
+
+	partSize    int64
+	concurrency int
+
+	retryPolicy RetryPolicy
+	metrics     *metrics
 
 	httpClient *http.Client
+
+	// configErr is returned by every request if the Config was invalid.
+	configErr error
 }
 
 func NewClient(config Config) *Client {
+	partSize := config.MultipartPartSize
+	if partSize <= 0 {
+		partSize = DefaultMultipartPartSize
+	}
+	var partSizeErr error
+	if partSize < MinMultipartPartSize && !config.AllowSmallParts {
+		partSizeErr = fmt.Errorf("multipart part size of %d bytes is below the minimum of %d bytes", partSize, MinMultipartPartSize)
+	}
+	concurrency := config.MultipartConcurrency
+	if concurrency <= 0 {
+		concurrency = DefaultMultipartConcurrency
+	}
+
+	credentials := config.Credentials
+	if credentials == nil {
+		credentials = StaticCredentials{AccessKeyID: config.KeyID, SecretAccessKey: config.KeySecret, SessionToken: config.SessionToken}
+	}
+
+	endpoint, endpointErr := parseEndpoint(config.URL, config.Insecure, config.AddressingStyle, config.Bucket)
+	httpClient, transportErr := newHTTPClient(config.Transport)
+	if httpClient == nil {
+		httpClient = &http.Client{Timeout: DefaultTimeout}
+	}
+	credentials = withTransport(credentials, httpClient.Transport)
+
 	return &Client{
-		endpoint:     config.URL,
+		endpoint:     endpoint,
 		region:       config.Region,
-		accessKey:    config.KeyID,
-		secretKey:    config.KeySecret,
+		credentials:  &credentialsCache{provider: credentials},
 		bucketName:   config.Bucket,
 		storageClass: config.StorageClass,
-		insecure:     config.Insecure,
-		httpClient:   &http.Client{Timeout: 600 * time.Second},
+		encryption:   config.Encryption,
+		partSize:     partSize,
+		concurrency:  concurrency,
+		retryPolicy:  config.Retry.withDefaults(),
+		metrics:      &metrics{},
+		httpClient:   httpClient,
+		configErr:    errors.Join(endpointErr, transportErr, partSizeErr, config.Encryption.validate()),
+	}
+}
+
+// WithStorageClass returns a client that uploads and copies objects into storageClass, or the
+// default class of the bucket if it is empty. It shares its connections, credentials and metrics
+// with c.
+func (c *Client) WithStorageClass(storageClass string) *Client {
+	clone := *c
+	clone.storageClass = storageClass
+	return &clone
+}
+
+// WithMetadata returns a client that uploads and copies objects with metadata as their user
+// metadata, which is returned by GetObject and HeadObject. It shares its connections, credentials
+// and metrics with c.
+func (c *Client) WithMetadata(metadata map[string]string) *Client {
+	clone := *c
+	clone.metadata = metadata
+	return &clone
+}
+
+// setMetadataHeaders adds the user metadata of a request that uploads or copies an object.
+func (c *Client) setMetadataHeaders(header http.Header) {
+	for name, value := range c.metadata {
+		header.Set("x-amz-meta-"+name, value)
+	}
+}
+
+// parseMetadata returns the user metadata of a response, with lower case names.
+func parseMetadata(header http.Header) map[string]string {
+	metadata := map[string]string{}
+	for name := range header {
+		if suffix, ok := strings.CutPrefix(strings.ToLower(name), "x-amz-meta-"); ok {
+			metadata[suffix] = header.Get(name)
+		}
+	}
+	return metadata
+}
+
+// WithGovernanceBypass returns a client that deletes versions and shortens their retention even
+// while they are locked in GOVERNANCE mode, which needs the s3:BypassGovernanceRetention
+// permission. COMPLIANCE mode locks and legal holds still apply. It shares its connections,
+// credentials and metrics with c.
+func (c *Client) WithGovernanceBypass() *Client {
+	clone := *c
+	clone.bypassGovernance = true
+	return &clone
+}
+
+// setBypassGovernanceHeader asks S3 to override GOVERNANCE mode locks if the client may.
+func (c *Client) setBypassGovernanceHeader(header http.Header) {
+	if c.bypassGovernance {
+		header.Set("x-amz-bypass-governance-retention", "true")
 	}
 }
 
@@ -46,26 +145,34 @@ type ObjectIdentifier struct {
 	VersionID string `xml:"VersionId,omitempty"`
 }
 
-func (c *Client) buildURL(key string, query url.Values) string {
-	path := fmt.Sprintf("/%s", c.bucketName)
-	if key != "" {
-		path = fmt.Sprintf("%s/%s", path, url.PathEscape(key))
+// buildURL returns the URL of key, or of the bucket if key is empty.
+func (c *Client) buildURL(key string, query url.Values) (string, error) {
+	if c.configErr != nil {
+		return "", c.configErr
 	}
 
-	scheme := "https"
-	if c.insecure {
-		scheme = "http"
+	host := c.endpoint.host
+	path := c.endpoint.basePath
+	if c.endpoint.virtualHosted {
+		host = c.bucketName + "." + host
+		path += "/" + key
+	} else {
+		path += "/" + c.bucketName
+		if key != "" {
+			path += "/" + key
+		}
 	}
 
 	u := url.URL{
-		Scheme: scheme,
-		Host:   c.endpoint,
-		Path:   path,
+		Scheme:  c.endpoint.scheme,
+		Host:    host,
+		Path:    path,
+		RawPath: uriEncode(path, false),
 	}
 
 	if query != nil {
-		u.RawQuery = query.Encode()
+		u.RawQuery = canonicalQueryString(query)
 	}
 
-	return u.String()
+	return u.String(), nil
 }
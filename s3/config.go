@@ -1,6 +1,8 @@
 package s3
 
 type Config struct {
+	// URL is the endpoint, either a host such as s3.example.com:9000 or a full URL such as
+	// https://example.com/s3. Insecure only applies to a bare host.
 	URL          string
 	Region       string
 	KeyID        string
@@ -8,5 +10,33 @@ type Config struct {
 	Bucket       string
 	StorageClass string
 
+	// Encryption is the server-side encryption of uploaded objects.
+	Encryption Encryption
+
 	Insecure bool
+	// AddressingStyle is whether the bucket goes in the host or the path of requests.
+	AddressingStyle AddressingStyle
+
+	// SessionToken is sent with temporary KeyID and KeySecret.
+	SessionToken string
+	// Credentials supplies the keys to sign requests with. It defaults to KeyID, KeySecret and
+	// SessionToken.
+	Credentials CredentialsProvider
+
+	// MultipartPartSize is the size in bytes of each part of a multipart upload. It must be at
+	// least MinMultipartPartSize. Uploads of a known length use larger parts if they would need
+	// more than 10000, and streamed uploads double their part size every 1000 parts.
+	MultipartPartSize int64
+	// AllowSmallParts accepts a MultipartPartSize below MinMultipartPartSize. It only exists for
+	// tests against servers without that minimum, such as the fake S3 server of this module, and is
+	// not supported against AWS, which fails the upload with EntityTooSmall once it is completed.
+	AllowSmallParts bool
+	// MultipartConcurrency is the number of parts of a multipart upload sent at once.
+	MultipartConcurrency int
+
+	// Retry controls how failed requests are retried.
+	Retry RetryPolicy
+
+	// Transport controls TLS trust, client certificates, proxying, timeouts and connection pooling.
+	Transport TransportConfig
 }
@@ -0,0 +1,95 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// MaxCopyObjectSize is the largest object that CopyObject can copy.
+const MaxCopyObjectSize = 5 * 1024 * 1024 * 1024
+
+// CopyObject copies a version of sourceKey to key without downloading it, using the storage class
+// and encryption of the client. An empty sourceVersionID copies the latest version. Locks are not
+// copied, so the copy gets retention instead, if it is not nil. The copy keeps the metadata of the
+// source, unless the client has metadata of its own, which replaces it.
+func (c *Client) CopyObject(sourceKey, sourceVersionID, key string, retention *ObjectLockRetention) error {
+	return c.CopyObjectContext(context.Background(), sourceKey, sourceVersionID, key, retention)
+}
+
+func (c *Client) CopyObjectContext(ctx context.Context, sourceKey, sourceVersionID, key string, retention *ObjectLockRetention) error {
+	reqURL, err := c.buildURL(key, nil)
+	if err != nil {
+		return err
+	}
+
+	source := "/" + c.bucketName + "/" + uriEncode(sourceKey, false)
+	if sourceVersionID != "" {
+		source += "?versionId=" + url.QueryEscape(sourceVersionID)
+	}
+
+	_, err = withRetries(ctx, c, "CopyObject", func() (struct{}, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, nil)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		req.Header.Set("x-amz-copy-source", source)
+
+		if retention != nil {
+			req.Header.Set("x-amz-object-lock-mode", retention.Mode)
+			req.Header.Set("x-amz-object-lock-retain-until-date", retention.Until.Format(time.RFC3339))
+		}
+
+		if c.storageClass != "" {
+			req.Header.Set("x-amz-storage-class", c.storageClass)
+		}
+
+		if len(c.metadata) > 0 {
+			req.Header.Set("x-amz-metadata-directive", "REPLACE")
+			c.setMetadataHeaders(req.Header)
+		}
+
+		c.encryption.setHeaders(req.Header)
+		c.encryption.setCopySourceHeaders(req.Header)
+
+		// the copy is a single part, so it gets a checksum of the whole object
+		req.Header.Set("x-amz-checksum-algorithm", "SHA256")
+
+		if err := c.signV4(req, bytes.NewReader(nil)); err != nil {
+			return struct{}{}, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return struct{}{}, transportError(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusOK {
+			return struct{}{}, bodyError("CopyObject", resp, body)
+		}
+
+		// Like CompleteMultipartUpload, a copy may fail after the 200 response has started.
+		var result struct {
+			XMLName xml.Name
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return struct{}{}, fmt.Errorf("failed to parse CopyObject XML: %v", err)
+		}
+		if result.XMLName.Local == "Error" {
+			return struct{}{}, bodyError("CopyObject", resp, body)
+		}
+
+		return struct{}{}, nil
+	})
+
+	return err
+}
@@ -0,0 +1,107 @@
+package s3_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestCopyObject(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	err := client.PutObject("my file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	err = client.PutObject("my file.txt", bytes.NewReader([]byte("abcdef")), 6, nil)
+	assert.NoErr(t, err)
+
+	// copy the first version over the file into another storage class, with a lock
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	err = client.WithStorageClass("GLACIER_IR").CopyObject("my file.txt", "v1", "my file.txt", &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: until})
+	assert.NoErr(t, err)
+
+	versions := sv.GetVersions("my file.txt")
+	assert.Equal(t, 3, len(versions))
+	copied := versions[2]
+	assert.Equal(t, "v3", copied.VersionID)
+	assert.Equal(t, "abc", string(copied.Content))
+	assert.Equal(t, "GLACIER_IR", copied.StorageClass)
+	assert.Equal(t, "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=", copied.ChecksumSHA256)
+	assert.Equal(t, "COMPLIANCE", copied.Retention.Mode)
+	assert.Equal(t, until, copied.Retention.Until)
+
+	// the copy is now the latest version
+	object, err := client.GetObject("my file.txt")
+	assert.NoErr(t, err)
+	data, err := io.ReadAll(object.Body)
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+	assert.Equal(t, "abc", string(data))
+
+	// the client it was made from keeps the default class
+	err = client.CopyObject("my file.txt", "", "other.txt", nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, "STANDARD", sv.GetVersions("other.txt")[0].StorageClass)
+	assert.Equal(t, "abc", string(sv.GetVersions("other.txt")[0].Content))
+
+	err = client.CopyObject("my file.txt", "v9", "other.txt", nil)
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchVersion))
+	err = client.CopyObject("missing.txt", "", "other.txt", nil)
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchKey))
+}
+
+func TestCopyArchivedObject(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	client := s3.NewClient(s3.Config{
+		URL:          sv.GetEndpoint(),
+		Region:       "my-region",
+		KeyID:        "keyid",
+		KeySecret:    "shh",
+		Bucket:       "my-bucket",
+		Insecure:     true,
+		StorageClass: "DEEP_ARCHIVE",
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	err = client.WithStorageClass("STANDARD").CopyObject("my-file.txt", "", "my-file.txt", nil)
+	assert.True(t, s3.IsErrorCode(err, "InvalidObjectState"))
+	assert.Equal(t, 1, len(sv.GetVersions("my-file.txt")))
+}
+
+func TestCopyObjectWithCustomerKey(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	client := s3.NewClient(s3.Config{
+		URL:        sv.GetEndpoint(),
+		Region:     "my-region",
+		KeyID:      "keyid",
+		KeySecret:  "shh",
+		Bucket:     "my-bucket",
+		Insecure:   true,
+		Encryption: s3.Encryption{CustomerKey: bytes.Repeat([]byte{7}, 32)},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// the key is needed to read the source and to encrypt the copy
+	err = client.CopyObject("my-file.txt", "", "other.txt", nil)
+	assert.NoErr(t, err)
+	assert.NotZero(t, sv.GetVersions("other.txt")[0].Encryption.CustomerKeyMD5)
+	assert.Equal(t, sv.GetVersions("my-file.txt")[0].Encryption.CustomerKeyMD5, sv.GetVersions("other.txt")[0].Encryption.CustomerKeyMD5)
+}
@@ -0,0 +1,430 @@
+package s3
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Credentials are the keys requests are signed with. SessionToken is set for temporary
+// credentials, which stop working at Expires.
+type Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	// Expires is zero for credentials that do not expire.
+	Expires time.Time
+}
+
+// CredentialsProvider supplies the credentials for a Client. The Client caches them and only asks
+// again shortly before they expire.
+type CredentialsProvider interface {
+	Retrieve(ctx context.Context) (Credentials, error)
+}
+
+// transportProvider is implemented by providers that make HTTP requests of their own, so NewClient
+// can send them over the Transport of the Client, through its proxy and with its trusted CAs.
+type transportProvider interface {
+	withTransport(transport http.RoundTripper) CredentialsProvider
+}
+
+func withTransport(provider CredentialsProvider, transport http.RoundTripper) CredentialsProvider {
+	if p, ok := provider.(transportProvider); ok {
+		return p.withTransport(transport)
+	}
+	return provider
+}
+
+// credentialsRefreshWindow is how long before expiry credentials are replaced, so a request is
+// never signed with credentials that expire while it is in flight.
+const credentialsRefreshWindow = 5 * time.Minute
+
+// credentialsCache holds the credentials of a provider until they are about to expire.
+type credentialsCache struct {
+	provider CredentialsProvider
+
+	mu          sync.Mutex
+	credentials *Credentials
+}
+
+func (c *credentialsCache) Retrieve(ctx context.Context) (Credentials, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.credentials != nil && (c.credentials.Expires.IsZero() || time.Until(c.credentials.Expires) > credentialsRefreshWindow) {
+		return *c.credentials, nil
+	}
+
+	credentials, err := c.provider.Retrieve(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.credentials = &credentials
+	return credentials, nil
+}
+
+// StaticCredentials are fixed keys, such as those set in Config.
+type StaticCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+func (s StaticCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	if s.AccessKeyID == "" || s.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("static credentials: access key id and secret access key are required")
+	}
+	return Credentials{AccessKeyID: s.AccessKeyID, SecretAccessKey: s.SecretAccessKey, SessionToken: s.SessionToken}, nil
+}
+
+// EnvCredentials reads AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type EnvCredentials struct{}
+
+func (EnvCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	credentials := Credentials{
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("env credentials: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+	}
+	return credentials, nil
+}
+
+// SharedCredentialsFile reads a profile from an AWS credentials file.
+type SharedCredentialsFile struct {
+	// Path defaults to AWS_SHARED_CREDENTIALS_FILE, then ~/.aws/credentials.
+	Path string
+	// Profile defaults to AWS_PROFILE, then "default".
+	Profile string
+}
+
+func (s SharedCredentialsFile) Retrieve(ctx context.Context) (Credentials, error) {
+	path := s.Path
+	if path == "" {
+		path = os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	}
+	if path == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return Credentials{}, fmt.Errorf("shared credentials: %w", err)
+		}
+		path = filepath.Join(home, ".aws", "credentials")
+	}
+
+	profile := s.Profile
+	if profile == "" {
+		profile = os.Getenv("AWS_PROFILE")
+	}
+	if profile == "" {
+		profile = "default"
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("shared credentials: %w", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	values, err := readINISection(file, profile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("shared credentials %s: %w", path, err)
+	}
+	if values == nil {
+		return Credentials{}, fmt.Errorf("shared credentials %s: profile not found: %s", path, profile)
+	}
+
+	credentials := Credentials{
+		AccessKeyID:     values["aws_access_key_id"],
+		SecretAccessKey: values["aws_secret_access_key"],
+		SessionToken:    values["aws_session_token"],
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("shared credentials %s: profile %s has no keys", path, profile)
+	}
+	return credentials, nil
+}
+
+// readINISection returns the keys of section, or nil if there is no such section.
+func readINISection(r io.Reader, section string) (map[string]string, error) {
+	var values map[string]string
+	inSection := false
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			inSection = strings.TrimSpace(line[1:len(line)-1]) == section
+			if inSection && values == nil {
+				values = map[string]string{}
+			}
+			continue
+		}
+
+		if !inSection {
+			continue
+		}
+		if key, value, ok := strings.Cut(line, "="); ok {
+			values[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+		}
+	}
+
+	return values, scanner.Err()
+}
+
+// metadataTimeout bounds requests to the container and instance endpoints, which answer quickly
+// if they are there at all.
+const metadataTimeout = 5 * time.Second
+
+// metadataClient is used for the instance endpoint, and the container endpoint of providers that
+// are not given an HTTPClient. Both endpoints are link-local, so they are never proxied.
+var metadataClient = &http.Client{Timeout: metadataTimeout, Transport: directTransport()}
+
+func directTransport() *http.Transport {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	return transport
+}
+
+// metadataCredentials is the JSON returned by the container and instance endpoints.
+type metadataCredentials struct {
+	Code            string    `json:"Code"`
+	Message         string    `json:"Message"`
+	AccessKeyID     string    `json:"AccessKeyId"`
+	SecretAccessKey string    `json:"SecretAccessKey"`
+	Token           string    `json:"Token"`
+	Expiration      time.Time `json:"Expiration"`
+}
+
+func (m metadataCredentials) credentials() (Credentials, error) {
+	if m.Code != "" && m.Code != "Success" {
+		return Credentials{}, fmt.Errorf("%s: %s", m.Code, m.Message)
+	}
+	if m.AccessKeyID == "" || m.SecretAccessKey == "" {
+		return Credentials{}, fmt.Errorf("response has no keys")
+	}
+	return Credentials{AccessKeyID: m.AccessKeyID, SecretAccessKey: m.SecretAccessKey, SessionToken: m.Token, Expires: m.Expiration}, nil
+}
+
+// ContainerCredentials fetches credentials from the endpoint ECS and EKS provide to containers.
+type ContainerCredentials struct {
+	// Endpoint defaults to AWS_CONTAINER_CREDENTIALS_FULL_URI, or
+	// AWS_CONTAINER_CREDENTIALS_RELATIVE_URI on the ECS credentials host.
+	Endpoint string
+	// AuthorizationToken defaults to AWS_CONTAINER_AUTHORIZATION_TOKEN, or the contents of
+	// AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE.
+	AuthorizationToken string
+
+	// HTTPClient sends the requests. If it is nil, NewClient sets it to a client that trusts the
+	// certificate authorities of the Client, but does not use its proxy.
+	HTTPClient *http.Client
+}
+
+func (c ContainerCredentials) withTransport(transport http.RoundTripper) CredentialsProvider {
+	if c.HTTPClient == nil {
+		direct := directTransport()
+		if t, ok := transport.(*http.Transport); ok && t.TLSClientConfig != nil {
+			direct.TLSClientConfig = t.TLSClientConfig.Clone()
+		}
+		c.HTTPClient = &http.Client{Transport: direct, Timeout: metadataTimeout}
+	}
+	return c
+}
+
+const ecsCredentialsHost = "http://169.254.170.2"
+
+func (c ContainerCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	endpoint := c.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_CONTAINER_CREDENTIALS_FULL_URI")
+	}
+	if endpoint == "" {
+		if relative := os.Getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); relative != "" {
+			endpoint = ecsCredentialsHost + relative
+		}
+	}
+	if endpoint == "" {
+		return Credentials{}, fmt.Errorf("container credentials: no endpoint is set")
+	}
+
+	token := c.AuthorizationToken
+	if token == "" {
+		token = os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN")
+	}
+	if tokenFile := os.Getenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE"); token == "" && tokenFile != "" {
+		data, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return Credentials{}, fmt.Errorf("container credentials: %w", err)
+		}
+		token = strings.TrimSpace(string(data))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("container credentials: %w", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+
+	client := c.HTTPClient
+	if client == nil {
+		client = metadataClient
+	}
+
+	var response metadataCredentials
+	if err := getMetadataJSON(client, req, &response); err != nil {
+		return Credentials{}, fmt.Errorf("container credentials: %w", err)
+	}
+
+	credentials, err := response.credentials()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("container credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+// IMDSCredentials fetches the credentials of the EC2 instance role using IMDSv2.
+type IMDSCredentials struct {
+	// Endpoint defaults to AWS_EC2_METADATA_SERVICE_ENDPOINT, then http://169.254.169.254.
+	Endpoint string
+}
+
+const imdsTokenTTL = "21600"
+
+func (i IMDSCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	if strings.EqualFold(os.Getenv("AWS_EC2_METADATA_DISABLED"), "true") {
+		return Credentials{}, fmt.Errorf("instance credentials: disabled by AWS_EC2_METADATA_DISABLED")
+	}
+
+	endpoint := i.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_EC2_METADATA_SERVICE_ENDPOINT")
+	}
+	if endpoint == "" {
+		endpoint = "http://169.254.169.254"
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	// IMDSv2 requires a session token for every metadata request.
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint+"/latest/api/token", nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance credentials: %w", err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", imdsTokenTTL)
+	token, err := getMetadata(metadataClient, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance credentials: get token: %w", err)
+	}
+
+	credentialsURL := endpoint + "/latest/meta-data/iam/security-credentials/"
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, credentialsURL, nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance credentials: %w", err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+	roles, err := getMetadata(metadataClient, req)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance credentials: get role: %w", err)
+	}
+	role, _, _ := strings.Cut(strings.TrimSpace(roles), "\n")
+	if role == "" {
+		return Credentials{}, fmt.Errorf("instance credentials: instance has no role")
+	}
+
+	req, err = http.NewRequestWithContext(ctx, http.MethodGet, credentialsURL+role, nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance credentials: %w", err)
+	}
+	req.Header.Set("X-aws-ec2-metadata-token", token)
+
+	var response metadataCredentials
+	if err := getMetadataJSON(metadataClient, req, &response); err != nil {
+		return Credentials{}, fmt.Errorf("instance credentials: %w", err)
+	}
+
+	credentials, err := response.credentials()
+	if err != nil {
+		return Credentials{}, fmt.Errorf("instance credentials: %w", err)
+	}
+	return credentials, nil
+}
+
+func getMetadata(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	// Metadata responses are small, anything much larger is not one.
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("status: %s, response: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	return string(body), nil
+}
+
+func getMetadataJSON(client *http.Client, req *http.Request, v any) error {
+	body, err := getMetadata(client, req)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(body), v)
+}
+
+// CredentialsChain uses the first provider that returns credentials.
+type CredentialsChain []CredentialsProvider
+
+func (c CredentialsChain) withTransport(transport http.RoundTripper) CredentialsProvider {
+	chain := make(CredentialsChain, len(c))
+	for i, provider := range c {
+		chain[i] = withTransport(provider, transport)
+	}
+	return chain
+}
+
+func (c CredentialsChain) Retrieve(ctx context.Context) (Credentials, error) {
+	errs := []error{}
+	for _, provider := range c {
+		credentials, err := provider.Retrieve(ctx)
+		if err == nil {
+			return credentials, nil
+		}
+		if ctx.Err() != nil {
+			return Credentials{}, err
+		}
+		errs = append(errs, err)
+	}
+	return Credentials{}, fmt.Errorf("no credentials found: %w", errors.Join(errs...))
+}
+
+// DefaultCredentials looks for credentials the way AWS tools do: the environment, then a web
+// identity token, then the shared credentials file, then the container endpoint, then the instance
+// metadata service.
+func DefaultCredentials(profile string) CredentialsChain {
+	return CredentialsChain{
+		EnvCredentials{},
+		WebIdentityCredentials{},
+		SharedCredentialsFile{Profile: profile},
+		ContainerCredentials{},
+		IMDSCredentials{},
+	}
+}
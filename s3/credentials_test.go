@@ -0,0 +1,303 @@
+package s3_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func setupSignedServer(t *testing.T, sessionToken string) *fakes3.FakeS3 {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetNow(time.Now().UTC())
+	sv.SetCredentials("keyid", "shh", sessionToken)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	return sv
+}
+
+func TestSignsWithSessionToken(t *testing.T) {
+	sv := setupSignedServer(t, "token/with+special=chars")
+
+	client := s3.NewClient(s3.Config{
+		URL:          sv.GetEndpoint(),
+		Region:       "my-region",
+		KeyID:        "keyid",
+		KeySecret:    "shh",
+		SessionToken: "token/with+special=chars",
+		Bucket:       "my-bucket",
+		Insecure:     true,
+	})
+
+	err := client.PutObject("dir/my file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	err = client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdef")), 6, nil)
+	assert.NoErr(t, err)
+
+	result, err := client.ListObjectVersions("dir/", "", "", 500)
+	assert.NoErr(t, err)
+	assert.Equal(t, 1, len(result.Versions))
+
+	object, err := client.GetObject("dir/my file.txt")
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+
+	_, err = client.DeleteObjects([]s3.ObjectIdentifier{{Key: "dir/my file.txt", VersionID: result.Versions[0].VersionId}})
+	assert.NoErr(t, err)
+
+	// the token is required
+	client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", KeyID: "keyid", KeySecret: "shh", Bucket: "my-bucket", Insecure: true})
+	_, err = client.GetObject("big.txt")
+	assert.True(t, s3.IsErrorCode(err, "InvalidToken"))
+
+	// and so is the right secret
+	client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", KeyID: "keyid", KeySecret: "wrong", SessionToken: "token/with+special=chars", Bucket: "my-bucket", Insecure: true})
+	_, err = client.GetObject("big.txt")
+	assert.True(t, s3.IsErrorCode(err, "SignatureDoesNotMatch"))
+}
+
+type countingProvider struct {
+	calls   atomic.Int32
+	expires time.Duration
+}
+
+func (p *countingProvider) Retrieve(ctx context.Context) (s3.Credentials, error) {
+	p.calls.Add(1)
+	return s3.Credentials{AccessKeyID: "keyid", SecretAccessKey: "shh", Expires: time.Now().Add(p.expires)}, nil
+}
+
+func TestCredentialsRefreshBeforeExpiry(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	// credentials are kept until they are about to expire
+	provider := &countingProvider{expires: time.Hour}
+	client := s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", Bucket: "my-bucket", Insecure: true, Credentials: provider})
+	for range 3 {
+		_, err := client.ListObjectVersions("", "", "", 500)
+		assert.NoErr(t, err)
+	}
+	assert.Equal(t, int32(1), provider.calls.Load())
+
+	// then fetched again for each request
+	provider = &countingProvider{expires: time.Minute}
+	client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", Bucket: "my-bucket", Insecure: true, Credentials: provider})
+	for range 3 {
+		_, err := client.ListObjectVersions("", "", "", 500)
+		assert.NoErr(t, err)
+	}
+	assert.Equal(t, int32(3), provider.calls.Load())
+}
+
+func TestEnvCredentials(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err := s3.EnvCredentials{}.Retrieve(context.Background())
+	assert.ErrContains(t, err, "AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are not set")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "keyid")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "shh")
+	t.Setenv("AWS_SESSION_TOKEN", "token")
+	credentials, err := s3.EnvCredentials{}.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.Credentials{AccessKeyID: "keyid", SecretAccessKey: "shh", SessionToken: "token"}, credentials)
+}
+
+func TestSharedCredentialsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials")
+	err := os.WriteFile(path, []byte(`
+# comments are ignored
+[default]
+aws_access_key_id = default-key
+aws_secret_access_key = default-secret
+
+[backups]
+; so are these
+aws_access_key_id=backups-key
+aws_secret_access_key=backups-secret
+aws_session_token=backups-token
+
+[empty]
+region = us-east-1
+`), 0600)
+	assert.NoErr(t, err)
+
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+	t.Setenv("AWS_PROFILE", "")
+
+	credentials, err := s3.SharedCredentialsFile{}.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.Credentials{AccessKeyID: "default-key", SecretAccessKey: "default-secret"}, credentials)
+
+	credentials, err = s3.SharedCredentialsFile{Profile: "backups"}.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.Credentials{AccessKeyID: "backups-key", SecretAccessKey: "backups-secret", SessionToken: "backups-token"}, credentials)
+
+	t.Setenv("AWS_PROFILE", "backups")
+	credentials, err = s3.SharedCredentialsFile{Path: path}.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, "backups-key", credentials.AccessKeyID)
+
+	_, err = s3.SharedCredentialsFile{Profile: "empty"}.Retrieve(context.Background())
+	assert.ErrContains(t, err, "profile empty has no keys")
+
+	_, err = s3.SharedCredentialsFile{Profile: "missing"}.Retrieve(context.Background())
+	assert.ErrContains(t, err, "profile not found: missing")
+}
+
+func writeMetadataCredentials(t *testing.T, w http.ResponseWriter, expires time.Time) {
+	err := json.NewEncoder(w).Encode(map[string]any{
+		"Code":            "Success",
+		"AccessKeyId":     "temp-key",
+		"SecretAccessKey": "temp-secret",
+		"Token":           "temp-token",
+		"Expiration":      expires.Format(time.RFC3339),
+	})
+	assert.NoErr(t, err)
+}
+
+func TestContainerCredentialsAreNotProxied(t *testing.T) {
+	sv := setupSignedServer(t, "temp-token")
+	sv.SetCredentials("temp-key", "temp-secret", "temp-token")
+
+	var containerRequests atomic.Int32
+	container := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		containerRequests.Add(1)
+		writeMetadataCredentials(t, w, time.Now().Add(time.Hour))
+	}))
+	t.Cleanup(container.Close)
+
+	// the proxy forwards requests and records where they were going
+	proxied := make(chan string, 10)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied <- r.URL.Host
+		r.RequestURI = ""
+		resp, err := http.DefaultTransport.RoundTrip(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+		for name, values := range resp.Header {
+			w.Header()[name] = values
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+	}))
+	t.Cleanup(proxy.Close)
+
+	client := s3.NewClient(s3.Config{
+		URL:         sv.GetEndpoint(),
+		Region:      "my-region",
+		Bucket:      "my-bucket",
+		Insecure:    true,
+		Transport:   s3.TransportConfig{ProxyURL: proxy.URL},
+		Credentials: s3.ContainerCredentials{Endpoint: container.URL},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// only the request to S3 went through the proxy
+	assert.Equal(t, int32(1), containerRequests.Load())
+	assert.Equal(t, 1, len(proxied))
+	assert.Equal(t, sv.GetEndpoint(), <-proxied)
+}
+
+func TestContainerCredentials(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/creds" || r.Header.Get("Authorization") != "secret-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		writeMetadataCredentials(t, w, expires)
+	}))
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte("secret-token\n"), 0600)
+	assert.NoErr(t, err)
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", server.URL+"/creds")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN", "")
+	t.Setenv("AWS_CONTAINER_AUTHORIZATION_TOKEN_FILE", tokenFile)
+
+	credentials, err := s3.ContainerCredentials{}.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.Credentials{AccessKeyID: "temp-key", SecretAccessKey: "temp-secret", SessionToken: "temp-token", Expires: expires}, credentials)
+
+	_, err = s3.ContainerCredentials{AuthorizationToken: "wrong"}.Retrieve(context.Background())
+	assert.ErrContains(t, err, "401 Unauthorized")
+
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_FULL_URI", "")
+	t.Setenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI", "")
+	_, err = s3.ContainerCredentials{}.Retrieve(context.Background())
+	assert.ErrContains(t, err, "no endpoint is set")
+}
+
+func TestIMDSCredentials(t *testing.T) {
+	expires := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			if r.Method != http.MethodPut || r.Header.Get("X-aws-ec2-metadata-token-ttl-seconds") == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			_, _ = w.Write([]byte("session"))
+			return
+		}
+
+		if r.Header.Get("X-aws-ec2-metadata-token") != "session" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			_, _ = w.Write([]byte("backup-role"))
+		case "/latest/meta-data/iam/security-credentials/backup-role":
+			writeMetadataCredentials(t, w, expires)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "")
+
+	credentials, err := s3.IMDSCredentials{Endpoint: server.URL}.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.Credentials{AccessKeyID: "temp-key", SecretAccessKey: "temp-secret", SessionToken: "temp-token", Expires: expires}, credentials)
+
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	_, err = s3.IMDSCredentials{Endpoint: server.URL}.Retrieve(context.Background())
+	assert.ErrContains(t, err, "disabled")
+}
+
+func TestCredentialsChain(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+
+	chain := s3.CredentialsChain{s3.EnvCredentials{}, s3.StaticCredentials{AccessKeyID: "keyid", SecretAccessKey: "shh"}}
+	credentials, err := chain.Retrieve(context.Background())
+	assert.NoErr(t, err)
+	assert.Equal(t, "keyid", credentials.AccessKeyID)
+
+	chain = s3.CredentialsChain{s3.EnvCredentials{}, s3.StaticCredentials{}}
+	_, err = chain.Retrieve(context.Background())
+	assert.ErrContains(t, err, "no credentials found")
+	assert.ErrContains(t, err, "AWS_ACCESS_KEY_ID")
+	assert.ErrContains(t, err, "static credentials")
+}
@@ -2,9 +2,8 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
-	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 )
@@ -33,9 +32,16 @@ type DeletedError struct {
 }
 
 func (c *Client) DeleteObjects(objects []ObjectIdentifier) (*DeleteObjectsResult, error) {
+	return c.DeleteObjectsContext(context.Background(), objects)
+}
+
+func (c *Client) DeleteObjectsContext(ctx context.Context, objects []ObjectIdentifier) (*DeleteObjectsResult, error) {
 	query := url.Values{}
 	query.Set("delete", "")
-	reqURL := c.buildURL("", query)
+	reqURL, err := c.buildURL("", query)
+	if err != nil {
+		return nil, err
+	}
 
 	deleteReq := DeleteObjectsRequest{
 		Objects: objects,
@@ -47,9 +53,9 @@ func (c *Client) DeleteObjects(objects []ObjectIdentifier) (*DeleteObjectsResult
 		return nil, err
 	}
 
-	return withRetries(func() (*DeleteObjectsResult, error) {
+	return withRetries(ctx, c, "DeleteObjects", func() (*DeleteObjectsResult, error) {
 		bodyReader := bytes.NewReader(data)
-		req, err := http.NewRequest(http.MethodPost, reqURL, bodyReader)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bodyReader)
 		if err != nil {
 			return nil, err
 		}
@@ -59,6 +65,7 @@ func (c *Client) DeleteObjects(objects []ObjectIdentifier) (*DeleteObjectsResult
 
 		md5sum := getMD5Sum(data)
 		req.Header.Set("Content-MD5", md5sum)
+		c.setBypassGovernanceHeader(req.Header)
 
 		if err := c.signV4(req, bytes.NewReader(data)); err != nil {
 			return nil, err
@@ -66,19 +73,12 @@ func (c *Client) DeleteObjects(objects []ObjectIdentifier) (*DeleteObjectsResult
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return nil, retriableError{err}
+			return nil, transportError(err)
 		}
 		defer func() { _ = resp.Body.Close() }()
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			err := fmt.Errorf("DeleteObjects failed with status: %s, response: %s", resp.Status, string(body))
-
-			if resp.StatusCode >= 500 {
-				return nil, retriableError{err}
-			} else {
-				return nil, err
-			}
+			return nil, responseError("DeleteObjects", resp)
 		}
 
 		result := &DeleteObjectsResult{}
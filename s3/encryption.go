@@ -0,0 +1,92 @@
+package s3
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+const (
+	// SSEAES256 encrypts objects with keys managed by S3.
+	SSEAES256 = "AES256"
+	// SSEKMS encrypts objects with a key from KMS.
+	SSEKMS = "aws:kms"
+)
+
+// Encryption is the server-side encryption of uploaded objects. It is applied on top of whatever
+// the caller encrypted itself.
+type Encryption struct {
+	// Algorithm is SSEAES256 or SSEKMS, or empty to use the default of the bucket.
+	Algorithm string
+	// KMSKeyID is the KMS key used with SSEKMS. Empty uses the AWS managed key.
+	KMSKeyID string
+	// KMSContext is the encryption context sent to KMS with SSEKMS.
+	KMSContext map[string]string
+
+	// CustomerKey is a 32 byte AES-256 key for SSE-C. S3 does not store it, so it is sent with
+	// every request that reads or writes the object. It cannot be combined with Algorithm.
+	CustomerKey []byte
+}
+
+func (e Encryption) validate() error {
+	if e.Algorithm != "" && e.Algorithm != SSEAES256 && e.Algorithm != SSEKMS {
+		return fmt.Errorf("unknown server-side encryption algorithm: %s", e.Algorithm)
+	}
+	if e.Algorithm != SSEKMS && (e.KMSKeyID != "" || len(e.KMSContext) > 0) {
+		return fmt.Errorf("a kms key id or context needs the %s algorithm", SSEKMS)
+	}
+	if e.CustomerKey != nil {
+		if e.Algorithm != "" {
+			return fmt.Errorf("a customer key cannot be combined with the %s algorithm", e.Algorithm)
+		}
+		if len(e.CustomerKey) != 32 {
+			return fmt.Errorf("customer key must be 32 bytes, got %d", len(e.CustomerKey))
+		}
+	}
+	return nil
+}
+
+// setHeaders adds the headers for a request that creates an object.
+func (e Encryption) setHeaders(header http.Header) {
+	if e.Algorithm != "" {
+		header.Set("x-amz-server-side-encryption", e.Algorithm)
+	}
+	if e.KMSKeyID != "" {
+		header.Set("x-amz-server-side-encryption-aws-kms-key-id", e.KMSKeyID)
+	}
+	if len(e.KMSContext) > 0 {
+		// a map of strings always encodes
+		context, _ := json.Marshal(e.KMSContext)
+		header.Set("x-amz-server-side-encryption-context", base64.StdEncoding.EncodeToString(context))
+	}
+
+	e.setCustomerKeyHeaders(header)
+}
+
+// setCustomerKeyHeaders adds the SSE-C key, which every request touching the content of an object
+// needs, including uploading its parts and reading it back.
+func (e Encryption) setCustomerKeyHeaders(header http.Header) {
+	if e.CustomerKey == nil {
+		return
+	}
+
+	keyMD5 := md5.Sum(e.CustomerKey)
+	header.Set("x-amz-server-side-encryption-customer-algorithm", SSEAES256)
+	header.Set("x-amz-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(e.CustomerKey))
+	header.Set("x-amz-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(keyMD5[:]))
+}
+
+// setCopySourceHeaders adds the SSE-C key needed to read the source of a copy. Copies are always
+// made within the bucket, so the source has the same key.
+func (e Encryption) setCopySourceHeaders(header http.Header) {
+	if e.CustomerKey == nil {
+		return
+	}
+
+	keyMD5 := md5.Sum(e.CustomerKey)
+	header.Set("x-amz-copy-source-server-side-encryption-customer-algorithm", SSEAES256)
+	header.Set("x-amz-copy-source-server-side-encryption-customer-key", base64.StdEncoding.EncodeToString(e.CustomerKey))
+	header.Set("x-amz-copy-source-server-side-encryption-customer-key-MD5", base64.StdEncoding.EncodeToString(keyMD5[:]))
+}
@@ -0,0 +1,130 @@
+package s3_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestServerSideEncryption(t *testing.T) {
+	sv := setupSignedServer(t, "")
+	sv.SetMinPartSize(0)
+
+	testCases := []struct {
+		name       string
+		encryption s3.Encryption
+		keyID      string
+		context    string
+	}{
+		{"s3 managed", s3.Encryption{Algorithm: s3.SSEAES256}, "", ""},
+		{"kms", s3.Encryption{Algorithm: s3.SSEKMS, KMSKeyID: "alias/backups", KMSContext: map[string]string{"job": "postgres"}}, "alias/backups", "postgres"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sv.Reset()
+			client := s3.NewClient(s3.Config{
+				URL:               sv.GetEndpoint(),
+				Region:            "my-region",
+				KeyID:             "keyid",
+				KeySecret:         "shh",
+				Bucket:            "my-bucket",
+				Insecure:          true,
+				MultipartPartSize: 4,
+				AllowSmallParts:   true,
+				Encryption:        tc.encryption,
+			})
+
+			err := client.PutObject("small.txt", bytes.NewReader([]byte("abc")), 3, nil)
+			assert.NoErr(t, err)
+			err = client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdefgh")), 8, nil)
+			assert.NoErr(t, err)
+
+			for _, key := range []string{"small.txt", "big.txt"} {
+				encryption := sv.GetVersions(key)[0].Encryption
+				assert.Equal(t, tc.encryption.Algorithm, encryption.Algorithm)
+				assert.Equal(t, tc.keyID, encryption.KMSKeyID)
+				assert.Equal(t, tc.context, encryption.KMSContext["job"])
+			}
+
+			// reading the object back needs nothing extra
+			object, err := client.GetObject("big.txt")
+			assert.NoErr(t, err)
+			_ = object.Body.Close()
+		})
+	}
+}
+
+func TestCustomerKeyEncryption(t *testing.T) {
+	sv := setupSignedServer(t, "")
+	sv.SetMinPartSize(0)
+	key := bytes.Repeat([]byte{7}, 32)
+
+	client := s3.NewClient(s3.Config{
+		URL:               sv.GetEndpoint(),
+		Region:            "my-region",
+		KeyID:             "keyid",
+		KeySecret:         "shh",
+		Bucket:            "my-bucket",
+		Insecure:          true,
+		MultipartPartSize: 4,
+		AllowSmallParts:   true,
+		Encryption:        s3.Encryption{CustomerKey: key},
+	})
+
+	// the key is sent when creating objects, uploading and completing parts, and setting retention
+	err := client.PutObject("small.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	err = client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdefgh")), 8, nil)
+	assert.NoErr(t, err)
+	err = client.PutObjectRetention("big.txt", &s3.ObjectLockRetention{Mode: "GOVERNANCE", Until: time.Now().Add(time.Hour)})
+	assert.NoErr(t, err)
+	assert.NotZero(t, sv.GetVersions("big.txt")[0].Encryption.CustomerKeyMD5)
+
+	object, err := client.GetObject("big.txt")
+	assert.NoErr(t, err)
+	data, err := io.ReadAll(object.Body)
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+	assert.Equal(t, "abcdefgh", string(data))
+
+	// the object cannot be read without the key, or with the wrong one
+	client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", KeyID: "keyid", KeySecret: "shh", Bucket: "my-bucket", Insecure: true})
+	_, err = client.GetObject("big.txt")
+	assert.True(t, s3.IsErrorCode(err, "InvalidRequest"))
+
+	client = s3.NewClient(s3.Config{
+		URL:        sv.GetEndpoint(),
+		Region:     "my-region",
+		KeyID:      "keyid",
+		KeySecret:  "shh",
+		Bucket:     "my-bucket",
+		Insecure:   true,
+		Encryption: s3.Encryption{CustomerKey: bytes.Repeat([]byte{8}, 32)},
+	})
+	_, err = client.GetObject("big.txt")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeAccessDenied))
+}
+
+func TestInvalidEncryption(t *testing.T) {
+	testCases := []struct {
+		encryption s3.Encryption
+		error      string
+	}{
+		{s3.Encryption{Algorithm: "ROT13"}, "unknown server-side encryption algorithm: ROT13"},
+		{s3.Encryption{Algorithm: s3.SSEAES256, KMSKeyID: "alias/backups"}, "a kms key id or context needs the aws:kms algorithm"},
+		{s3.Encryption{KMSContext: map[string]string{"job": "postgres"}}, "a kms key id or context needs the aws:kms algorithm"},
+		{s3.Encryption{Algorithm: s3.SSEAES256, CustomerKey: make([]byte, 32)}, "a customer key cannot be combined with the AES256 algorithm"},
+		{s3.Encryption{CustomerKey: make([]byte, 16)}, "customer key must be 32 bytes, got 16"},
+	}
+
+	for _, tc := range testCases {
+		client := s3.NewClient(s3.Config{URL: "s3.example.com", Region: "my-region", Bucket: "my-bucket", KeyID: "keyid", KeySecret: "shh", Encryption: tc.encryption})
+		err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+		assert.ErrContains(t, err, tc.error)
+	}
+}
@@ -0,0 +1,114 @@
+package s3
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+// AddressingStyle is how the bucket is put in request URLs.
+type AddressingStyle string
+
+const (
+	// AddressingAuto uses virtual-hosted style for AWS endpoints when the bucket name allows it,
+	// and path style everywhere else.
+	AddressingAuto AddressingStyle = ""
+	// AddressingPath puts the bucket in the path, as in https://host/bucket/key.
+	AddressingPath AddressingStyle = "path"
+	// AddressingVirtualHosted puts the bucket in the host, as in https://bucket.host/key.
+	AddressingVirtualHosted AddressingStyle = "virtual"
+)
+
+// ParseAddressingStyle accepts "auto", "path" or "virtual".
+func ParseAddressingStyle(style string) (AddressingStyle, error) {
+	switch style {
+	case "", "auto":
+		return AddressingAuto, nil
+	case string(AddressingPath):
+		return AddressingPath, nil
+	case string(AddressingVirtualHosted), "virtual-hosted":
+		return AddressingVirtualHosted, nil
+	default:
+		return "", fmt.Errorf("unknown addressing style: %s", style)
+	}
+}
+
+type endpoint struct {
+	scheme        string
+	host          string
+	basePath      string
+	virtualHosted bool
+}
+
+// parseEndpoint reads an endpoint that is either a bare host, such as s3.example.com:9000, or a
+// full URL with a scheme and optional base path, such as https://example.com/s3. The scheme of a
+// full URL takes precedence over insecure.
+func parseEndpoint(raw string, insecure bool, style AddressingStyle, bucket string) (endpoint, error) {
+	e := endpoint{scheme: "https", host: raw}
+	if insecure {
+		e.scheme = "http"
+	}
+
+	if strings.Contains(raw, "://") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return endpoint{}, fmt.Errorf("parse endpoint: %w", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return endpoint{}, fmt.Errorf("parse endpoint %s: scheme must be http or https", raw)
+		}
+		if u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+			return endpoint{}, fmt.Errorf("parse endpoint %s: query, fragment and user info are not supported", raw)
+		}
+		e.scheme = u.Scheme
+		e.host = u.Host
+		e.basePath = strings.TrimSuffix(u.Path, "/")
+	}
+
+	if e.host == "" {
+		return endpoint{}, fmt.Errorf("parse endpoint %q: host is required", raw)
+	}
+
+	switch style {
+	case AddressingPath:
+	case AddressingVirtualHosted:
+		if !validVirtualHostBucket(bucket, false) {
+			return endpoint{}, fmt.Errorf("bucket %s cannot be used in a host name", bucket)
+		}
+		e.virtualHosted = true
+	case AddressingAuto:
+		hostname := e.host
+		if h, _, err := net.SplitHostPort(e.host); err == nil {
+			hostname = h
+		}
+		// Dots in the bucket would not match the wildcard certificate of AWS over https.
+		e.virtualHosted = strings.HasSuffix(hostname, ".amazonaws.com") && validVirtualHostBucket(bucket, e.scheme == "https")
+	default:
+		return endpoint{}, fmt.Errorf("unknown addressing style: %s", style)
+	}
+
+	return e, nil
+}
+
+// validVirtualHostBucket reports whether bucket is a valid DNS label sequence.
+func validVirtualHostBucket(bucket string, noDots bool) bool {
+	if len(bucket) < 3 || len(bucket) > 63 || (noDots && strings.Contains(bucket, ".")) {
+		return false
+	}
+	if net.ParseIP(bucket) != nil {
+		return false
+	}
+
+	for _, label := range strings.Split(bucket, ".") {
+		if label == "" || label[0] == '-' || label[len(label)-1] == '-' {
+			return false
+		}
+		for _, c := range label {
+			if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+				return false
+			}
+		}
+	}
+	return true
+}
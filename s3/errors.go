@@ -0,0 +1,104 @@
+package s3
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// Error is an error response from S3, parsed from its <Error> XML body. Use errors.As to get one
+// from an error returned by the Client.
+type Error struct {
+	// Operation is the request that failed, such as PutObject.
+	Operation string `xml:"-"`
+	// StatusCode is the HTTP status of the response.
+	StatusCode int    `xml:"-"`
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+	RequestID  string `xml:"RequestId"`
+	HostID     string `xml:"HostId"`
+
+	// body is kept for responses that are not S3 error XML, such as from a proxy.
+	body string
+}
+
+// S3 error codes that callers commonly need to tell apart.
+const (
+	ErrCodeAccessDenied             = "AccessDenied"
+	ErrCodeNoSuchKey                = "NoSuchKey"
+	ErrCodeNoSuchVersion            = "NoSuchVersion"
+	ErrCodeNoSuchUpload             = "NoSuchUpload"
+	ErrCodeInvalidRequest           = "InvalidRequest"
+	ErrCodeInvalidObjectState       = "InvalidObjectState"
+	ErrCodeRestoreAlreadyInProgress = "RestoreAlreadyInProgress"
+	ErrCodeSlowDown                 = "SlowDown"
+	ErrCodeRequestTimeTooSkewed     = "RequestTimeTooSkewed"
+
+	// ErrCodeNoSuchObjectLockConfiguration is returned for the legal hold of a version that never
+	// had one.
+	ErrCodeNoSuchObjectLockConfiguration = "NoSuchObjectLockConfiguration"
+)
+
+// retriableErrorCodes are failures on the server side that may succeed if tried again.
+var retriableErrorCodes = []string{
+	"InternalError",
+	"ServiceUnavailable",
+	"RequestTimeout",
+	ErrCodeSlowDown,
+}
+
+func (e *Error) Error() string {
+	status := fmt.Sprintf("%d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code == "" {
+		return fmt.Sprintf("%s failed with status: %s, response: %s", e.Operation, status, e.body)
+	}
+
+	message := fmt.Sprintf("%s failed with status: %s, code: %s, message: %s", e.Operation, status, e.Code, e.Message)
+	if e.RequestID != "" {
+		message += ", request id: " + e.RequestID
+	}
+	return message
+}
+
+// IsErrorCode reports whether err is an Error with one of codes.
+func IsErrorCode(err error, codes ...string) bool {
+	var s3Err *Error
+	if !errors.As(err, &s3Err) {
+		return false
+	}
+	return slices.Contains(codes, s3Err.Code)
+}
+
+func (e *Error) retriable() bool {
+	return slices.Contains(retriableErrorCodes, e.Code) || e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// parseError builds an Error from a failed response body. Bodies that are not S3 error XML are
+// kept as they are.
+func parseError(operation string, statusCode int, body []byte) *Error {
+	e := &Error{}
+	if err := xml.Unmarshal(body, e); err != nil || e.Code == "" {
+		e = &Error{body: strings.TrimSpace(string(body))}
+	}
+	e.Operation = operation
+	e.StatusCode = statusCode
+	return e
+}
+
+// responseError reads the body of a failed response into an Error, marking it retriable if it is.
+func responseError(operation string, resp *http.Response) error {
+	body, _ := io.ReadAll(resp.Body)
+	return bodyError(operation, resp, body)
+}
+
+func bodyError(operation string, resp *http.Response, body []byte) error {
+	e := parseError(operation, resp.StatusCode, body)
+	if e.retriable() {
+		return retriableError{err: e, retryAfter: parseRetryAfter(resp.Header)}
+	}
+	return e
+}
@@ -0,0 +1,105 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+type GetObjectResult struct {
+	Body          io.ReadCloser
+	ContentLength int64
+	VersionID     string
+	// ChecksumSHA256 is the base64 checksum S3 has for the object. A checksum of a multipart
+	// upload is composite, made of the checksums of its parts and ending in the number of parts.
+	ChecksumSHA256 string
+	// Metadata is the user metadata of the object, with lower case names.
+	Metadata map[string]string
+}
+
+// GetObject fetches the latest version of key. The caller must close the returned Body. Reading
+// the Body to the end fails with ErrChecksumMismatch if the data does not match the checksum S3
+// has for it. The composite checksum of a multipart upload is checked part by part, using the
+// parts listed by GetObjectParts. Servers without GetObjectAttributes cannot list them, so
+// multipart uploads are not checked against them.
+func (c *Client) GetObject(key string) (*GetObjectResult, error) {
+	return c.GetObjectContext(context.Background(), key)
+}
+
+func (c *Client) GetObjectContext(ctx context.Context, key string) (*GetObjectResult, error) {
+	reqURL, err := c.buildURL(key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	result, err := withRetries(ctx, c, "GetObject", func() (*GetObjectResult, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		c.encryption.setCustomerKeyHeaders(req.Header)
+		req.Header.Set("x-amz-checksum-mode", "ENABLED")
+
+		if err := c.signV4(req, bytes.NewReader(nil)); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, transportError(err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			defer func() { _ = resp.Body.Close() }()
+			return nil, responseError("GetObject", resp)
+		}
+
+		return &GetObjectResult{
+			Body:           resp.Body,
+			ContentLength:  resp.ContentLength,
+			VersionID:      resp.Header.Get("x-amz-version-id"),
+			ChecksumSHA256: resp.Header.Get("x-amz-checksum-sha256"),
+			Metadata:       parseMetadata(resp.Header),
+		}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var parts []ObjectPart
+	if strings.Contains(result.ChecksumSHA256, "-") {
+		parts, err = c.objectParts(ctx, key, result)
+		if err != nil {
+			_ = result.Body.Close()
+			return nil, err
+		}
+	}
+	result.Body = newChecksumReader(result.Body, key, result.ChecksumSHA256, parts)
+
+	return result, nil
+}
+
+// objectParts lists the parts of a downloaded multipart upload, checking that they make up its
+// composite checksum. It returns no parts if the server cannot list them.
+func (c *Client) objectParts(ctx context.Context, key string, object *GetObjectResult) ([]ObjectPart, error) {
+	parts, err := c.GetObjectPartsContext(ctx, key, object.VersionID)
+	if IsErrorCode(err, "NotImplemented") {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get parts of %s: %w", key, err)
+	}
+
+	checksum, err := partsChecksum(parts)
+	if err != nil {
+		return nil, err
+	}
+	if checksum != object.ChecksumSHA256 {
+		return nil, fmt.Errorf("%w: parts of %s have checksum %s, expected %s", ErrChecksumMismatch, key, checksum, object.ChecksumSHA256)
+	}
+	return parts, nil
+}
@@ -0,0 +1,130 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type HeadObjectResult struct {
+	ContentLength int64
+	VersionID     string
+	// StorageClass is empty for STANDARD, which S3 does not report.
+	StorageClass   string
+	ChecksumSHA256 string
+	// Restore is the state of the restored copy of an archived object, nil if it has never been
+	// restored.
+	Restore *RestoreStatus
+	// Metadata is the user metadata of the object, with lower case names.
+	Metadata map[string]string
+}
+
+// RestoreStatus is parsed from the x-amz-restore header.
+type RestoreStatus struct {
+	// Ongoing is set until the restored copy is ready.
+	Ongoing bool
+	// Expires is when the restored copy will be removed, zero while the restore is ongoing.
+	Expires time.Time
+}
+
+// NeedsRestore reports whether the object is archived and has no restored copy that can be read.
+func (r *HeadObjectResult) NeedsRestore() bool {
+	return IsArchivedStorageClass(r.StorageClass) && (r.Restore == nil || r.Restore.Ongoing)
+}
+
+// HeadObject fetches the metadata of the latest version of key.
+func (c *Client) HeadObject(key string) (*HeadObjectResult, error) {
+	return c.HeadObjectContext(context.Background(), key)
+}
+
+func (c *Client) HeadObjectContext(ctx context.Context, key string) (*HeadObjectResult, error) {
+	reqURL, err := c.buildURL(key, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return withRetries(ctx, c, "HeadObject", func() (*HeadObjectResult, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, reqURL, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		c.encryption.setCustomerKeyHeaders(req.Header)
+		req.Header.Set("x-amz-checksum-mode", "ENABLED")
+
+		if err := c.signV4(req, bytes.NewReader(nil)); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, transportError(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusOK {
+			err := responseError("HeadObject", resp)
+
+			// HEAD responses have no body, so a missing object is only told by its status.
+			var s3Err *Error
+			if errors.As(err, &s3Err) && s3Err.Code == "" && resp.StatusCode == http.StatusNotFound {
+				s3Err.Code = ErrCodeNoSuchKey
+			}
+			return nil, err
+		}
+
+		result := &HeadObjectResult{
+			ContentLength:  resp.ContentLength,
+			VersionID:      resp.Header.Get("x-amz-version-id"),
+			StorageClass:   resp.Header.Get("x-amz-storage-class"),
+			ChecksumSHA256: resp.Header.Get("x-amz-checksum-sha256"),
+			Metadata:       parseMetadata(resp.Header),
+		}
+		if header := resp.Header.Get("x-amz-restore"); header != "" {
+			restore, err := parseRestoreHeader(header)
+			if err != nil {
+				return nil, err
+			}
+			result.Restore = restore
+		}
+		return result, nil
+	})
+}
+
+// parseRestoreHeader reads an x-amz-restore header such as
+//
+//	ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"
+func parseRestoreHeader(header string) (*RestoreStatus, error) {
+	restore := &RestoreStatus{}
+
+	// The expiry date has a comma of its own, so split on the quotes instead.
+	rest := header
+	for rest != "" {
+		name, value, ok := strings.Cut(rest, `="`)
+		if !ok {
+			return nil, fmt.Errorf("parse x-amz-restore header: %s", header)
+		}
+		value, rest, ok = strings.Cut(value, `"`)
+		if !ok {
+			return nil, fmt.Errorf("parse x-amz-restore header: %s", header)
+		}
+		rest = strings.TrimLeft(rest, ", ")
+
+		switch strings.TrimSpace(name) {
+		case "ongoing-request":
+			restore.Ongoing = value == "true"
+		case "expiry-date":
+			expires, err := time.Parse(http.TimeFormat, value)
+			if err != nil {
+				return nil, fmt.Errorf("parse x-amz-restore expiry date: %w", err)
+			}
+			restore.Expires = expires
+		}
+	}
+
+	return restore, nil
+}
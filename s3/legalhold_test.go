@@ -0,0 +1,121 @@
+package s3_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func setupLockServer(t *testing.T) (*fakes3.FakeS3, *s3.Client, time.Time) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	sv.SetNow(now)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	return sv, client, now
+}
+
+func TestPutObjectLegalHold(t *testing.T) {
+	sv, client, _ := setupLockServer(t)
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	held, err := client.GetObjectLegalHold("my-file.txt", "")
+	assert.NoErr(t, err)
+	assert.True(t, !held)
+
+	err = client.PutObjectLegalHold("my-file.txt", true)
+	assert.NoErr(t, err)
+	assert.True(t, sv.GetVersions("my-file.txt")[0].LegalHold)
+	held, err = client.GetObjectLegalHold("my-file.txt", "v1")
+	assert.NoErr(t, err)
+	assert.True(t, held)
+
+	// nobody can delete a held version, not even with the governance bypass
+	res, err := client.WithGovernanceBypass().DeleteObjects([]s3.ObjectIdentifier{{Key: "my-file.txt", VersionID: "v1"}})
+	assert.NoErr(t, err)
+	assert.Equal(t, 1, len(res.Error))
+	assert.Equal(t, "Object is under legal hold", res.Error[0].Message)
+
+	// release the hold and delete it
+	err = client.PutObjectLegalHold("my-file.txt", false)
+	assert.NoErr(t, err)
+	assert.True(t, !sv.GetVersions("my-file.txt")[0].LegalHold)
+	held, err = client.GetObjectLegalHold("my-file.txt", "v1")
+	assert.NoErr(t, err)
+	assert.True(t, !held)
+
+	res, err = client.DeleteObjects([]s3.ObjectIdentifier{{Key: "my-file.txt", VersionID: "v1"}})
+	assert.NoErr(t, err)
+	assert.Equal(t, 0, len(res.Error))
+
+	err = client.PutObjectLegalHold("missing.txt", true)
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchKey))
+}
+
+func TestGovernanceBypass(t *testing.T) {
+	sv, client, now := setupLockServer(t)
+
+	err := client.PutObject("governance.txt", bytes.NewReader([]byte("abc")), 3, &s3.ObjectLockRetention{Mode: s3.LockModeGovernance, Until: now.Add(time.Hour)})
+	assert.NoErr(t, err)
+	err = client.PutObject("compliance.txt", bytes.NewReader([]byte("abc")), 3, &s3.ObjectLockRetention{Mode: s3.LockModeCompliance, Until: now.Add(time.Hour)})
+	assert.NoErr(t, err)
+
+	// without the bypass, both locks hold
+	res, err := client.DeleteObjects([]s3.ObjectIdentifier{{Key: "governance.txt", VersionID: "v1"}, {Key: "compliance.txt", VersionID: "v2"}})
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(res.Error))
+
+	// the bypass only applies to GOVERNANCE mode
+	res, err = client.WithGovernanceBypass().DeleteObjects([]s3.ObjectIdentifier{{Key: "governance.txt", VersionID: "v1"}, {Key: "compliance.txt", VersionID: "v2"}})
+	assert.NoErr(t, err)
+	assert.Equal(t, 1, len(res.Error))
+	assert.Equal(t, "compliance.txt", res.Error[0].Key)
+	assert.Equal(t, "Object is locked", res.Error[0].Message)
+	assert.Equal(t, 0, len(sv.GetVersions("governance.txt")))
+	assert.Equal(t, 1, len(sv.GetVersions("compliance.txt")))
+}
+
+func TestShortenRetention(t *testing.T) {
+	sv, client, now := setupLockServer(t)
+
+	err := client.PutObject("governance.txt", bytes.NewReader([]byte("abc")), 3, &s3.ObjectLockRetention{Mode: s3.LockModeGovernance, Until: now.Add(time.Hour)})
+	assert.NoErr(t, err)
+	err = client.PutObject("compliance.txt", bytes.NewReader([]byte("abc")), 3, &s3.ObjectLockRetention{Mode: s3.LockModeCompliance, Until: now.Add(time.Hour)})
+	assert.NoErr(t, err)
+
+	// locks can always be extended
+	err = client.PutObjectRetention("compliance.txt", &s3.ObjectLockRetention{Mode: s3.LockModeCompliance, Until: now.Add(2 * time.Hour)})
+	assert.NoErr(t, err)
+
+	// but not shortened or weakened
+	err = client.PutObjectRetention("compliance.txt", &s3.ObjectLockRetention{Mode: s3.LockModeCompliance, Until: now.Add(time.Minute)})
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeAccessDenied))
+	err = client.WithGovernanceBypass().PutObjectRetention("compliance.txt", &s3.ObjectLockRetention{Mode: s3.LockModeGovernance, Until: now.Add(2 * time.Hour)})
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeAccessDenied))
+	assert.Equal(t, now.Add(2*time.Hour), sv.GetVersions("compliance.txt")[0].Retention.Until)
+
+	// unless they are GOVERNANCE mode locks and the client bypasses governance
+	err = client.PutObjectRetention("governance.txt", &s3.ObjectLockRetention{Mode: s3.LockModeGovernance, Until: now.Add(time.Minute)})
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeAccessDenied))
+	err = client.WithGovernanceBypass().PutObjectRetention("governance.txt", &s3.ObjectLockRetention{Mode: s3.LockModeGovernance, Until: now.Add(time.Minute)})
+	assert.NoErr(t, err)
+	assert.Equal(t, now.Add(time.Minute), sv.GetVersions("governance.txt")[0].Retention.Until)
+}
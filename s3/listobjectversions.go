@@ -2,17 +2,20 @@ package s3
 
 import (
 	"bytes"
+	"context"
 	"encoding/xml"
 	"fmt"
-	"io"
+	"iter"
 	"net/http"
 	"net/url"
 )
 
 type VersionInfo struct {
-	Key       string `xml:"Key"`
-	VersionId string `xml:"VersionId"`
-	IsLatest  bool   `xml:"IsLatest"`
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	Size         int64  `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
 }
 
 type DeleteMarker struct {
@@ -44,7 +47,71 @@ type CommonPrefix struct {
 	Prefix string `xml:"Prefix"`
 }
 
+// ListedVersion is either an object version or a delete marker returned by ListAllObjectVersions.
+type ListedVersion struct {
+	Key          string
+	VersionId    string
+	IsLatest     bool
+	DeleteMarker bool
+
+	// Size and StorageClass are only set for object versions.
+	Size         int64
+	StorageClass string
+}
+
+const listObjectVersionsPageSize = 1000
+
+// ListAllObjectVersions iterates over every object version and delete marker under prefix,
+// requesting further pages as needed. Iteration stops after the first error.
+func (c *Client) ListAllObjectVersions(prefix string) iter.Seq2[ListedVersion, error] {
+	return c.ListAllObjectVersionsContext(context.Background(), prefix)
+}
+
+func (c *Client) ListAllObjectVersionsContext(ctx context.Context, prefix string) iter.Seq2[ListedVersion, error] {
+	return func(yield func(ListedVersion, error) bool) {
+		keyMarker := ""
+		versionIdMarker := ""
+
+		for {
+			page, err := c.ListObjectVersionsContext(ctx, prefix, keyMarker, versionIdMarker, listObjectVersionsPageSize)
+			if err != nil {
+				yield(ListedVersion{}, err)
+				return
+			}
+
+			for _, version := range page.Versions {
+				listed := ListedVersion{Key: version.Key, VersionId: version.VersionId, IsLatest: version.IsLatest, Size: version.Size, StorageClass: version.StorageClass}
+				if !yield(listed, nil) {
+					return
+				}
+			}
+			for _, marker := range page.DeleteMarkers {
+				if !yield(ListedVersion{Key: marker.Key, VersionId: marker.VersionId, IsLatest: marker.IsLatest, DeleteMarker: true}, nil) {
+					return
+				}
+			}
+
+			if !page.IsTruncated {
+				return
+			}
+
+			// Guard against looping forever on a server that does not return markers.
+			if page.NextKeyMarker == "" || (page.NextKeyMarker == keyMarker && page.NextVersionIdMarker == versionIdMarker) {
+				yield(ListedVersion{}, fmt.Errorf("ListObjectVersions is truncated but did not advance the key marker"))
+				return
+			}
+
+			keyMarker = page.NextKeyMarker
+			versionIdMarker = page.NextVersionIdMarker
+		}
+	}
+}
+
 func (c *Client) ListObjectVersions(prefix, keyMarker, versionIdMarker string, maxKeys int) (*ListObjectVersionsResult, error) {
+	return c.ListObjectVersionsContext(context.Background(), prefix, keyMarker, versionIdMarker, maxKeys)
+}
+
+func (c *Client) ListObjectVersionsContext(ctx context.Context, prefix, keyMarker, versionIdMarker string, maxKeys int) (*ListObjectVersionsResult, error) {
 	query := url.Values{}
 	query.Set("versions", "")
 
@@ -61,10 +128,13 @@ func (c *Client) ListObjectVersions(prefix, keyMarker, versionIdMarker string, m
 		query.Set("max-keys", fmt.Sprintf("%d", maxKeys))
 	}
 
-	reqURL := c.buildURL("", query)
+	reqURL, err := c.buildURL("", query)
+	if err != nil {
+		return nil, err
+	}
 
-	return withRetries(func() (*ListObjectVersionsResult, error) {
-		req, err := http.NewRequest(http.MethodGet, reqURL, nil)
+	return withRetries(ctx, c, "ListObjectVersions", func() (*ListObjectVersionsResult, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
 		if err != nil {
 			return nil, err
 		}
@@ -75,19 +145,12 @@ func (c *Client) ListObjectVersions(prefix, keyMarker, versionIdMarker string, m
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return nil, err
+			return nil, transportError(err)
 		}
 		defer func() { _ = resp.Body.Close() }()
 
 		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			err := fmt.Errorf("ListObjectVersions failed with status: %s, response: %s", resp.Status, string(body))
-
-			if resp.StatusCode >= 500 {
-				return nil, retriableError{err}
-			} else {
-				return nil, err
-			}
+			return nil, responseError("ListObjectVersions", resp)
 		}
 
 		result := &ListObjectVersionsResult{}
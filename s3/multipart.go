@@ -0,0 +1,493 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMultipartPartSize    = 16 * 1024 * 1024
+	DefaultMultipartConcurrency = 4
+
+	// MinMultipartPartSize is the smallest part S3 accepts, other than the last part of an upload.
+	MinMultipartPartSize = 5 * 1024 * 1024
+
+	maxMultipartParts    = 10000
+	maxMultipartPartSize = 5 * 1024 * 1024 * 1024
+
+	// streamPartGrowth is how many parts of a streamed upload are sent before the part size
+	// doubles. Parts start at the part size of the Client, so a stream can grow to 1023000 times
+	// that size before it runs out of part numbers.
+	streamPartGrowth = 1000
+)
+
+// streamPartSize is the size of part partNumber of a streamed upload, whose length is not known
+// up front.
+func (c *Client) streamPartSize(partNumber int) int64 {
+	return min(c.partSize<<((partNumber-1)/streamPartGrowth), max(c.partSize, maxMultipartPartSize))
+}
+
+type InitiateMultipartUploadResult struct {
+	Bucket   string `xml:"Bucket"`
+	Key      string `xml:"Key"`
+	UploadID string `xml:"UploadId"`
+}
+
+type CompletedPart struct {
+	PartNumber     int    `xml:"PartNumber"`
+	ETag           string `xml:"ETag"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256,omitempty"`
+}
+
+type CompleteMultipartUploadRequest struct {
+	XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+	Parts   []CompletedPart `xml:"Part"`
+}
+
+func (c *Client) CreateMultipartUpload(key string, retention *ObjectLockRetention) (string, error) {
+	return c.CreateMultipartUploadContext(context.Background(), key, retention)
+}
+
+func (c *Client) CreateMultipartUploadContext(ctx context.Context, key string, retention *ObjectLockRetention) (string, error) {
+	query := url.Values{}
+	query.Set("uploads", "")
+	reqURL, err := c.buildURL(key, query)
+	if err != nil {
+		return "", err
+	}
+
+	return withRetries(ctx, c, "CreateMultipartUpload", func() (string, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, nil)
+		if err != nil {
+			return "", err
+		}
+
+		req.Header.Set("Content-Type", "application/octet-stream")
+
+		if retention != nil {
+			req.Header.Set("x-amz-object-lock-mode", retention.Mode)
+			req.Header.Set("x-amz-object-lock-retain-until-date", retention.Until.Format(time.RFC3339))
+		}
+
+		if c.storageClass != "" {
+			req.Header.Set("x-amz-storage-class", c.storageClass)
+		}
+
+		c.encryption.setHeaders(req.Header)
+		c.setMetadataHeaders(req.Header)
+
+		// every part is then sent with its checksum
+		req.Header.Set("x-amz-checksum-algorithm", "SHA256")
+
+		if err := c.signV4(req, bytes.NewReader(nil)); err != nil {
+			return "", err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return "", transportError(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusOK {
+			return "", responseError("CreateMultipartUpload", resp)
+		}
+
+		result := &InitiateMultipartUploadResult{}
+		if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+			return "", fmt.Errorf("failed to parse CreateMultipartUpload XML: %v", err)
+		}
+
+		return result.UploadID, nil
+	})
+}
+
+// UploadPart uploads a single part of a multipart upload and returns it for completing the upload.
+func (c *Client) UploadPart(key, uploadID string, partNumber int, data []byte) (CompletedPart, error) {
+	return c.UploadPartContext(context.Background(), key, uploadID, partNumber, data)
+}
+
+func (c *Client) UploadPartContext(ctx context.Context, key, uploadID string, partNumber int, data []byte) (CompletedPart, error) {
+	query := url.Values{}
+	query.Set("partNumber", strconv.Itoa(partNumber))
+	query.Set("uploadId", uploadID)
+	reqURL, err := c.buildURL(key, query)
+	if err != nil {
+		return CompletedPart{}, err
+	}
+
+	checksum := checksumSHA256(data)
+
+	return withRetries(ctx, c, "UploadPart", func() (CompletedPart, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, bytes.NewReader(data))
+		if err != nil {
+			return CompletedPart{}, err
+		}
+
+		req.Header.Set("Content-Type", "application/octet-stream")
+		req.ContentLength = int64(len(data))
+		req.Header.Set("Content-MD5", getMD5Sum(data))
+		req.Header.Set("x-amz-checksum-sha256", checksum)
+		c.encryption.setCustomerKeyHeaders(req.Header)
+
+		if err := c.signV4(req, bytes.NewReader(data)); err != nil {
+			return CompletedPart{}, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return CompletedPart{}, transportError(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusOK {
+			return CompletedPart{}, responseError("UploadPart", resp)
+		}
+
+		return CompletedPart{PartNumber: partNumber, ETag: resp.Header.Get("ETag"), ChecksumSHA256: checksum}, nil
+	})
+}
+
+func (c *Client) CompleteMultipartUpload(key, uploadID string, parts []CompletedPart) error {
+	return c.CompleteMultipartUploadContext(context.Background(), key, uploadID, parts)
+}
+
+func (c *Client) CompleteMultipartUploadContext(ctx context.Context, key, uploadID string, parts []CompletedPart) error {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	reqURL, err := c.buildURL(key, query)
+	if err != nil {
+		return err
+	}
+
+	data, err := xml.Marshal(CompleteMultipartUploadRequest{Parts: parts})
+	if err != nil {
+		return err
+	}
+
+	// Parts uploaded without checksums cannot be checked.
+	expectedChecksum := ""
+	if !slices.ContainsFunc(parts, func(part CompletedPart) bool { return part.ChecksumSHA256 == "" }) {
+		expectedChecksum, err = compositeChecksum(parts)
+		if err != nil {
+			return err
+		}
+	}
+
+	_, err = withRetries(ctx, c, "CompleteMultipartUpload", func() (struct{}, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, bytes.NewReader(data))
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		req.Header.Set("Content-Type", "application/xml")
+		req.ContentLength = int64(len(data))
+		// S3 needs the key to compute the checksum of the object.
+		c.encryption.setCustomerKeyHeaders(req.Header)
+
+		if err := c.signV4(req, bytes.NewReader(data)); err != nil {
+			return struct{}{}, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return struct{}{}, transportError(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		body, _ := io.ReadAll(resp.Body)
+
+		if resp.StatusCode != http.StatusOK {
+			return struct{}{}, bodyError("CompleteMultipartUpload", resp, body)
+		}
+
+		// S3 may report a failure in the body of a 200 response.
+		var result struct {
+			XMLName        xml.Name
+			ChecksumSHA256 string `xml:"ChecksumSHA256"`
+		}
+		if err := xml.Unmarshal(body, &result); err != nil {
+			return struct{}{}, fmt.Errorf("failed to parse CompleteMultipartUpload XML: %v", err)
+		}
+		if result.XMLName.Local == "Error" {
+			return struct{}{}, bodyError("CompleteMultipartUpload", resp, body)
+		}
+
+		// Servers without checksum support leave it out.
+		if result.ChecksumSHA256 != "" && expectedChecksum != "" && result.ChecksumSHA256 != expectedChecksum {
+			return struct{}{}, fmt.Errorf("%w: %s has sha256 %s, expected %s", ErrChecksumMismatch, key, result.ChecksumSHA256, expectedChecksum)
+		}
+
+		return struct{}{}, nil
+	})
+
+	return err
+}
+
+func (c *Client) AbortMultipartUpload(key, uploadID string) error {
+	return c.AbortMultipartUploadContext(context.Background(), key, uploadID)
+}
+
+func (c *Client) AbortMultipartUploadContext(ctx context.Context, key, uploadID string) error {
+	query := url.Values{}
+	query.Set("uploadId", uploadID)
+	reqURL, err := c.buildURL(key, query)
+	if err != nil {
+		return err
+	}
+
+	_, err = withRetries(ctx, c, "AbortMultipartUpload", func() (struct{}, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodDelete, reqURL, nil)
+		if err != nil {
+			return struct{}{}, err
+		}
+
+		if err := c.signV4(req, bytes.NewReader(nil)); err != nil {
+			return struct{}{}, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return struct{}{}, transportError(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+			return struct{}{}, responseError("AbortMultipartUpload", resp)
+		}
+
+		return struct{}{}, nil
+	})
+
+	return err
+}
+
+// PutObjectMultipart uploads data as a multipart upload, sending up to the configured number of
+// parts in parallel. Parts are made larger than the configured size if data would otherwise need
+// more parts than S3 allows. The upload is aborted if any part fails.
+func (c *Client) PutObjectMultipart(key string, data io.ReaderAt, dataLength int64, retention *ObjectLockRetention) error {
+	return c.PutObjectMultipartContext(context.Background(), key, data, dataLength, retention)
+}
+
+func (c *Client) PutObjectMultipartContext(ctx context.Context, key string, data io.ReaderAt, dataLength int64, retention *ObjectLockRetention) error {
+	partSize := max(c.partSize, (dataLength+maxMultipartParts-1)/maxMultipartParts)
+	partCount := max(1, int((dataLength+partSize-1)/partSize))
+
+	uploadID, err := c.CreateMultipartUploadContext(ctx, key, retention)
+	if err != nil {
+		return err
+	}
+
+	parts, err := c.uploadParts(ctx, key, uploadID, data, dataLength, partSize, partCount)
+	if err == nil {
+		err = c.CompleteMultipartUploadContext(ctx, key, uploadID, parts)
+	}
+
+	// Abort even if ctx was cancelled, so the uploaded parts are not left behind.
+	if err != nil {
+		if abortErr := c.AbortMultipartUploadContext(context.WithoutCancel(ctx), key, uploadID); abortErr != nil {
+			return errors.Join(err, fmt.Errorf("abort multipart upload: %w", abortErr))
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (c *Client) uploadParts(ctx context.Context, key, uploadID string, data io.ReaderAt, dataLength, partSize int64, partCount int) ([]CompletedPart, error) {
+	parts := make([]CompletedPart, partCount)
+
+	var mu sync.Mutex
+	var uploadErr error
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploadErr != nil
+	}
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if uploadErr == nil {
+			uploadErr = err
+		}
+	}
+
+	partIndexes := make(chan int)
+	var wg sync.WaitGroup
+	for range min(c.concurrency, partCount) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// Each worker reuses one buffer, bounding memory to partSize * concurrency.
+			buffer := make([]byte, partSize)
+			for i := range partIndexes {
+				offset := int64(i) * partSize
+				part := buffer[:min(partSize, dataLength-offset)]
+
+				n, err := data.ReadAt(part, offset)
+				if err != nil && !(errors.Is(err, io.EOF) && n == len(part)) {
+					fail(fmt.Errorf("read part %d: %w", i+1, err))
+					continue
+				}
+
+				completed, err := c.UploadPartContext(ctx, key, uploadID, i+1, part)
+				if err != nil {
+					fail(fmt.Errorf("upload part %d: %w", i+1, err))
+					continue
+				}
+
+				parts[i] = completed
+			}
+		}()
+	}
+
+	for i := range partCount {
+		if failed() {
+			break
+		}
+		partIndexes <- i
+	}
+	close(partIndexes)
+	wg.Wait()
+
+	return parts, uploadErr
+}
+
+// PutObjectStream uploads everything read from data and returns the number of bytes uploaded.
+// Data that fits in a single part is sent with PutObject, anything larger is sent as a multipart
+// upload. At most one part per concurrent upload is held in memory. As the length of data is not
+// known, the part size doubles every 1000 parts, so long streams use larger parts and more memory
+// instead of running out of part numbers.
+func (c *Client) PutObjectStream(key string, data io.Reader, retention *ObjectLockRetention) (int64, error) {
+	return c.PutObjectStreamContext(context.Background(), key, data, retention)
+}
+
+func (c *Client) PutObjectStreamContext(ctx context.Context, key string, data io.Reader, retention *ObjectLockRetention) (int64, error) {
+	first := make([]byte, c.partSize)
+	n, err := io.ReadFull(data, first)
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return int64(n), c.PutObjectContext(ctx, key, bytes.NewReader(first[:n]), int64(n), retention)
+	}
+	if err != nil {
+		return 0, fmt.Errorf("read part 1: %w", err)
+	}
+
+	uploadID, err := c.CreateMultipartUploadContext(ctx, key, retention)
+	if err != nil {
+		return 0, err
+	}
+
+	parts, size, err := c.streamParts(ctx, key, uploadID, first, data)
+	if err == nil {
+		err = c.CompleteMultipartUploadContext(ctx, key, uploadID, parts)
+	}
+
+	if err != nil {
+		if abortErr := c.AbortMultipartUploadContext(context.WithoutCancel(ctx), key, uploadID); abortErr != nil {
+			return 0, errors.Join(err, fmt.Errorf("abort multipart upload: %w", abortErr))
+		}
+		return 0, err
+	}
+
+	return size, nil
+}
+
+func (c *Client) streamParts(ctx context.Context, key, uploadID string, first []byte, data io.Reader) ([]CompletedPart, int64, error) {
+	var mu sync.Mutex
+	var uploadErr error
+	parts := []CompletedPart{}
+	fail := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if uploadErr == nil {
+			uploadErr = err
+		}
+	}
+	failed := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return uploadErr != nil
+	}
+
+	// Buffers are handed back once their part is uploaded, so no more than concurrency buffers
+	// are ever allocated.
+	free := make(chan []byte, c.concurrency)
+	allocated := 1
+	nextBuffer := func(size int64) []byte {
+		var buffer []byte
+		select {
+		case buffer = <-free:
+		default:
+			if allocated < c.concurrency {
+				allocated++
+				return make([]byte, size)
+			}
+			buffer = <-free
+		}
+		// Buffers of an earlier, smaller part size are replaced.
+		if int64(len(buffer)) < size {
+			return make([]byte, size)
+		}
+		return buffer[:size]
+	}
+
+	var wg sync.WaitGroup
+	buffer, n := first, len(first)
+	size := int64(0)
+	for partNumber := 1; ; partNumber++ {
+		if partNumber > maxMultipartParts {
+			fail(fmt.Errorf("more than %d parts, %d bytes read", maxMultipartParts, size))
+			break
+		}
+
+		size += int64(n)
+		wg.Add(1)
+		go func(partNumber int, buffer []byte, n int) {
+			defer wg.Done()
+			defer func() { free <- buffer }()
+
+			completed, err := c.UploadPartContext(ctx, key, uploadID, partNumber, buffer[:n])
+			if err != nil {
+				fail(fmt.Errorf("upload part %d: %w", partNumber, err))
+				return
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			parts = append(parts, completed)
+		}(partNumber, buffer, n)
+
+		if n < len(buffer) || failed() {
+			break
+		}
+
+		buffer = nextBuffer(c.streamPartSize(partNumber + 1))
+		var err error
+		n, err = io.ReadFull(data, buffer)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) {
+			fail(fmt.Errorf("read part %d: %w", partNumber+1, err))
+			break
+		}
+	}
+	wg.Wait()
+
+	slices.SortFunc(parts, func(a, b CompletedPart) int {
+		return a.PartNumber - b.PartNumber
+	})
+
+	return parts, size, uploadErr
+}
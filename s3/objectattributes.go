@@ -0,0 +1,94 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+// ObjectPart is a part of an object that was uploaded with a multipart upload.
+type ObjectPart struct {
+	PartNumber     int    `xml:"PartNumber"`
+	Size           int64  `xml:"Size"`
+	ChecksumSHA256 string `xml:"ChecksumSHA256"`
+}
+
+type getObjectAttributesResult struct {
+	ObjectParts struct {
+		IsTruncated          bool         `xml:"IsTruncated"`
+		NextPartNumberMarker int          `xml:"NextPartNumberMarker"`
+		Parts                []ObjectPart `xml:"Part"`
+	} `xml:"ObjectParts"`
+}
+
+// GetObjectParts lists the parts of a version of key, or of the latest version if versionID is
+// empty, using GetObjectAttributes. Objects that were not uploaded in parts have none.
+func (c *Client) GetObjectParts(key, versionID string) ([]ObjectPart, error) {
+	return c.GetObjectPartsContext(context.Background(), key, versionID)
+}
+
+func (c *Client) GetObjectPartsContext(ctx context.Context, key, versionID string) ([]ObjectPart, error) {
+	parts := []ObjectPart{}
+	marker := 0
+	for {
+		query := url.Values{}
+		query.Set("attributes", "")
+		if versionID != "" {
+			query.Set("versionId", versionID)
+		}
+		reqURL, err := c.buildURL(key, query)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := withRetries(ctx, c, "GetObjectAttributes", func() (*getObjectAttributesResult, error) {
+			req, err := http.NewRequestWithContext(ctx, http.MethodGet, reqURL, nil)
+			if err != nil {
+				return nil, err
+			}
+
+			req.Header.Set("x-amz-object-attributes", "ObjectParts")
+			if marker > 0 {
+				req.Header.Set("x-amz-part-number-marker", strconv.Itoa(marker))
+			}
+			c.encryption.setCustomerKeyHeaders(req.Header)
+
+			if err := c.signV4(req, bytes.NewReader(nil)); err != nil {
+				return nil, err
+			}
+
+			resp, err := c.httpClient.Do(req)
+			if err != nil {
+				return nil, transportError(err)
+			}
+			defer func() { _ = resp.Body.Close() }()
+
+			if resp.StatusCode != http.StatusOK {
+				return nil, responseError("GetObjectAttributes", resp)
+			}
+
+			result := &getObjectAttributesResult{}
+			if err := xml.NewDecoder(resp.Body).Decode(result); err != nil {
+				return nil, fmt.Errorf("failed to parse GetObjectAttributes XML: %v", err)
+			}
+
+			return result, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		parts = append(parts, result.ObjectParts.Parts...)
+		if !result.ObjectParts.IsTruncated {
+			return parts, nil
+		}
+		if result.ObjectParts.NextPartNumberMarker <= marker {
+			return nil, fmt.Errorf("GetObjectAttributes did not advance past part %d", marker)
+		}
+		marker = result.ObjectParts.NextPartNumberMarker
+	}
+}
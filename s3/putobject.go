@@ -1,24 +1,32 @@
 package s3
 
 import (
+	"context"
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/base64"
-	"fmt"
 	"io"
 	"net/http"
 	"time"
 )
 
 func (c *Client) PutObject(key string, data io.ReadSeeker, dataLength int64, retention *ObjectLockRetention) error {
-	reqURL := c.buildURL(key, nil)
+	return c.PutObjectContext(context.Background(), key, data, dataLength, retention)
+}
+
+func (c *Client) PutObjectContext(ctx context.Context, key string, data io.ReadSeeker, dataLength int64, retention *ObjectLockRetention) error {
+	reqURL, err := c.buildURL(key, nil)
+	if err != nil {
+		return err
+	}
 
-	_, err := withRetries(func() (struct{}, error) {
+	_, err = withRetries(ctx, c, "PutObject", func() (struct{}, error) {
 		// always reset data reader at the start
 		if _, err := data.Seek(0, io.SeekStart); err != nil {
 			return struct{}{}, err
 		}
 
-		req, err := http.NewRequest(http.MethodPut, reqURL, data)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, data)
 		if err != nil {
 			return struct{}{}, err
 		}
@@ -35,9 +43,13 @@ func (c *Client) PutObject(key string, data io.ReadSeeker, dataLength int64, ret
 			req.Header.Set("x-amz-storage-class", c.storageClass)
 		}
 
-		// compute md5 hash
+		c.encryption.setHeaders(req.Header)
+		c.setMetadataHeaders(req.Header)
+
+		// compute md5 and sha256 hashes, S3 rejects the upload if either does not match
 		hash := md5.New()
-		if _, err := io.Copy(hash, data); err != nil {
+		checksum := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(hash, checksum), data); err != nil {
 			return struct{}{}, err
 		}
 		hashSum := hash.Sum(nil)
@@ -46,6 +58,7 @@ func (c *Client) PutObject(key string, data io.ReadSeeker, dataLength int64, ret
 		}
 
 		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(hashSum[:]))
+		req.Header.Set("x-amz-checksum-sha256", base64.StdEncoding.EncodeToString(checksum.Sum(nil)))
 
 		// sign and send request
 		if err := c.signV4(req, data); err != nil {
@@ -54,19 +67,12 @@ func (c *Client) PutObject(key string, data io.ReadSeeker, dataLength int64, ret
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return struct{}{}, err
+			return struct{}{}, transportError(err)
 		}
 		defer func() { _ = resp.Body.Close() }()
 
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
-			body, _ := io.ReadAll(resp.Body)
-			err := fmt.Errorf("PutObject failed with status: %s, response: %s", resp.Status, string(body))
-
-			if resp.StatusCode >= 500 {
-				return struct{}{}, retriableError{err}
-			} else {
-				return struct{}{}, err
-			}
+			return struct{}{}, responseError("PutObject", resp)
 		}
 
 		return struct{}{}, nil
@@ -0,0 +1,81 @@
+package s3
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Retrieval tiers of RestoreObject, from the fastest to the cheapest. Expedited retrieval is not
+// available for DEEP_ARCHIVE.
+const (
+	RestoreTierExpedited = "Expedited"
+	RestoreTierStandard  = "Standard"
+	RestoreTierBulk      = "Bulk"
+)
+
+// archivedStorageClasses cannot be read or copied until a restored copy has been made.
+var archivedStorageClasses = []string{"GLACIER", "DEEP_ARCHIVE"}
+
+// IsArchivedStorageClass reports whether objects in storageClass must be restored with
+// RestoreObject before they can be read.
+func IsArchivedStorageClass(storageClass string) bool {
+	return slices.Contains(archivedStorageClasses, storageClass)
+}
+
+// RestoreObject starts making a temporary copy of the latest version of an archived object, which
+// can be read once it is ready and is kept for days. Use HeadObject to see when it is ready. If a
+// restore is already in progress, the error has the code ErrCodeRestoreAlreadyInProgress. Restoring
+// an object that has already been restored extends how long the copy is kept.
+func (c *Client) RestoreObject(key string, days int, tier string) error {
+	return c.RestoreObjectContext(context.Background(), key, days, tier)
+}
+
+func (c *Client) RestoreObjectContext(ctx context.Context, key string, days int, tier string) error {
+	query := url.Values{}
+	query.Set("restore", "")
+	reqURL, err := c.buildURL(key, query)
+	if err != nil {
+		return err
+	}
+
+	restoreXML := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
+<RestoreRequest xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
+  <Days>%d</Days>
+  <GlacierJobParameters>
+    <Tier>%s</Tier>
+  </GlacierJobParameters>
+</RestoreRequest>`, days, tier)
+
+	_, err = withRetries(ctx, c, "RestoreObject", func() (any, error) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, reqURL, strings.NewReader(restoreXML))
+		if err != nil {
+			return nil, err
+		}
+
+		req.Header.Set("Content-Type", "application/xml")
+		req.ContentLength = int64(len(restoreXML))
+
+		if err := c.signV4(req, strings.NewReader(restoreXML)); err != nil {
+			return nil, err
+		}
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return nil, transportError(err)
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		// 202 starts a restore, 200 extends a copy that is already there.
+		if resp.StatusCode != http.StatusAccepted && resp.StatusCode != http.StatusOK {
+			return nil, responseError("RestoreObject", resp)
+		}
+
+		return nil, nil
+	})
+
+	return err
+}
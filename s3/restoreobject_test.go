@@ -0,0 +1,148 @@
+package s3_test
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+func TestRestoreArchivedObject(t *testing.T) {
+	sv := setupSignedServer(t, "")
+	now := time.Date(2025, time.March, 5, 3, 0, 0, 0, time.UTC)
+	sv.SetNow(now)
+
+	client := s3.NewClient(s3.Config{
+		URL:          sv.GetEndpoint(),
+		Region:       "my-region",
+		KeyID:        "keyid",
+		KeySecret:    "shh",
+		Bucket:       "my-bucket",
+		Insecure:     true,
+		StorageClass: "DEEP_ARCHIVE",
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// archived objects cannot be read
+	_, err = client.GetObject("my-file.txt")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeInvalidObjectState))
+
+	head, err := client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, int64(3), head.ContentLength)
+	assert.Equal(t, "DEEP_ARCHIVE", head.StorageClass)
+	assert.Equal(t, nil, head.Restore)
+	assert.True(t, head.NeedsRestore())
+
+	// start the restore
+	err = client.RestoreObject("my-file.txt", 2, s3.RestoreTierBulk)
+	assert.NoErr(t, err)
+
+	head, err = client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.RestoreStatus{Ongoing: true}, *head.Restore)
+	assert.True(t, head.NeedsRestore())
+
+	err = client.RestoreObject("my-file.txt", 2, s3.RestoreTierBulk)
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeRestoreAlreadyInProgress))
+
+	// the restored copy can be read once it is ready
+	ready := now.Add(4 * time.Hour)
+	sv.SetNow(ready)
+
+	head, err = client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.RestoreStatus{Expires: ready.Add(48 * time.Hour)}, *head.Restore)
+	assert.True(t, !head.NeedsRestore())
+
+	object, err := client.GetObject("my-file.txt")
+	assert.NoErr(t, err)
+	data, err := io.ReadAll(object.Body)
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+	assert.Equal(t, "abc", string(data))
+
+	// restoring again extends the copy
+	err = client.RestoreObject("my-file.txt", 3, s3.RestoreTierBulk)
+	assert.NoErr(t, err)
+	head, err = client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, ready.Add(72*time.Hour), head.Restore.Expires)
+
+	// and it is gone once it expires
+	sv.SetNow(ready.Add(73 * time.Hour))
+	_, err = client.GetObject("my-file.txt")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeInvalidObjectState))
+}
+
+func TestRestoreObjectErrors(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// objects that are not archived never need restoring
+	head, err := client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, "", head.StorageClass)
+	assert.True(t, !head.NeedsRestore())
+
+	err = client.RestoreObject("my-file.txt", 1, s3.RestoreTierStandard)
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeInvalidObjectState))
+
+	// HEAD has no error body, missing objects are told by the status
+	_, err = client.HeadObject("missing.txt")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchKey))
+	err = client.RestoreObject("missing.txt", 1, s3.RestoreTierStandard)
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchKey))
+}
+
+func TestParsesRestoreHeader(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	header := ""
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		w.Header().Set("x-amz-storage-class", "GLACIER")
+		w.Header().Set("x-amz-restore", header)
+		w.WriteHeader(http.StatusOK)
+		return true
+	})
+
+	header = `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`
+	head, err := client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.RestoreStatus{Expires: time.Date(2012, time.December, 21, 0, 0, 0, 0, time.UTC)}, *head.Restore)
+
+	header = `ongoing-request="true"`
+	head, err = client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.RestoreStatus{Ongoing: true}, *head.Restore)
+
+	header = `ongoing-request=true`
+	_, err = client.HeadObject("my-file.txt")
+	assert.ErrContains(t, err, "parse x-amz-restore header")
+}
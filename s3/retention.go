@@ -1,25 +1,42 @@
 package s3
 
 import (
+	"context"
 	"crypto/md5"
 	"encoding/base64"
 	"fmt"
-	"io"
 	"net/http"
 	"net/url"
 	"strings"
 	"time"
 )
 
+// Object lock modes. Versions locked in GOVERNANCE mode can be deleted early by clients from
+// WithGovernanceBypass. Nobody can delete versions locked in COMPLIANCE mode until they expire.
+const (
+	LockModeGovernance = "GOVERNANCE"
+	LockModeCompliance = "COMPLIANCE"
+)
+
 type ObjectLockRetention struct {
 	Mode  string // GOVERNANCE or COMPLIANCE
 	Until time.Time
 }
 
+// PutObjectRetention locks the latest version of key until retention.Until. An active lock can
+// always be extended. Shortening it or changing its mode is only possible for GOVERNANCE mode
+// locks, with a client from WithGovernanceBypass.
 func (c *Client) PutObjectRetention(key string, retention *ObjectLockRetention) error {
+	return c.PutObjectRetentionContext(context.Background(), key, retention)
+}
+
+func (c *Client) PutObjectRetentionContext(ctx context.Context, key string, retention *ObjectLockRetention) error {
 	query := url.Values{}
 	query.Set("retention", "")
-	reqURL := c.buildURL(key, query)
+	reqURL, err := c.buildURL(key, query)
+	if err != nil {
+		return err
+	}
 
 	retentionXML := fmt.Sprintf(`<?xml version="1.0" encoding="UTF-8"?>
 <Retention xmlns="http://s3.amazonaws.com/doc/2006-03-01/">
@@ -27,10 +44,10 @@ func (c *Client) PutObjectRetention(key string, retention *ObjectLockRetention)
   <RetainUntilDate>%s</RetainUntilDate>
 </Retention>`, retention.Mode, retention.Until.Format(time.RFC3339))
 
-	_, err := withRetries(func() (any, error) {
+	_, err = withRetries(ctx, c, "PutObjectRetention", func() (any, error) {
 		bodyReader := strings.NewReader(retentionXML)
 
-		req, err := http.NewRequest(http.MethodPut, reqURL, bodyReader)
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, reqURL, bodyReader)
 		if err != nil {
 			return nil, err
 		}
@@ -40,6 +57,8 @@ func (c *Client) PutObjectRetention(key string, retention *ObjectLockRetention)
 
 		md5Sum := md5.Sum([]byte(retentionXML))
 		req.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(md5Sum[:]))
+		c.encryption.setCustomerKeyHeaders(req.Header)
+		c.setBypassGovernanceHeader(req.Header)
 
 		if err := c.signV4(req, strings.NewReader(retentionXML)); err != nil {
 			return nil, err
@@ -47,19 +66,12 @@ func (c *Client) PutObjectRetention(key string, retention *ObjectLockRetention)
 
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
-			return nil, err
+			return nil, transportError(err)
 		}
 		defer func() { _ = resp.Body.Close() }()
 
 		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
-			body, _ := io.ReadAll(resp.Body)
-			err := fmt.Errorf("PutObjectRetention failed with status: %s, response: %s", resp.Status, string(body))
-
-			if resp.StatusCode >= 500 {
-				return nil, retriableError{err}
-			} else {
-				return nil, err
-			}
+			return nil, responseError("PutObjectRetention", resp)
 		}
 
 		return nil, nil
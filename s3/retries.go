@@ -1,15 +1,97 @@
 package s3
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
+	"log/slog"
 	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"sync/atomic"
 	"testing"
 	"time"
 )
 
+const (
+	DefaultRetryMaxAttempts = 10
+	DefaultRetryBaseDelay   = 100 * time.Millisecond
+	DefaultRetryMaxDelay    = 20 * time.Second
+)
+
+// RetryPolicy controls how failed requests are retried. Zero values use the defaults.
+type RetryPolicy struct {
+	// MaxAttempts is the most times a request is sent, including the first.
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry. It doubles with each retry after that.
+	BaseDelay time.Duration
+	// MaxDelay caps the delay between retries, except when S3 asks for longer with Retry-After.
+	MaxDelay time.Duration
+	// Budget is the most time spent on a request, including all of its retries. Zero means no
+	// limit other than MaxAttempts.
+	Budget time.Duration
+}
+
+func (p RetryPolicy) withDefaults() RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryMaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryBaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryMaxDelay
+	}
+	return p
+}
+
+// delay is how long to wait before retry number i, counting from zero.
+func (p RetryPolicy) delay(i int) time.Duration {
+	backoff := p.MaxDelay
+	if i < 32 && p.BaseDelay<<i > 0 && p.BaseDelay<<i < p.MaxDelay {
+		backoff = p.BaseDelay << i
+	}
+
+	// Half of the backoff is always waited, the rest is jitter so clients spread out.
+	half := backoff / 2
+	return half + time.Duration(rand.Int64N(int64(backoff-half)+1))
+}
+
+// Metrics counts the requests made by a Client since it was created.
+type Metrics struct {
+	// Requests is every request sent, including retries.
+	Requests int64
+	// Retries is the number of requests that were retries of an earlier failure.
+	Retries int64
+	// Throttled is the number of responses where S3 asked to slow down.
+	Throttled int64
+	// Exhausted is the number of operations that failed after running out of retries or budget.
+	Exhausted int64
+}
+
+type metrics struct {
+	requests  atomic.Int64
+	retries   atomic.Int64
+	throttled atomic.Int64
+	exhausted atomic.Int64
+}
+
+// Metrics returns the request counts of the client.
+func (c *Client) Metrics() Metrics {
+	return Metrics{
+		Requests:  c.metrics.requests.Load(),
+		Retries:   c.metrics.retries.Load(),
+		Throttled: c.metrics.throttled.Load(),
+		Exhausted: c.metrics.exhausted.Load(),
+	}
+}
+
 type retriableError struct {
 	err error
+	// retryAfter is how long S3 asked to wait before trying again, if it did.
+	retryAfter time.Duration
 }
 
 func (e retriableError) Error() string {
@@ -20,18 +102,63 @@ func (e retriableError) Unwrap() error {
 	return e.err
 }
 
-func withRetries[T any](do func() (T, error)) (T, error) {
-	maxTries := 10
-	i := 0
+// transportError marks an error from sending a request as retriable, unless trying again cannot
+// help, such as when the server certificate is not trusted.
+func transportError(err error) error {
+	var verifyErr *tls.CertificateVerificationError
+	var authorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	if errors.As(err, &verifyErr) || errors.As(err, &authorityErr) || errors.As(err, &hostnameErr) {
+		return err
+	}
+	return retriableError{err: err}
+}
+
+// parseRetryAfter reads a Retry-After header, which is either a number of seconds or a date.
+func parseRetryAfter(header http.Header) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0)
+	}
+	return 0
+}
+
+// withRetries calls do until it succeeds or returns an error that is not retriable. Retrying stops
+// as soon as ctx is done, or when the retry policy of c runs out of attempts or time.
+func withRetries[T any](ctx context.Context, c *Client, operation string, do func() (T, error)) (T, error) {
+	policy := c.retryPolicy
+	start := time.Now()
 
 	var result T
 	var err error
 	var retriable retriableError
-	for i < maxTries {
+	for i := 0; ; i++ {
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			if i == 0 {
+				return result, ctxErr
+			}
+			return result, fmt.Errorf("retries stopped: %w, last error: %w", ctxErr, retriable.Unwrap())
+		}
+
+		c.metrics.requests.Add(1)
+		if i > 0 {
+			c.metrics.retries.Add(1)
+		}
+
 		result, err = do()
 
 		// if there is no error just return
 		if err == nil {
+			if i > 0 {
+				slog.Info("request succeeded after retrying", "operation", operation, "retries", i)
+			}
 			return result, nil
 		}
 
@@ -40,15 +167,44 @@ func withRetries[T any](do func() (T, error)) (T, error) {
 			return result, err
 		}
 
-		backoff := time.Duration(100 * (1 << i))
-		jitter := time.Duration(rand.Int64N(int64(100)))
+		// a request cut short by ctx is not retried, the check above returns
+		if ctx.Err() != nil {
+			continue
+		}
+
+		if isThrottle(err) {
+			c.metrics.throttled.Add(1)
+		}
+
+		if i+1 >= policy.MaxAttempts {
+			c.metrics.exhausted.Add(1)
+			return result, fmt.Errorf("retries exceeded after %d attempts: %w", i+1, retriable.Unwrap())
+		}
+
+		delay := max(policy.delay(i), retriable.retryAfter)
+		if policy.Budget > 0 && time.Since(start)+delay > policy.Budget {
+			c.metrics.exhausted.Add(1)
+			return result, fmt.Errorf("retry budget of %s exceeded after %d attempts: %w", policy.Budget, i+1, retriable.Unwrap())
+		}
+
+		slog.Warn("retrying request", "operation", operation, "attempt", i+1, "delay", delay, "error", retriable.Unwrap())
 
 		// don't sleep in tests to keep them fast
 		if !testing.Testing() {
-			time.Sleep(backoff + jitter)
+			timer := time.NewTimer(delay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+			case <-timer.C:
+			}
 		}
-		i++
 	}
+}
 
-	return result, fmt.Errorf("retries exceeded: %w", retriable.Unwrap())
+func isThrottle(err error) bool {
+	var s3Err *Error
+	if !errors.As(err, &s3Err) {
+		return false
+	}
+	return s3Err.StatusCode == http.StatusTooManyRequests || s3Err.Code == ErrCodeSlowDown
 }
@@ -2,7 +2,14 @@ package s3_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
 	"net/http"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -38,13 +45,14 @@ func TestCanPutAndListObjects(t *testing.T) {
 	assert.Equal(t, 1, len(versions))
 
 	assert.Equal(t, versions[0], &fakes3.ObjectVersion{
-		Key:          "my-file.txt",
-		VersionID:    "v1",
-		Content:      []byte("abc"),
-		LastModified: now,
-		StorageClass: "STANDARD",
-		DeleteMarker: false,
-		Retention:    nil,
+		Key:            "my-file.txt",
+		VersionID:      "v1",
+		Content:        []byte("abc"),
+		LastModified:   now,
+		StorageClass:   "STANDARD",
+		DeleteMarker:   false,
+		Retention:      nil,
+		ChecksumSHA256: "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=",
 	})
 
 	// can list the version back out
@@ -55,9 +63,11 @@ func TestCanPutAndListObjects(t *testing.T) {
 	assert.Equal(t, 0, len(result.DeleteMarkers))
 	assert.Equal(t, 1, len(result.Versions))
 	assert.Equal(t, s3.VersionInfo{
-		Key:       "my-file.txt",
-		VersionId: "v1",
-		IsLatest:  true,
+		Key:          "my-file.txt",
+		VersionId:    "v1",
+		IsLatest:     true,
+		Size:         3,
+		StorageClass: "STANDARD",
 	}, result.Versions[0])
 }
 
@@ -104,13 +114,14 @@ func TestDeletion(t *testing.T) {
 	versions = sv.GetVersions("my-file.txt")
 	assert.Equal(t, 2, len(versions))
 	assert.Equal(t, versions[0], &fakes3.ObjectVersion{
-		Key:          "my-file.txt",
-		VersionID:    "v2",
-		Content:      []byte("abc"),
-		LastModified: now,
-		StorageClass: "STANDARD",
-		DeleteMarker: false,
-		Retention:    nil,
+		Key:            "my-file.txt",
+		VersionID:      "v2",
+		Content:        []byte("abc"),
+		LastModified:   now,
+		StorageClass:   "STANDARD",
+		DeleteMarker:   false,
+		Retention:      nil,
+		ChecksumSHA256: "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=",
 	})
 	assert.Equal(t, versions[1], &fakes3.ObjectVersion{
 		Key:          "my-file.txt",
@@ -133,9 +144,11 @@ func TestDeletion(t *testing.T) {
 	}, result.DeleteMarkers[0])
 	assert.Equal(t, 1, len(result.Versions))
 	assert.Equal(t, s3.VersionInfo{
-		Key:       "my-file.txt",
-		VersionId: "v2",
-		IsLatest:  false,
+		Key:          "my-file.txt",
+		VersionId:    "v2",
+		IsLatest:     false,
+		Size:         3,
+		StorageClass: "STANDARD",
 	}, result.Versions[0])
 
 	// try deleting wrong object (silently move on)
@@ -190,14 +203,18 @@ func TestMultipleVersions(t *testing.T) {
 	assert.Equal(t, 0, len(result.DeleteMarkers))
 	assert.Equal(t, 2, len(result.Versions))
 	assert.Equal(t, s3.VersionInfo{
-		Key:       "my-file.txt",
-		VersionId: "v2",
-		IsLatest:  true,
+		Key:          "my-file.txt",
+		VersionId:    "v2",
+		IsLatest:     true,
+		Size:         3,
+		StorageClass: "STANDARD",
 	}, result.Versions[0])
 	assert.Equal(t, s3.VersionInfo{
-		Key:       "my-file.txt",
-		VersionId: "v1",
-		IsLatest:  false,
+		Key:          "my-file.txt",
+		VersionId:    "v1",
+		IsLatest:     false,
+		Size:         3,
+		StorageClass: "STANDARD",
 	}, result.Versions[1])
 }
 
@@ -312,9 +329,11 @@ func TestObjectRetentionWithDeletionMarker(t *testing.T) {
 	}, result.DeleteMarkers[0])
 	assert.Equal(t, 1, len(result.Versions))
 	assert.Equal(t, s3.VersionInfo{
-		Key:       "my-file.txt",
-		VersionId: "v1",
-		IsLatest:  false,
+		Key:          "my-file.txt",
+		VersionId:    "v1",
+		IsLatest:     false,
+		Size:         3,
+		StorageClass: "STANDARD",
 	}, result.Versions[0])
 
 	// try to delete both versions of the file
@@ -331,9 +350,11 @@ func TestObjectRetentionWithDeletionMarker(t *testing.T) {
 	assert.Equal(t, 0, len(result.DeleteMarkers))
 	assert.Equal(t, 1, len(result.Versions))
 	assert.Equal(t, s3.VersionInfo{
-		Key:       "my-file.txt",
-		VersionId: "v1",
-		IsLatest:  true,
+		Key:          "my-file.txt",
+		VersionId:    "v1",
+		IsLatest:     true,
+		Size:         3,
+		StorageClass: "STANDARD",
 	}, result.Versions[0])
 
 	// wait two hours and delete again
@@ -390,13 +411,14 @@ func TestPutObjectDoesRetries(t *testing.T) {
 	assert.Equal(t, 1, len(versions))
 
 	assert.Equal(t, versions[0], &fakes3.ObjectVersion{
-		Key:          "my-file.txt",
-		VersionID:    "v1",
-		Content:      []byte("abc"),
-		LastModified: now,
-		StorageClass: "STANDARD",
-		DeleteMarker: false,
-		Retention:    nil,
+		Key:            "my-file.txt",
+		VersionID:      "v1",
+		Content:        []byte("abc"),
+		LastModified:   now,
+		StorageClass:   "STANDARD",
+		DeleteMarker:   false,
+		Retention:      nil,
+		ChecksumSHA256: "ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0=",
 	})
 
 	// try another upload but this should fail
@@ -555,3 +577,809 @@ func TestPutObjectRetentionDoesRetries(t *testing.T) {
 	assert.Equal(t, 1, len(versions))
 	assert.Equal(t, until, versions[0].Retention.Until)
 }
+
+func TestGetObject(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	now := time.Now().UTC()
+	sv.SetNow(now)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	// missing file
+	_, err := client.GetObject("my-file.txt")
+	assert.ErrContains(t, err, "404")
+
+	// put a file twice, the latest version is returned
+	err = client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	err = client.PutObject("my-file.txt", bytes.NewReader([]byte("def")), 3, nil)
+	assert.NoErr(t, err)
+
+	result, err := client.GetObject("my-file.txt")
+	assert.NoErr(t, err)
+	t.Cleanup(func() { _ = result.Body.Close() })
+
+	data, err := io.ReadAll(result.Body)
+	assert.NoErr(t, err)
+	assert.Equal(t, "def", string(data))
+	assert.Equal(t, "v2", result.VersionID)
+	assert.Equal(t, int64(3), result.ContentLength)
+
+	// deleted file can not be fetched
+	_, err = client.DeleteObjects([]s3.ObjectIdentifier{{Key: "my-file.txt"}})
+	assert.NoErr(t, err)
+
+	_, err = client.GetObject("my-file.txt")
+	assert.ErrContains(t, err, "404")
+}
+
+func TestListAllObjectVersionsPaginates(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	now := time.Now().UTC()
+	sv.SetNow(now)
+	sv.SetMaxKeys(2)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	// three files with two versions each, and a delete marker on the last
+	for _, key := range []string{"a.txt", "b.txt", "c.txt"} {
+		err := client.PutObject(key, bytes.NewReader([]byte("abc")), 3, nil)
+		assert.NoErr(t, err)
+		err = client.PutObject(key, bytes.NewReader([]byte("abc")), 3, nil)
+		assert.NoErr(t, err)
+	}
+	_, err := client.DeleteObjects([]s3.ObjectIdentifier{{Key: "c.txt"}})
+	assert.NoErr(t, err)
+
+	// a single page is truncated
+	result, err := client.ListObjectVersions("", "", "", 500)
+	assert.NoErr(t, err)
+	assert.Equal(t, true, result.IsTruncated)
+	assert.Equal(t, 2, len(result.Versions))
+
+	// iterating returns every version exactly once
+	listed := []s3.ListedVersion{}
+	for version, err := range client.ListAllObjectVersions("") {
+		assert.NoErr(t, err)
+		listed = append(listed, version)
+	}
+
+	assert.Equal(t, 7, len(listed))
+	assert.Equal(t, s3.ListedVersion{Key: "a.txt", VersionId: "v2", IsLatest: true, Size: 3, StorageClass: "STANDARD"}, listed[0])
+	assert.Equal(t, s3.ListedVersion{Key: "a.txt", VersionId: "v1", IsLatest: false, Size: 3, StorageClass: "STANDARD"}, listed[1])
+	assert.Equal(t, s3.ListedVersion{Key: "b.txt", VersionId: "v4", IsLatest: true, Size: 3, StorageClass: "STANDARD"}, listed[2])
+	assert.Equal(t, s3.ListedVersion{Key: "b.txt", VersionId: "v3", IsLatest: false, Size: 3, StorageClass: "STANDARD"}, listed[3])
+	assert.Equal(t, s3.ListedVersion{Key: "c.txt", VersionId: "v6", IsLatest: false, Size: 3, StorageClass: "STANDARD"}, listed[4])
+	assert.Equal(t, s3.ListedVersion{Key: "c.txt", VersionId: "v7", IsLatest: true, DeleteMarker: true}, listed[5])
+	assert.Equal(t, s3.ListedVersion{Key: "c.txt", VersionId: "v5", IsLatest: false, Size: 3, StorageClass: "STANDARD"}, listed[6])
+
+	// iteration can be stopped early
+	count := 0
+	for _, err := range client.ListAllObjectVersions("") {
+		assert.NoErr(t, err)
+		count++
+		if count == 3 {
+			break
+		}
+	}
+	assert.Equal(t, 3, count)
+}
+
+func TestKeysAreEscaped(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	now := time.Now().UTC()
+	sv.SetNow(now)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	err := client.PutObject("my dir/my file+1.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	err = client.PutObject("other/my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	assert.Equal(t, 1, len(sv.GetVersions("my dir/my file+1.txt")))
+
+	// list only the prefix
+	result, err := client.ListObjectVersions("my dir/", "", "", 500)
+	assert.NoErr(t, err)
+	assert.Equal(t, "my dir/", result.Prefix)
+	assert.Equal(t, 1, len(result.Versions))
+	assert.Equal(t, "my dir/my file+1.txt", result.Versions[0].Key)
+
+	object, err := client.GetObject("my dir/my file+1.txt")
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+}
+
+func TestObjectMetadata(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	err := client.WithMetadata(map[string]string{"marmalade-compression": "gzip"}).PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	head, err := client.HeadObject("my-file.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, "gzip", head.Metadata["marmalade-compression"])
+
+	// copies keep the metadata of their source
+	err = client.CopyObject("my-file.txt", "", "other.txt", nil)
+	assert.NoErr(t, err)
+
+	object, err := client.GetObject("other.txt")
+	assert.NoErr(t, err)
+	_ = object.Body.Close()
+	assert.Equal(t, "gzip", object.Metadata["marmalade-compression"])
+
+	// unless the client has metadata of its own
+	err = client.WithMetadata(map[string]string{"marmalade-compression": "none"}).CopyObject("my-file.txt", "", "other.txt", nil)
+	assert.NoErr(t, err)
+
+	head, err = client.HeadObject("other.txt")
+	assert.NoErr(t, err)
+	assert.Equal(t, "none", head.Metadata["marmalade-compression"])
+}
+
+func TestMultipartUpload(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+	now := time.Now().UTC()
+	sv.SetNow(now)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:                  url,
+		Region:               "my-region",
+		KeyID:                "keyid",
+		KeySecret:            "shh",
+		Bucket:               "my-bucket",
+		StorageClass:         "GLACIER",
+		Insecure:             true,
+		MultipartPartSize:    4,
+		AllowSmallParts:      true,
+		MultipartConcurrency: 2,
+	})
+
+	// the first part upload fails once and is retried
+	var mu sync.Mutex
+	parts := 0
+	failed := false
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Query().Has("partNumber") {
+			parts++
+			if !failed {
+				failed = true
+				w.WriteHeader(http.StatusInternalServerError)
+				return true
+			}
+		}
+		return false
+	})
+
+	until := now.Add(time.Hour).Truncate(time.Second)
+	err := client.PutObjectMultipart("my-file.txt", bytes.NewReader([]byte("abcdefghij")), 10, &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: until})
+	assert.NoErr(t, err)
+	assert.Equal(t, 4, parts)
+
+	versions := sv.GetVersions("my-file.txt")
+	assert.Equal(t, 1, len(versions))
+	assert.Equal(t, versions[0], &fakes3.ObjectVersion{
+		Key:          "my-file.txt",
+		VersionID:    "v1",
+		Content:      []byte("abcdefghij"),
+		LastModified: now,
+		StorageClass: "GLACIER",
+		DeleteMarker: false,
+		Retention:    &fakes3.ObjectLockRetention{Mode: "COMPLIANCE", Until: until},
+		// the checksum of the checksums of the three parts
+		ChecksumSHA256: "Obym+jYZ9n7k1vjfUUkVWFYPQpkgWT9/ZJEZuj8oXtk=-3",
+		Parts: []fakes3.ObjectPart{
+			{PartNumber: 1, Size: 4, ChecksumSHA256: "iNQmb9TmM40TuEX88olXnSCciXgjuSF9o+Fhk28DFYk="},
+			{PartNumber: 2, Size: 4, ChecksumSHA256: "5eCIoLZhY6Cial4FPSpEltwWq24OPdGt8tFqqEoHjJ0="},
+			{PartNumber: 3, Size: 2, ChecksumSHA256: "yd+cPyljsZublfWMTTOwU/qfhYbdbuBBJuUqho+IIQg="},
+		},
+	})
+	assert.Equal(t, 0, sv.GetMultipartUploadCount())
+
+	// an empty file is a single empty part
+	sv.SetInterceptor(nil)
+	err = client.PutObjectMultipart("empty.txt", bytes.NewReader([]byte{}), 0, nil)
+	assert.NoErr(t, err)
+
+	versions = sv.GetVersions("empty.txt")
+	assert.Equal(t, 1, len(versions))
+	assert.Equal(t, 0, len(versions[0].Content))
+}
+
+func TestMultipartPartSize(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	config := s3.Config{
+		URL:               sv.GetEndpoint(),
+		Region:            "my-region",
+		KeyID:             "keyid",
+		KeySecret:         "shh",
+		Bucket:            "my-bucket",
+		Insecure:          true,
+		MultipartPartSize: 1024,
+	}
+
+	// S3 rejects parts below 5 MiB, so the client does too
+	err := s3.NewClient(config).PutObjectMultipart("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.ErrContains(t, err, "multipart part size of 1024 bytes is below the minimum of 5242880 bytes")
+
+	// a file that needs more parts than S3 allows is sent in larger parts
+	sv.SetMinPartSize(0)
+	config.MultipartPartSize = 1
+	config.AllowSmallParts = true
+	config.MultipartConcurrency = 16
+	client := s3.NewClient(config)
+
+	var mu sync.Mutex
+	parts := 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Query().Has("partNumber") {
+			parts++
+		}
+		return false
+	})
+
+	data := bytes.Repeat([]byte("a"), 10001)
+	err = client.PutObjectMultipart("my-file.txt", bytes.NewReader(data), int64(len(data)), nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, 5001, parts)
+	assert.Equal(t, string(data), string(sv.GetVersions("my-file.txt")[0].Content))
+}
+
+func TestMultipartUploadAbortsOnFailure(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+	now := time.Now().UTC()
+	sv.SetNow(now)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:                  url,
+		Region:               "my-region",
+		KeyID:                "keyid",
+		KeySecret:            "shh",
+		Bucket:               "my-bucket",
+		Insecure:             true,
+		MultipartPartSize:    4,
+		AllowSmallParts:      true,
+		MultipartConcurrency: 2,
+	})
+
+	// the second part is rejected
+	var mu sync.Mutex
+	aborted := false
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Query().Get("partNumber") == "2" {
+			w.WriteHeader(http.StatusForbidden)
+			return true
+		}
+		if r.Method == http.MethodDelete {
+			aborted = true
+		}
+		return false
+	})
+
+	err := client.PutObjectMultipart("my-file.txt", bytes.NewReader([]byte("abcdefghij")), 10, nil)
+	assert.ErrContains(t, err, "upload part 2")
+	assert.True(t, aborted)
+
+	assert.Equal(t, 0, len(sv.GetVersions("my-file.txt")))
+	assert.Equal(t, 0, sv.GetMultipartUploadCount())
+
+	// completion fails in the body of a 200 response
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.Method == http.MethodPost && r.URL.Query().Has("uploadId") {
+			w.WriteHeader(http.StatusOK)
+			_, _ = w.Write([]byte(`<?xml version="1.0" encoding="UTF-8"?><Error><Code>InternalError</Code></Error>`))
+			return true
+		}
+		return false
+	})
+
+	err = client.PutObjectMultipart("my-file.txt", bytes.NewReader([]byte("abcdefghij")), 10, nil)
+	assert.ErrContains(t, err, "retries exceeded")
+
+	assert.Equal(t, 0, len(sv.GetVersions("my-file.txt")))
+	assert.Equal(t, 0, sv.GetMultipartUploadCount())
+}
+
+type failingReader struct {
+	data []byte
+}
+
+func (r *failingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("disk on fire")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestPutObjectStreamGrowsParts(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	client := s3.NewClient(s3.Config{
+		URL:                  sv.GetEndpoint(),
+		Region:               "my-region",
+		KeyID:                "keyid",
+		KeySecret:            "shh",
+		Bucket:               "my-bucket",
+		Insecure:             true,
+		MultipartPartSize:    1,
+		AllowSmallParts:      true,
+		MultipartConcurrency: 16,
+	})
+
+	var mu sync.Mutex
+	parts := 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Query().Has("partNumber") {
+			parts++
+		}
+		return false
+	})
+
+	// 1000 parts each of 1, 2, 4 and 8 bytes, then 313 parts of 16 bytes for the last 5000 bytes
+	data := bytes.Repeat([]byte("a"), 20000)
+	size, err := client.PutObjectStream("my-file.txt", bytes.NewReader(data), nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, int64(len(data)), size)
+	assert.Equal(t, 4313, parts)
+	assert.Equal(t, string(data), string(sv.GetVersions("my-file.txt")[0].Content))
+}
+
+func TestPutObjectStream(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+	now := time.Now().UTC()
+	sv.SetNow(now)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:                  url,
+		Region:               "my-region",
+		KeyID:                "keyid",
+		KeySecret:            "shh",
+		Bucket:               "my-bucket",
+		Insecure:             true,
+		MultipartPartSize:    4,
+		AllowSmallParts:      true,
+		MultipartConcurrency: 2,
+	})
+
+	var mu sync.Mutex
+	parts := 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		mu.Lock()
+		defer mu.Unlock()
+
+		if r.URL.Query().Has("partNumber") {
+			parts++
+		}
+		return false
+	})
+
+	testCases := []struct {
+		content string
+		parts   int
+	}{
+		{"", 0},
+		{"abc", 0},
+		{"abcd", 1},
+		{"abcdefgh", 2},
+		{"abcdefghijklm", 4},
+	}
+
+	until := now.Add(time.Hour).Truncate(time.Second)
+	for i, tc := range testCases {
+		parts = 0
+		key := fmt.Sprintf("file-%d.txt", i)
+
+		size, err := client.PutObjectStream(key, strings.NewReader(tc.content), &s3.ObjectLockRetention{Mode: "COMPLIANCE", Until: until})
+		assert.NoErr(t, err)
+		assert.Equal(t, int64(len(tc.content)), size)
+		assert.Equal(t, tc.parts, parts)
+
+		versions := sv.GetVersions(key)
+		assert.Equal(t, 1, len(versions))
+		assert.Equal(t, tc.content, string(versions[0].Content))
+		assert.Equal(t, until, versions[0].Retention.Until)
+	}
+
+	// a failing reader aborts the upload
+	_, err := client.PutObjectStream("failed.txt", &failingReader{data: []byte("abcdefghij")}, nil)
+	assert.ErrContains(t, err, "disk on fire")
+
+	assert.Equal(t, 0, len(sv.GetVersions("failed.txt")))
+	assert.Equal(t, 0, sv.GetMultipartUploadCount())
+}
+
+func TestCancelStopsRetries(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:       url,
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	tries := 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		tries++
+		cancel()
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+
+	err := client.PutObjectContext(ctx, "my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, tries)
+
+	// nothing is sent once cancelled
+	_, err = client.ListObjectVersionsContext(ctx, "", "", "", 500)
+	assert.True(t, errors.Is(err, context.Canceled))
+	assert.Equal(t, 1, tries)
+}
+
+func TestCancelAbortsMultipartUpload(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	url := sv.GetEndpoint()
+
+	client := s3.NewClient(s3.Config{
+		URL:                  url,
+		Region:               "my-region",
+		KeyID:                "keyid",
+		KeySecret:            "shh",
+		Bucket:               "my-bucket",
+		Insecure:             true,
+		MultipartPartSize:    4,
+		AllowSmallParts:      true,
+		MultipartConcurrency: 2,
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// cancel once the first part is uploaded
+	var once sync.Once
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if r.URL.Query().Has("partNumber") {
+			once.Do(cancel)
+		}
+		return false
+	})
+
+	_, err := client.PutObjectStreamContext(ctx, "my-file.txt", strings.NewReader("abcdefghijklmnop"), nil)
+	assert.True(t, errors.Is(err, context.Canceled))
+
+	assert.Equal(t, 0, len(sv.GetVersions("my-file.txt")))
+	assert.Equal(t, 0, sv.GetMultipartUploadCount())
+}
+
+func TestErrorResponsesAreParsed(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetNow(time.Now().UTC())
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+	})
+
+	_, err := client.GetObject("my-file.txt")
+	var s3Err *s3.Error
+	assert.True(t, errors.As(err, &s3Err))
+	assert.Equal(t, "GetObject", s3Err.Operation)
+	assert.Equal(t, http.StatusNotFound, s3Err.StatusCode)
+	assert.Equal(t, s3.ErrCodeNoSuchKey, s3Err.Code)
+	assert.Equal(t, "The specified key does not exist.", s3Err.Message)
+	assert.True(t, s3Err.RequestID != "")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeNoSuchKey))
+
+	// SlowDown is retried
+	tries := 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if tries < 2 {
+			tries++
+			fakes3.WriteError(w, http.StatusServiceUnavailable, s3.ErrCodeSlowDown, "Please reduce your request rate.")
+			return true
+		}
+		return false
+	})
+	err = client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, tries)
+
+	// AccessDenied is not
+	tries = 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		tries++
+		fakes3.WriteError(w, http.StatusForbidden, s3.ErrCodeAccessDenied, "Access Denied")
+		return true
+	})
+	err = client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.ErrContains(t, err, "PutObject failed with status: 403 Forbidden, code: AccessDenied, message: Access Denied")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeAccessDenied))
+	assert.Equal(t, 1, tries)
+
+	// responses that are not S3 errors keep their body
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+		return true
+	})
+	_, err = client.GetObject("my-file.txt")
+	assert.ErrContains(t, err, "GetObject failed with status: 502 Bad Gateway, response: bad gateway")
+}
+
+func TestRetryPolicy(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetNow(time.Now().UTC())
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+		Retry:     s3.RetryPolicy{MaxAttempts: 3, Budget: time.Minute},
+	})
+
+	// gives up after the configured attempts
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		w.WriteHeader(http.StatusInternalServerError)
+		return true
+	})
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.ErrContains(t, err, "retries exceeded after 3 attempts")
+	assert.Equal(t, s3.Metrics{Requests: 3, Retries: 2, Exhausted: 1}, client.Metrics())
+
+	// throttling is retried
+	tries := 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if tries < 2 {
+			tries++
+			fakes3.WriteError(w, http.StatusTooManyRequests, "TooManyRequests", "Please reduce your request rate.")
+			return true
+		}
+		return false
+	})
+	err = client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, s3.Metrics{Requests: 6, Retries: 4, Throttled: 2, Exhausted: 1}, client.Metrics())
+
+	// connection resets are retried
+	tries = 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		if tries < 2 {
+			tries++
+			conn, _, err := w.(http.Hijacker).Hijack()
+			assert.NoErr(t, err)
+			_ = conn.Close()
+			return true
+		}
+		return false
+	})
+	err = client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, tries)
+	assert.Equal(t, 2, len(sv.GetVersions("my-file.txt")))
+
+	// waiting as long as Retry-After asks would go over the budget
+	tries = 0
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		tries++
+		w.Header().Set("Retry-After", "120")
+		fakes3.WriteError(w, http.StatusServiceUnavailable, s3.ErrCodeSlowDown, "Please reduce your request rate.")
+		return true
+	})
+	err = client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.ErrContains(t, err, "retry budget of 1m0s exceeded after 1 attempts")
+	assert.True(t, s3.IsErrorCode(err, s3.ErrCodeSlowDown))
+	assert.Equal(t, 1, tries)
+}
+
+func TestAddressingStyles(t *testing.T) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetMinPartSize(0)
+	sv.SetNow(time.Now().UTC())
+	sv.SetCredentials("keyid", "shh", "")
+
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+	_, port, _ := strings.Cut(sv.GetEndpoint(), ":")
+
+	testCases := []struct {
+		name     string
+		url      string
+		style    s3.AddressingStyle
+		basePath string
+		host     string
+	}{
+		{"virtual-hosted", "virtual.example.com:" + port, s3.AddressingVirtualHosted, "", "my-bucket.virtual.example.com:" + port},
+		{"auto detects aws", "http://s3.eu-west-1.amazonaws.com:" + port, s3.AddressingAuto, "", "my-bucket.s3.eu-west-1.amazonaws.com:" + port},
+		{"auto uses path style elsewhere", "http://" + sv.GetEndpoint(), s3.AddressingAuto, "", sv.GetEndpoint()},
+		{"base path", "http://" + sv.GetEndpoint() + "/storage/", s3.AddressingPath, "/storage", sv.GetEndpoint()},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sv.Reset()
+			sv.SetBasePath(tc.basePath)
+
+			hosts := []string{}
+			sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+				hosts = append(hosts, r.Host)
+				return false
+			})
+
+			client := s3.NewClient(s3.Config{
+				URL:               tc.url,
+				Region:            "my-region",
+				KeyID:             "keyid",
+				KeySecret:         "shh",
+				Bucket:            "my-bucket",
+				Insecure:          true,
+				AddressingStyle:   tc.style,
+				MultipartPartSize: 4,
+				AllowSmallParts:   true,
+				// one part at a time, so the interceptor can record hosts without locking
+				MultipartConcurrency: 1,
+				// connect to the fake whatever the host of a request is
+				Transport: s3.TransportConfig{RoundTripper: &http.Transport{
+					DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+						var dialer net.Dialer
+						return dialer.DialContext(ctx, network, sv.GetEndpoint())
+					},
+				}},
+			})
+
+			// every request is signed correctly, including keys that need escaping
+			err := client.PutObject("dir/my file+1.txt", bytes.NewReader([]byte("abc")), 3, nil)
+			assert.NoErr(t, err)
+			err = client.PutObjectMultipart("big.txt", bytes.NewReader([]byte("abcdefgh")), 8, nil)
+			assert.NoErr(t, err)
+			err = client.PutObjectRetention("big.txt", &s3.ObjectLockRetention{Mode: "GOVERNANCE", Until: time.Now().Add(time.Hour)})
+			assert.NoErr(t, err)
+
+			object, err := client.GetObject("dir/my file+1.txt")
+			assert.NoErr(t, err)
+			_ = object.Body.Close()
+
+			result, err := client.ListObjectVersions("dir/", "", "", 500)
+			assert.NoErr(t, err)
+			assert.Equal(t, 1, len(result.Versions))
+			assert.Equal(t, "dir/my file+1.txt", result.Versions[0].Key)
+
+			_, err = client.DeleteObjects([]s3.ObjectIdentifier{{Key: "dir/my file+1.txt", VersionID: result.Versions[0].VersionId}})
+			assert.NoErr(t, err)
+			assert.Equal(t, 0, len(sv.GetVersions("dir/my file+1.txt")))
+
+			assert.Equal(t, 9, len(hosts))
+			for _, host := range hosts {
+				assert.Equal(t, tc.host, host)
+			}
+		})
+	}
+}
+
+func TestInvalidEndpoints(t *testing.T) {
+	testCases := []struct {
+		url    string
+		style  s3.AddressingStyle
+		bucket string
+		error  string
+	}{
+		{"ftp://s3.example.com", s3.AddressingAuto, "my-bucket", "scheme must be http or https"},
+		{"https://", s3.AddressingAuto, "my-bucket", "host is required"},
+		{"", s3.AddressingAuto, "my-bucket", "host is required"},
+		{"https://s3.example.com/?a=b", s3.AddressingAuto, "my-bucket", "query, fragment and user info are not supported"},
+		{"s3.example.com", s3.AddressingVirtualHosted, "My_Bucket", "bucket My_Bucket cannot be used in a host name"},
+		{"s3.example.com", "sideways", "my-bucket", "unknown addressing style: sideways"},
+	}
+
+	for _, tc := range testCases {
+		client := s3.NewClient(s3.Config{URL: tc.url, AddressingStyle: tc.style, Bucket: tc.bucket, Region: "my-region", KeyID: "keyid", KeySecret: "shh"})
+		_, err := client.GetObject("my-file.txt")
+		assert.ErrContains(t, err, tc.error)
+	}
+
+	_, err := s3.ParseAddressingStyle("virtual-hosted")
+	assert.NoErr(t, err)
+	_, err = s3.ParseAddressingStyle("sideways")
+	assert.ErrContains(t, err, "unknown addressing style: sideways")
+}
@@ -10,12 +10,23 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"slices"
 	"sort"
 	"strings"
 	"time"
 )
 
 func (c *Client) signV4(req *http.Request, body io.ReadSeeker) error {
+	credentials, err := c.credentials.Retrieve(req.Context())
+	if err != nil {
+		return fmt.Errorf("retrieve credentials: %w", err)
+	}
+
+	return signRequest(req, body, credentials, c.region, "s3")
+}
+
+// signRequest adds a SigV4 signature for service in region to req.
+func signRequest(req *http.Request, body io.ReadSeeker, credentials Credentials, region string, service string) error {
 	parsedURL, err := url.Parse(req.URL.String())
 	if err != nil {
 		return err
@@ -28,6 +39,9 @@ func (c *Client) signV4(req *http.Request, body io.ReadSeeker) error {
 
 	// Set required headers
 	req.Header.Set("x-amz-date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("x-amz-security-token", credentials.SessionToken)
+	}
 
 	// Calculate hash of request body
 	var bodyHash string
@@ -48,13 +62,13 @@ func (c *Client) signV4(req *http.Request, body io.ReadSeeker) error {
 	req.Header.Set("x-amz-content-sha256", bodyHash)
 
 	// Create canonical URI
-	canonicalURI := parsedURL.Path
+	canonicalURI := uriEncode(parsedURL.Path, false)
 	if canonicalURI == "" {
 		canonicalURI = "/"
 	}
 
 	// Create canonical query string
-	canonicalQueryString := parsedURL.RawQuery
+	canonicalQueryString := canonicalQueryString(parsedURL.Query())
 
 	// Create canonical headers
 	canonicalHeaders := ""
@@ -96,7 +110,7 @@ func (c *Client) signV4(req *http.Request, body io.ReadSeeker) error {
 
 	// Create string to sign
 	algorithm := "AWS4-HMAC-SHA256"
-	credentialScope := fmt.Sprintf("%s/%s/s3/aws4_request", dateStamp, c.region)
+	credentialScope := fmt.Sprintf("%s/%s/%s/aws4_request", dateStamp, region, service)
 
 	h := sha256.New()
 	h.Write([]byte(canonicalRequest))
@@ -109,16 +123,16 @@ func (c *Client) signV4(req *http.Request, body io.ReadSeeker) error {
 		canonicalRequestHash)
 
 	// Calculate signature
-	kDate := hmacSHA256([]byte("AWS4"+c.secretKey), []byte(dateStamp))
-	kRegion := hmacSHA256(kDate, []byte(c.region))
-	kService := hmacSHA256(kRegion, []byte("s3"))
+	kDate := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), []byte(dateStamp))
+	kRegion := hmacSHA256(kDate, []byte(region))
+	kService := hmacSHA256(kRegion, []byte(service))
 	kSigning := hmacSHA256(kService, []byte("aws4_request"))
 	signature := hex.EncodeToString(hmacSHA256(kSigning, []byte(stringToSign)))
 
 	// Add Authorization header
 	authHeader := fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
 		algorithm,
-		c.accessKey,
+		credentials.AccessKeyID,
 		credentialScope,
 		signedHeaders,
 		signature)
@@ -128,6 +142,41 @@ func (c *Client) signV4(req *http.Request, body io.ReadSeeker) error {
 	return nil
 }
 
+// uriEncode escapes every byte except the unreserved characters, as required by SigV4.
+// Slashes are left alone when encoding a path.
+func uriEncode(s string, encodeSlash bool) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// canonicalQueryString encodes query sorted by key, with every key and value escaped by uriEncode.
+func canonicalQueryString(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for k := range query {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := []string{}
+	for _, k := range keys {
+		values := slices.Clone(query[k])
+		sort.Strings(values)
+		for _, v := range values {
+			parts = append(parts, uriEncode(k, true)+"="+uriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
 const emptyStringSHA256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
 
 func hmacSHA256(key, data []byte) []byte {
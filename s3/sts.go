@@ -0,0 +1,254 @@
+package s3
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	stsVersion = "2011-06-15"
+	// DefaultAssumeRoleDuration is how long assumed role credentials last. The Client replaces them
+	// shortly before they expire.
+	DefaultAssumeRoleDuration = time.Hour
+)
+
+// stsTimeout bounds STS requests, which are small and quick.
+const stsTimeout = 30 * time.Second
+
+// stsClient is used for STS requests by providers that are not given an HTTPClient.
+var stsClient = &http.Client{Timeout: stsTimeout}
+
+// AssumeRoleCredentials are temporary credentials for RoleARN, requested from STS with the
+// credentials of Source.
+type AssumeRoleCredentials struct {
+	Source      CredentialsProvider
+	RoleARN     string
+	SessionName string
+	// ExternalID is sent if the trust policy of the role requires one.
+	ExternalID string
+	// Duration defaults to DefaultAssumeRoleDuration.
+	Duration time.Duration
+
+	// Region of the STS endpoint. The global endpoint is used if it is empty.
+	Region string
+	// Endpoint overrides the STS URL, such as https://sts.us-east-1.amazonaws.com.
+	Endpoint string
+
+	// HTTPClient sends the STS requests. NewClient sets it to use the Transport of the Client if
+	// it is nil, so STS is reached through the same proxy and trusted CAs as S3.
+	HTTPClient *http.Client
+}
+
+func (a AssumeRoleCredentials) withTransport(transport http.RoundTripper) CredentialsProvider {
+	if a.HTTPClient == nil {
+		a.HTTPClient = &http.Client{Transport: transport, Timeout: stsTimeout}
+	}
+	a.Source = withTransport(a.Source, transport)
+	return a
+}
+
+func (a AssumeRoleCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	if a.Source == nil || a.RoleARN == "" {
+		return Credentials{}, fmt.Errorf("assume role: source credentials and role arn are required")
+	}
+
+	source, err := a.Source.Retrieve(ctx)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("assume role: source credentials: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRole")
+	form.Set("Version", stsVersion)
+	form.Set("RoleArn", a.RoleARN)
+	form.Set("RoleSessionName", sessionName(a.SessionName))
+	form.Set("DurationSeconds", durationSeconds(a.Duration))
+	if a.ExternalID != "" {
+		form.Set("ExternalId", a.ExternalID)
+	}
+
+	region := a.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	credentials, err := callSTS(ctx, a.HTTPClient, stsEndpoint(a.Endpoint, a.Region), form, func(req *http.Request, body io.ReadSeeker) error {
+		return signRequest(req, body, source, region, "sts")
+	})
+	if err != nil {
+		return Credentials{}, fmt.Errorf("assume role %s: %w", a.RoleARN, err)
+	}
+	return credentials, nil
+}
+
+// WebIdentityCredentials exchange an OIDC token, such as a Kubernetes projected service account
+// token, for temporary credentials of RoleARN. The token file is read again on every exchange
+// because it is rotated while the process runs.
+type WebIdentityCredentials struct {
+	// RoleARN defaults to AWS_ROLE_ARN.
+	RoleARN string
+	// TokenFile defaults to AWS_WEB_IDENTITY_TOKEN_FILE.
+	TokenFile string
+	// SessionName defaults to AWS_ROLE_SESSION_NAME.
+	SessionName string
+	// Duration defaults to DefaultAssumeRoleDuration.
+	Duration time.Duration
+
+	Region   string
+	Endpoint string
+
+	// HTTPClient sends the STS requests. NewClient sets it to use the Transport of the Client if
+	// it is nil.
+	HTTPClient *http.Client
+}
+
+func (w WebIdentityCredentials) withTransport(transport http.RoundTripper) CredentialsProvider {
+	if w.HTTPClient == nil {
+		w.HTTPClient = &http.Client{Transport: transport, Timeout: stsTimeout}
+	}
+	return w
+}
+
+func (w WebIdentityCredentials) Retrieve(ctx context.Context) (Credentials, error) {
+	roleARN := w.RoleARN
+	if roleARN == "" {
+		roleARN = os.Getenv("AWS_ROLE_ARN")
+	}
+	tokenFile := w.TokenFile
+	if tokenFile == "" {
+		tokenFile = os.Getenv("AWS_WEB_IDENTITY_TOKEN_FILE")
+	}
+	if roleARN == "" || tokenFile == "" {
+		return Credentials{}, fmt.Errorf("web identity: role arn and token file are required")
+	}
+	name := w.SessionName
+	if name == "" {
+		name = os.Getenv("AWS_ROLE_SESSION_NAME")
+	}
+
+	token, err := os.ReadFile(tokenFile)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("web identity: %w", err)
+	}
+
+	form := url.Values{}
+	form.Set("Action", "AssumeRoleWithWebIdentity")
+	form.Set("Version", stsVersion)
+	form.Set("RoleArn", roleARN)
+	form.Set("RoleSessionName", sessionName(name))
+	form.Set("DurationSeconds", durationSeconds(w.Duration))
+	form.Set("WebIdentityToken", strings.TrimSpace(string(token)))
+
+	// The token is the proof of identity, so the request is not signed.
+	credentials, err := callSTS(ctx, w.HTTPClient, stsEndpoint(w.Endpoint, w.Region), form, nil)
+	if err != nil {
+		return Credentials{}, fmt.Errorf("web identity %s: %w", roleARN, err)
+	}
+	return credentials, nil
+}
+
+func stsEndpoint(endpoint, region string) string {
+	if endpoint != "" {
+		return endpoint
+	}
+	if region != "" {
+		return fmt.Sprintf("https://sts.%s.amazonaws.com", region)
+	}
+	return "https://sts.amazonaws.com"
+}
+
+func sessionName(name string) string {
+	if name == "" {
+		return fmt.Sprintf("marmalade-%d", time.Now().Unix())
+	}
+	return name
+}
+
+func durationSeconds(duration time.Duration) string {
+	if duration <= 0 {
+		duration = DefaultAssumeRoleDuration
+	}
+	return strconv.Itoa(int(duration.Seconds()))
+}
+
+// stsResponse covers both AssumeRoleResponse and AssumeRoleWithWebIdentityResponse, which only
+// differ in their root elements.
+type stsResponse struct {
+	Credentials struct {
+		AccessKeyID     string    `xml:"AccessKeyId"`
+		SecretAccessKey string    `xml:"SecretAccessKey"`
+		SessionToken    string    `xml:"SessionToken"`
+		Expiration      time.Time `xml:"Expiration"`
+	} `xml:"Credentials"`
+}
+
+type stsErrorResponse struct {
+	Error     Error  `xml:"Error"`
+	RequestID string `xml:"RequestId"`
+}
+
+func callSTS(ctx context.Context, client *http.Client, endpoint string, form url.Values, sign func(*http.Request, io.ReadSeeker) error) (Credentials, error) {
+	if client == nil {
+		client = stsClient
+	}
+
+	body := strings.NewReader(form.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, body)
+	if err != nil {
+		return Credentials{}, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+
+	if sign != nil {
+		if err := sign(req, body); err != nil {
+			return Credentials{}, err
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return Credentials{}, err
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	operation := form.Get("Action")
+	if resp.StatusCode != http.StatusOK {
+		var errorResponse stsErrorResponse
+		if err := xml.Unmarshal(data, &errorResponse); err != nil || errorResponse.Error.Code == "" {
+			return Credentials{}, parseError(operation, resp.StatusCode, data)
+		}
+		e := errorResponse.Error
+		e.Operation = operation
+		e.StatusCode = resp.StatusCode
+		e.RequestID = errorResponse.RequestID
+		return Credentials{}, &e
+	}
+
+	// The result element is named after the action, so look for it by suffix.
+	var result struct {
+		Results []stsResponse `xml:",any"`
+	}
+	if err := xml.Unmarshal(data, &result); err != nil {
+		return Credentials{}, fmt.Errorf("parse response: %w", err)
+	}
+	for _, r := range result.Results {
+		c := r.Credentials
+		if c.AccessKeyID != "" && c.SecretAccessKey != "" {
+			return Credentials{AccessKeyID: c.AccessKeyID, SecretAccessKey: c.SecretAccessKey, SessionToken: c.SessionToken, Expires: c.Expiration}, nil
+		}
+	}
+	return Credentials{}, fmt.Errorf("response has no credentials")
+}
@@ -0,0 +1,218 @@
+package s3_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	fakests "github.com/bradenrayhorn/marmalade/internal/fake_sts"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+const backupRole = "arn:aws:iam::123456789012:role/backup"
+
+// setupSTS starts a fake S3 that only accepts credentials handed out by the returned fake STS.
+func setupSTS(t *testing.T) (*fakes3.FakeS3, *fakests.FakeSTS) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetNow(time.Now().UTC())
+	sv.StartServer()
+	t.Cleanup(func() { sv.StopServer() })
+
+	sts := fakests.NewFakeSTS()
+	sts.AddCaller(fakes3.Key{ID: "long-lived", Secret: "long-lived-secret"})
+	sts.AddRole(backupRole, "")
+	sts.OnIssue(sv.AddKey)
+	sts.StartServer()
+	t.Cleanup(func() { sts.StopServer() })
+
+	return sv, sts
+}
+
+func TestAssumeRole(t *testing.T) {
+	sv, sts := setupSTS(t)
+	sts.AddRole("arn:aws:iam::123456789012:role/external", "ext-123")
+
+	client := s3.NewClient(s3.Config{
+		URL:      sv.GetEndpoint(),
+		Region:   "my-region",
+		Bucket:   "my-bucket",
+		Insecure: true,
+		Credentials: s3.AssumeRoleCredentials{
+			Source:      s3.StaticCredentials{AccessKeyID: "long-lived", SecretAccessKey: "long-lived-secret"},
+			RoleARN:     backupRole,
+			SessionName: "nightly",
+			Endpoint:    sts.GetURL(),
+		},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.NoErr(t, err)
+
+	// the role was assumed once and its credentials reused
+	issued := sts.GetIssued()
+	assert.Equal(t, 1, len(issued))
+	assert.Equal(t, "AssumeRole", issued[0].Action)
+	assert.Equal(t, backupRole, issued[0].RoleARN)
+	assert.Equal(t, "nightly", issued[0].SessionName)
+
+	// the long-lived key cannot use the bucket itself
+	client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", KeyID: "long-lived", KeySecret: "long-lived-secret", Bucket: "my-bucket", Insecure: true})
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.True(t, s3.IsErrorCode(err, "InvalidAccessKeyId"))
+
+	// roles that are not allowed, or without the external id they need, are refused
+	for _, credentials := range []s3.AssumeRoleCredentials{
+		{RoleARN: "arn:aws:iam::123456789012:role/admin"},
+		{RoleARN: "arn:aws:iam::123456789012:role/external", ExternalID: "wrong"},
+	} {
+		credentials.Source = s3.StaticCredentials{AccessKeyID: "long-lived", SecretAccessKey: "long-lived-secret"}
+		credentials.Endpoint = sts.GetURL()
+		client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", Bucket: "my-bucket", Insecure: true, Credentials: credentials})
+
+		_, err = client.ListObjectVersions("", "", "", 500)
+		assert.ErrContains(t, err, "assume role "+credentials.RoleARN)
+		assert.True(t, s3.IsErrorCode(err, s3.ErrCodeAccessDenied))
+	}
+
+	// and so is a bad source key
+	client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", Bucket: "my-bucket", Insecure: true, Credentials: s3.AssumeRoleCredentials{
+		Source:   s3.StaticCredentials{AccessKeyID: "long-lived", SecretAccessKey: "wrong"},
+		RoleARN:  backupRole,
+		Endpoint: sts.GetURL(),
+	}})
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.True(t, s3.IsErrorCode(err, "SignatureDoesNotMatch"))
+}
+
+func TestAssumeRoleRefreshesDuringMultipartUpload(t *testing.T) {
+	sv, sts := setupSTS(t)
+	sv.SetMinPartSize(0)
+
+	// credentials that are about to expire are replaced before every request
+	sts.SetLifetime(time.Minute)
+
+	client := s3.NewClient(s3.Config{
+		URL:                  sv.GetEndpoint(),
+		Region:               "my-region",
+		Bucket:               "my-bucket",
+		Insecure:             true,
+		MultipartPartSize:    4,
+		AllowSmallParts:      true,
+		MultipartConcurrency: 2,
+		Credentials: s3.AssumeRoleCredentials{
+			Source:   s3.StaticCredentials{AccessKeyID: "long-lived", SecretAccessKey: "long-lived-secret"},
+			RoleARN:  backupRole,
+			Endpoint: sts.GetURL(),
+		},
+	})
+
+	data := []byte("abcdefghijklmnopqrstuvwxyz")
+	err := client.PutObjectMultipart("my-file.txt", bytes.NewReader(data), int64(len(data)), nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, string(data), string(sv.GetVersions("my-file.txt")[0].Content))
+
+	// create, 7 parts and complete
+	assert.Equal(t, 9, len(sts.GetIssued()))
+}
+
+func TestWebIdentity(t *testing.T) {
+	sv, sts := setupSTS(t)
+	sts.SetWebIdentityToken("first-token")
+	sts.SetLifetime(time.Minute)
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte("first-token\n"), 0600)
+	assert.NoErr(t, err)
+
+	t.Setenv("AWS_ROLE_ARN", backupRole)
+	t.Setenv("AWS_WEB_IDENTITY_TOKEN_FILE", tokenFile)
+	t.Setenv("AWS_ROLE_SESSION_NAME", "pod")
+
+	client := s3.NewClient(s3.Config{
+		URL:         sv.GetEndpoint(),
+		Region:      "my-region",
+		Bucket:      "my-bucket",
+		Insecure:    true,
+		Credentials: s3.WebIdentityCredentials{Endpoint: sts.GetURL()},
+	})
+
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.NoErr(t, err)
+
+	issued := sts.GetIssued()
+	assert.Equal(t, 1, len(issued))
+	assert.Equal(t, "AssumeRoleWithWebIdentity", issued[0].Action)
+	assert.Equal(t, "pod", issued[0].SessionName)
+
+	// the token file is read again for each exchange, so rotated tokens are picked up
+	sts.SetWebIdentityToken("second-token")
+	err = os.WriteFile(tokenFile, []byte("second-token\n"), 0600)
+	assert.NoErr(t, err)
+
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.NoErr(t, err)
+	assert.Equal(t, 2, len(sts.GetIssued()))
+
+	sts.SetWebIdentityToken("third-token")
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.ErrContains(t, err, "web identity "+backupRole)
+	assert.True(t, s3.IsErrorCode(err, "InvalidIdentityToken"))
+}
+
+// recordingTransport records the host of every request it sends.
+type recordingTransport struct {
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.mu.Lock()
+	r.hosts = append(r.hosts, req.URL.Host)
+	r.mu.Unlock()
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+func TestCredentialRequestsUseClientTransport(t *testing.T) {
+	sv, sts := setupSTS(t)
+
+	container := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		err := json.NewEncoder(w).Encode(map[string]any{"AccessKeyId": "long-lived", "SecretAccessKey": "long-lived-secret"})
+		assert.NoErr(t, err)
+	}))
+	t.Cleanup(container.Close)
+
+	// the role is assumed with container credentials, found through a chain
+	transport := &recordingTransport{}
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+		Transport: s3.TransportConfig{RoundTripper: transport},
+		Credentials: s3.CredentialsChain{s3.AssumeRoleCredentials{
+			Source:   s3.ContainerCredentials{Endpoint: container.URL},
+			RoleARN:  backupRole,
+			Endpoint: sts.GetURL(),
+		}},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// STS and S3 went through the transport of the client, the container endpoint is reached
+	// directly
+	stsURL, _ := url.Parse(sts.GetURL())
+	assert.Equal(t, strings.Join([]string{stsURL.Host, sv.GetEndpoint()}, ","), strings.Join(transport.hosts, ","))
+}
@@ -0,0 +1,138 @@
+package s3
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const (
+	// DefaultTimeout bounds a whole request, including sending a multipart part.
+	DefaultTimeout = 600 * time.Second
+	// DefaultMaxIdleConnsPerHost keeps enough connections around for concurrent multipart parts.
+	DefaultMaxIdleConnsPerHost = 16
+)
+
+// TransportConfig controls the HTTP connections to the endpoint. Zero values use the defaults of
+// net/http, except where noted.
+type TransportConfig struct {
+	// RoundTripper sends the requests instead of a transport built from the fields below, which are
+	// then ignored apart from Timeout.
+	RoundTripper http.RoundTripper
+
+	// CAFile is a PEM bundle of certificate authorities to trust in addition to the system ones.
+	CAFile string
+	// ClientCertFile and ClientKeyFile are a PEM certificate and key presented for mutual TLS.
+	ClientCertFile string
+	ClientKeyFile  string
+
+	// ProxyURL is an HTTP proxy that requests are sent through, using CONNECT for https. Without
+	// it HTTPS_PROXY, HTTP_PROXY and NO_PROXY are honored.
+	ProxyURL string
+
+	// Timeout bounds a whole request. Defaults to DefaultTimeout.
+	Timeout               time.Duration
+	DialTimeout           time.Duration
+	TLSHandshakeTimeout   time.Duration
+	ResponseHeaderTimeout time.Duration
+	IdleConnTimeout       time.Duration
+
+	MaxIdleConns int
+	// MaxIdleConnsPerHost defaults to DefaultMaxIdleConnsPerHost.
+	MaxIdleConnsPerHost int
+	// MaxConnsPerHost limits connections to the endpoint, zero means no limit.
+	MaxConnsPerHost int
+}
+
+// newHTTPClient builds the client for config. Files that cannot be read are reported as an error.
+func newHTTPClient(config TransportConfig) (*http.Client, error) {
+	timeout := config.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+
+	if config.RoundTripper != nil {
+		return &http.Client{Timeout: timeout, Transport: config.RoundTripper}, nil
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if config.ProxyURL != "" {
+		proxyURL, err := url.Parse(config.ProxyURL)
+		if err != nil {
+			return nil, fmt.Errorf("parse proxy url: %w", err)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if config.CAFile != "" || config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		tlsConfig, err := newTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if config.DialTimeout > 0 {
+		dialer := &net.Dialer{Timeout: config.DialTimeout, KeepAlive: 30 * time.Second}
+		transport.DialContext = dialer.DialContext
+	}
+	if config.TLSHandshakeTimeout > 0 {
+		transport.TLSHandshakeTimeout = config.TLSHandshakeTimeout
+	}
+	if config.ResponseHeaderTimeout > 0 {
+		transport.ResponseHeaderTimeout = config.ResponseHeaderTimeout
+	}
+	if config.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = config.IdleConnTimeout
+	}
+
+	if config.MaxIdleConns > 0 {
+		transport.MaxIdleConns = config.MaxIdleConns
+	}
+	transport.MaxIdleConnsPerHost = DefaultMaxIdleConnsPerHost
+	if config.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = config.MaxIdleConnsPerHost
+	}
+	transport.MaxConnsPerHost = config.MaxConnsPerHost
+
+	return &http.Client{Timeout: timeout, Transport: transport}, nil
+}
+
+func newTLSConfig(config TransportConfig) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+
+	if config.CAFile != "" {
+		pem, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("read ca file: %w", err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("ca file %s has no certificates", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if config.ClientCertFile != "" || config.ClientKeyFile != "" {
+		if config.ClientCertFile == "" || config.ClientKeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key must be set together")
+		}
+		certificate, err := tls.LoadX509KeyPair(config.ClientCertFile, config.ClientKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+
+	return tlsConfig, nil
+}
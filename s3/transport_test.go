@@ -0,0 +1,276 @@
+package s3_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fakes3 "github.com/bradenrayhorn/marmalade/internal/fake_s3"
+	"github.com/bradenrayhorn/marmalade/internal/testutils/assert"
+	"github.com/bradenrayhorn/marmalade/s3"
+)
+
+// writePEM writes a PEM block of type to a file in dir and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	path := filepath.Join(dir, name)
+	err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600)
+	assert.NoErr(t, err)
+	return path
+}
+
+// newClientCertificate creates a certificate authority and a client certificate signed by it. It
+// returns the authority and the paths of the client certificate and key.
+func newClientCertificate(t *testing.T) (*x509.CertPool, string, string) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoErr(t, err)
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "marmalade test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	assert.NoErr(t, err)
+	ca, err := x509.ParseCertificate(caDER)
+	assert.NoErr(t, err)
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.NoErr(t, err)
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "backup"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	assert.NoErr(t, err)
+	clientKeyDER, err := x509.MarshalPKCS8PrivateKey(clientKey)
+	assert.NoErr(t, err)
+
+	dir := t.TempDir()
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+	return pool, writePEM(t, dir, "client.crt", "CERTIFICATE", clientDER), writePEM(t, dir, "client.key", "PRIVATE KEY", clientKeyDER)
+}
+
+func setupTLSServer(t *testing.T, clientCAs *x509.CertPool) (*fakes3.FakeS3, string) {
+	sv := fakes3.NewFakeS3("my-bucket")
+	sv.SetNow(time.Now().UTC())
+	sv.StartTLSServer(clientCAs)
+	t.Cleanup(func() { sv.StopServer() })
+
+	caFile := writePEM(t, t.TempDir(), "ca.crt", "CERTIFICATE", sv.GetCertificate().Raw)
+	return sv, caFile
+}
+
+func TestTrustsCAFile(t *testing.T) {
+	sv, caFile := setupTLSServer(t, nil)
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Transport: s3.TransportConfig{CAFile: caFile},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, "abc", string(sv.GetVersions("my-file.txt")[0].Content))
+
+	// without the bundle the server is not trusted, and that is not retried
+	client = s3.NewClient(s3.Config{URL: sv.GetEndpoint(), Region: "my-region", KeyID: "keyid", KeySecret: "shh", Bucket: "my-bucket"})
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.ErrContains(t, err, "certificate")
+	assert.Equal(t, int64(0), client.Metrics().Retries)
+}
+
+func TestClientCertificate(t *testing.T) {
+	clientCAs, certFile, keyFile := newClientCertificate(t)
+	sv, caFile := setupTLSServer(t, clientCAs)
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Transport: s3.TransportConfig{CAFile: caFile, ClientCertFile: certFile, ClientKeyFile: keyFile},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+
+	// the server refuses connections without a certificate
+	client = s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Retry:     s3.RetryPolicy{MaxAttempts: 1},
+		Transport: s3.TransportConfig{CAFile: caFile},
+	})
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.ErrContains(t, err, "certificate")
+}
+
+func TestProxy(t *testing.T) {
+	sv, caFile := setupTLSServer(t, nil)
+
+	// a proxy that tunnels CONNECT requests
+	var tunnels atomic.Int64
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "only CONNECT is supported", http.StatusMethodNotAllowed)
+			return
+		}
+		tunnels.Add(1)
+
+		upstream, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer func() { _ = upstream.Close() }()
+
+		conn, _, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+		_, _ = conn.Write([]byte("HTTP/1.1 200 Connection established\r\n\r\n"))
+
+		var wg sync.WaitGroup
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(upstream, conn)
+			_ = upstream.(*net.TCPConn).CloseWrite()
+		}()
+		go func() {
+			defer wg.Done()
+			_, _ = io.Copy(conn, upstream)
+			_ = conn.(*net.TCPConn).CloseWrite()
+		}()
+		wg.Wait()
+	}))
+	t.Cleanup(proxy.Close)
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Transport: s3.TransportConfig{CAFile: caFile, ProxyURL: proxy.URL},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	_, err = client.ListObjectVersions("", "", "", 500)
+	assert.NoErr(t, err)
+
+	// both requests went through one kept-alive tunnel
+	assert.Equal(t, int64(1), tunnels.Load())
+}
+
+func TestResponseHeaderTimeout(t *testing.T) {
+	sv := setupSignedServer(t, "")
+	sv.SetInterceptor(func(r *http.Request, w http.ResponseWriter) bool {
+		time.Sleep(200 * time.Millisecond)
+		return false
+	})
+
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+		Retry:     s3.RetryPolicy{MaxAttempts: 2},
+		Transport: s3.TransportConfig{ResponseHeaderTimeout: 50 * time.Millisecond},
+	})
+
+	_, err := client.ListObjectVersions("", "", "", 500)
+	assert.ErrContains(t, err, "retries exceeded after 2 attempts")
+	assert.ErrContains(t, err, "timeout awaiting response headers")
+}
+
+func TestCustomRoundTripper(t *testing.T) {
+	sv := setupSignedServer(t, "")
+
+	var requests atomic.Int64
+	client := s3.NewClient(s3.Config{
+		URL:       sv.GetEndpoint(),
+		Region:    "my-region",
+		KeyID:     "keyid",
+		KeySecret: "shh",
+		Bucket:    "my-bucket",
+		Insecure:  true,
+		Transport: s3.TransportConfig{
+			RoundTripper: roundTripperFunc(func(r *http.Request) (*http.Response, error) {
+				requests.Add(1)
+				return http.DefaultTransport.RoundTrip(r)
+			}),
+			// ignored, as the round tripper is used as is
+			CAFile: "does-not-exist.crt",
+		},
+	})
+
+	err := client.PutObject("my-file.txt", bytes.NewReader([]byte("abc")), 3, nil)
+	assert.NoErr(t, err)
+	assert.Equal(t, int64(1), requests.Load())
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestInvalidTransport(t *testing.T) {
+	_, certFile, keyFile := newClientCertificate(t)
+	notPEM := filepath.Join(t.TempDir(), "ca.crt")
+	err := os.WriteFile(notPEM, []byte("not a certificate"), 0600)
+	assert.NoErr(t, err)
+
+	testCases := []struct {
+		transport s3.TransportConfig
+		error     string
+	}{
+		{s3.TransportConfig{CAFile: "does-not-exist.crt"}, "read ca file"},
+		{s3.TransportConfig{CAFile: notPEM}, "has no certificates"},
+		{s3.TransportConfig{ClientCertFile: certFile}, "client certificate and key must be set together"},
+		{s3.TransportConfig{ClientCertFile: keyFile, ClientKeyFile: certFile}, "load client certificate"},
+		{s3.TransportConfig{ProxyURL: "http://proxy:port"}, "parse proxy url"},
+	}
+
+	for _, tc := range testCases {
+		client := s3.NewClient(s3.Config{URL: "s3.example.com", Region: "my-region", Bucket: "my-bucket", KeyID: "keyid", KeySecret: "shh", Transport: tc.transport})
+		_, err := client.GetObject("my-file.txt")
+		assert.ErrContains(t, err, tc.error)
+	}
+}